@@ -0,0 +1,477 @@
+package pokedict
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+)
+
+const (
+	API_ROOT = "/api/v1/"
+
+	apiDefaultLimit = 20
+	apiMaxLimit     = 100
+	// 遊戲資料最快每 30 分鐘由 cron 同步一次，快取時間要比同步間隔短，
+	// 資料更新後最多五分鐘就會看到，過期後用 ETag 重新驗證，內容沒變只回 304
+	apiCacheControl = "public, max-age=300"
+)
+
+type apiError struct {
+	Status  int    `json:"-"`
+	Message string `json:"error"`
+}
+
+func (e *apiError) Error() string {
+	return e.Message
+}
+
+func apiBadRequest(format string, args ...interface{}) *apiError {
+	return &apiError{http.StatusBadRequest, fmt.Sprintf(format, args...)}
+}
+
+func apiNotFound(format string, args ...interface{}) *apiError {
+	return &apiError{http.StatusNotFound, fmt.Sprintf(format, args...)}
+}
+
+type apiPage struct {
+	Total  int         `json:"total"`
+	Offset int         `json:"offset"`
+	Limit  int         `json:"limit"`
+	Items  interface{} `json:"items"`
+}
+
+type apiSearchResult struct {
+	Pokemon []Pokemon      `json:"pokemon"`
+	Skills  []PokemonSkill `json:"skills"`
+}
+
+func init() {
+	http.HandleFunc(API_ROOT, apiHandler)
+}
+
+func apiIntParam(query url.Values, name string, def int) (int, error) {
+	v := query.Get(name)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, apiBadRequest("invalid %s: %s", name, v)
+	}
+	return n, nil
+}
+
+// offset 超過總數時回傳空的 items
+func apiPaginate(query url.Values, total int) (offset, limit int, err error) {
+	if offset, err = apiIntParam(query, "offset", 0); err != nil {
+		return
+	}
+	if limit, err = apiIntParam(query, "limit", apiDefaultLimit); err != nil {
+		return
+	}
+	if limit == 0 {
+		err = apiBadRequest("limit must be positive")
+		return
+	}
+	if limit > apiMaxLimit {
+		limit = apiMaxLimit
+	}
+	if offset > total {
+		offset = total
+	}
+	return
+}
+
+func pageEnd(offset, limit, total int) int {
+	if offset+limit > total {
+		return total
+	}
+	return offset + limit
+}
+
+func containsType(types []string, t string) bool {
+	for _, x := range types {
+		if normalizeType(x) == normalizeType(t) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasMove(p Pokemon, name string) bool {
+	for _, move := range append(append([]string{}, p.FastMoves...), p.ChargedMoves...) {
+		if skillKey(move) == skillKey(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// 可以用 q、type、weakness、move、min_cp、max_cp 篩選
+func apiListPokemon(d *GameData, query url.Values) (interface{}, error) {
+	minCP, err := apiIntParam(query, "min_cp", 0)
+	if err != nil {
+		return nil, err
+	}
+	maxCP, err := apiIntParam(query, "max_cp", 0)
+	if err != nil {
+		return nil, err
+	}
+	if maxCP != 0 && minCP > maxCP {
+		return nil, apiBadRequest("min_cp %d is greater than max_cp %d", minCP, maxCP)
+	}
+	q, t, weakness, move := query.Get("q"), query.Get("type"), query.Get("weakness"), query.Get("move")
+
+	monsters := []Pokemon{}
+	for _, m := range d.Monsters {
+		switch {
+		case !m.NameContains(q):
+		case t != "" && !containsType(pokemonTypes(m), t):
+		case weakness != "" && !containsType(m.Weaknesses, weakness):
+		case move != "" && !hasMove(m, move):
+		case minCP != 0 && m.MaxCP < int64(minCP):
+		case maxCP != 0 && m.MaxCP > int64(maxCP):
+		default:
+			monsters = append(monsters, m)
+		}
+	}
+	sort.Sort(monstersById(monsters))
+
+	offset, limit, err := apiPaginate(query, len(monsters))
+	if err != nil {
+		return nil, err
+	}
+	return apiPage{len(monsters), offset, limit, monsters[offset:pageEnd(offset, limit, len(monsters))]}, nil
+}
+
+// 可以用 q、type、kind (fast 或 charged) 篩選
+func apiListSkills(d *GameData, query url.Values) (interface{}, error) {
+	q, t, kind := query.Get("q"), query.Get("type"), query.Get("kind")
+	if kind != "" && kind != "fast" && kind != "charged" {
+		return nil, apiBadRequest("invalid kind: %s", kind)
+	}
+
+	skills := []PokemonSkill{}
+	for _, s := range d.Skills {
+		switch {
+		case !s.NameContains(q):
+		case t != "" && normalizeType(s.Type) != normalizeType(t):
+		case kind != "" && s.Kind != kind:
+		default:
+			skills = append(skills, s)
+		}
+	}
+	sort.Sort(skillsById(skills))
+
+	offset, limit, err := apiPaginate(query, len(skills))
+	if err != nil {
+		return nil, err
+	}
+	return apiPage{len(skills), offset, limit, skills[offset:pageEnd(offset, limit, len(skills))]}, nil
+}
+
+func apiGetPokemon(d *GameData, id string) (interface{}, error) {
+	n, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return nil, apiBadRequest("invalid id: %s", id)
+	}
+	m, ok := d.MonsterById(n)
+	if !ok {
+		return nil, apiNotFound("pokemon %d not found", n)
+	}
+	return m, nil
+}
+
+func apiGetSkill(d *GameData, id string) (interface{}, error) {
+	n, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return nil, apiBadRequest("invalid id: %s", id)
+	}
+	s, ok := d.SkillById(n)
+	if !ok {
+		return nil, apiNotFound("skill %d not found", n)
+	}
+	return s, nil
+}
+
+// 寵物和技能一起搜尋，各自最多 apiMaxLimit 筆
+func apiSearch(d *GameData, query url.Values) (interface{}, error) {
+	q := strings.TrimSpace(query.Get("q"))
+	if q == "" {
+		return nil, apiBadRequest("missing q")
+	}
+
+	result := apiSearchResult{Pokemon: []Pokemon{}, Skills: []PokemonSkill{}}
+	for _, m := range d.Monsters {
+		if m.NameContains(q) {
+			result.Pokemon = append(result.Pokemon, m)
+		}
+	}
+	for _, s := range d.Skills {
+		if s.NameContains(q) {
+			result.Skills = append(result.Skills, s)
+		}
+	}
+	sort.Sort(monstersById(result.Pokemon))
+	sort.Sort(skillsById(result.Skills))
+	if len(result.Pokemon) > apiMaxLimit {
+		result.Pokemon = result.Pokemon[:apiMaxLimit]
+	}
+	if len(result.Skills) > apiMaxLimit {
+		result.Skills = result.Skills[:apiMaxLimit]
+	}
+	return result, nil
+}
+
+func apiRoute(d *GameData, path string, query url.Values) (interface{}, error) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(path, API_ROOT), "/"), "/")
+	switch {
+	case len(parts) == 1 && parts[0] == "pokemon":
+		return apiListPokemon(d, query)
+	case len(parts) == 2 && parts[0] == "pokemon":
+		return apiGetPokemon(d, parts[1])
+	case len(parts) == 1 && parts[0] == "skills":
+		return apiListSkills(d, query)
+	case len(parts) == 2 && parts[0] == "skills":
+		return apiGetSkill(d, parts[1])
+	case len(parts) == 1 && parts[0] == "search":
+		return apiSearch(d, query)
+	case len(parts) == 1 && parts[0] == "openapi.json":
+		return json.RawMessage(openAPISpec), nil
+	}
+	return nil, apiNotFound("unknown path: %s", path)
+}
+
+func writeAPIError(w http.ResponseWriter, e *apiError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.Status)
+	json.NewEncoder(w).Encode(e)
+}
+
+// If-None-Match 可以是 * 或逗號分隔的多個 ETag，用弱比對所以忽略 W/ 前綴
+func etagMatches(header, etag string) bool {
+	header = strings.TrimSpace(header)
+	if header == "*" {
+		return true
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, t := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(t), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// 唯讀 API，內容只跟著遊戲資料變動，用內容的雜湊當 ETag
+func apiHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if r.Method != "GET" && r.Method != "HEAD" {
+		writeAPIError(w, &apiError{http.StatusMethodNotAllowed, "method not allowed"})
+		return
+	}
+	d := loadGameData(ctx)
+	if !d.loaded() {
+		writeAPIError(w, &apiError{http.StatusServiceUnavailable, "game data is not loaded"})
+		return
+	}
+
+	v, err := apiRoute(d, r.URL.Path, r.URL.Query())
+	if err != nil {
+		if e, ok := err.(*apiError); ok {
+			writeAPIError(w, e)
+		} else {
+			log.Errorf(ctx, "api %s: %s", r.URL, err)
+			writeAPIError(w, &apiError{http.StatusInternalServerError, err.Error()})
+		}
+		return
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		log.Errorf(ctx, "api %s: %s", r.URL, err)
+		writeAPIError(w, &apiError{http.StatusInternalServerError, err.Error()})
+		return
+	}
+
+	etag := fmt.Sprintf(`"%x"`, sha1.Sum(b))
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", apiCacheControl)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if r.Method == "HEAD" {
+		return
+	}
+	w.Write(b)
+}
+
+const openAPISpec = `{
+  "openapi": "3.0.0",
+  "info": {
+    "title": "PokéDict API",
+    "version": "1.0.0",
+    "description": "Read-only access to the Pokémon and move data used by the PokéDict bots."
+  },
+  "servers": [{"url": "/api/v1"}],
+  "paths": {
+    "/pokemon": {
+      "get": {
+        "summary": "List Pokémon",
+        "parameters": [
+          {"$ref": "#/components/parameters/q"},
+          {"name": "type", "in": "query", "description": "Pokémon type, e.g. Dragon", "schema": {"type": "string"}},
+          {"name": "weakness", "in": "query", "description": "Type the Pokémon is weak to", "schema": {"type": "string"}},
+          {"name": "move", "in": "query", "description": "English name of a fast or charged move it can learn", "schema": {"type": "string"}},
+          {"name": "min_cp", "in": "query", "schema": {"type": "integer", "minimum": 0}},
+          {"name": "max_cp", "in": "query", "description": "Must not be less than min_cp", "schema": {"type": "integer", "minimum": 0}},
+          {"$ref": "#/components/parameters/offset"},
+          {"$ref": "#/components/parameters/limit"}
+        ],
+        "responses": {
+          "200": {"description": "A page of Pokémon ordered by id", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/PokemonPage"}}}},
+          "400": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/pokemon/{id}": {
+      "get": {
+        "summary": "Get a Pokémon by Pokédex number",
+        "parameters": [{"$ref": "#/components/parameters/id"}],
+        "responses": {
+          "200": {"description": "The Pokémon", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Pokemon"}}}},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/skills": {
+      "get": {
+        "summary": "List moves",
+        "parameters": [
+          {"$ref": "#/components/parameters/q"},
+          {"name": "type", "in": "query", "description": "Move type, e.g. Fire", "schema": {"type": "string"}},
+          {"name": "kind", "in": "query", "schema": {"type": "string", "enum": ["fast", "charged"]}},
+          {"$ref": "#/components/parameters/offset"},
+          {"$ref": "#/components/parameters/limit"}
+        ],
+        "responses": {
+          "200": {"description": "A page of moves ordered by id", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/SkillPage"}}}},
+          "400": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/skills/{id}": {
+      "get": {
+        "summary": "Get a move by id",
+        "parameters": [{"$ref": "#/components/parameters/id"}],
+        "responses": {
+          "200": {"description": "The move", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/PokemonSkill"}}}},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/search": {
+      "get": {
+        "summary": "Search Pokémon and moves by name in any supported language",
+        "parameters": [{"name": "q", "in": "query", "required": true, "schema": {"type": "string"}}],
+        "responses": {
+          "200": {
+            "description": "Matching Pokémon and moves, at most 100 of each",
+            "content": {"application/json": {"schema": {
+              "type": "object",
+              "properties": {
+                "pokemon": {"type": "array", "items": {"$ref": "#/components/schemas/Pokemon"}},
+                "skills": {"type": "array", "items": {"$ref": "#/components/schemas/PokemonSkill"}}
+              }
+            }}}
+          },
+          "400": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    }
+  },
+  "components": {
+    "parameters": {
+      "id": {"name": "id", "in": "path", "required": true, "schema": {"type": "integer"}},
+      "q": {"name": "q", "in": "query", "description": "Part of the English, Chinese or Japanese name", "schema": {"type": "string"}},
+      "offset": {"name": "offset", "in": "query", "schema": {"type": "integer", "minimum": 0, "default": 0}},
+      "limit": {"name": "limit", "in": "query", "schema": {"type": "integer", "minimum": 1, "maximum": 100, "default": 20}}
+    },
+    "responses": {
+      "Error": {
+        "description": "Error",
+        "content": {"application/json": {"schema": {"type": "object", "properties": {"error": {"type": "string"}}}}}
+      }
+    },
+    "schemas": {
+      "Pokemon": {
+        "type": "object",
+        "properties": {
+          "Id": {"type": "integer"},
+          "Classification": {"type": "string"},
+          "Name": {"type": "string"},
+          "Cname": {"type": "string", "description": "Traditional Chinese name"},
+          "Scname": {"type": "string", "description": "Simplified Chinese name"},
+          "Jname": {"type": "string", "description": "Japanese name"},
+          "MaxCP": {"type": "integer"},
+          "Type I": {"type": "string"},
+          "Type II": {"type": "string"},
+          "Weaknesses": {"type": "array", "items": {"type": "string"}},
+          "Fast Attack(s)": {"type": "array", "items": {"type": "string"}},
+          "Special Attack(s)": {"type": "array", "items": {"type": "string"}},
+          "BaseAttack": {"type": "integer"},
+          "BaseDefense": {"type": "integer"},
+          "BaseStamina": {"type": "integer"}
+        }
+      },
+      "PokemonSkill": {
+        "type": "object",
+        "properties": {
+          "Id": {"type": "integer"},
+          "Kind": {"type": "string", "enum": ["fast", "charged"]},
+          "Type": {"type": "string"},
+          "Name": {"type": "string"},
+          "Cname": {"type": "string"},
+          "Scname": {"type": "string"},
+          "Jname": {"type": "string"},
+          "Damage": {"type": "number"},
+          "Cooldown": {"type": "number"},
+          "Energy": {"type": "number"},
+          "Dps": {"type": "number"},
+          "Dpe": {"type": "number"},
+          "Eps": {"type": "number"}
+        }
+      },
+      "PokemonPage": {
+        "type": "object",
+        "properties": {
+          "total": {"type": "integer"},
+          "offset": {"type": "integer"},
+          "limit": {"type": "integer"},
+          "items": {"type": "array", "items": {"$ref": "#/components/schemas/Pokemon"}}
+        }
+      },
+      "SkillPage": {
+        "type": "object",
+        "properties": {
+          "total": {"type": "integer"},
+          "offset": {"type": "integer"},
+          "limit": {"type": "integer"},
+          "items": {"type": "array", "items": {"$ref": "#/components/schemas/PokemonSkill"}}
+        }
+      }
+    }
+  }
+}`
@@ -0,0 +1,268 @@
+package pokedict
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestETagMatches(t *testing.T) {
+	const etag = `"3f2a"`
+	tests := []struct {
+		header string
+		match  bool
+	}{
+		{`"3f2a"`, true},
+		{`W/"3f2a"`, true},
+		{`"1111", "3f2a"`, true},
+		{`"1111",W/"3f2a"`, true},
+		{`*`, true},
+		{` * `, true},
+		{``, false},
+		{`"1111"`, false},
+		{`3f2a`, false},
+		{`"3f2a`, false},
+	}
+	for _, test := range tests {
+		if got := etagMatches(test.header, etag); got != test.match {
+			t.Errorf("%q: got %v, want %v", test.header, got, test.match)
+		}
+	}
+}
+
+func apiTestGameData(t *testing.T) *GameData {
+	skills := []PokemonSkill{
+		{Id: 12, Kind: "fast", Type: "Water", Name: "Water Gun", Cname: "水槍", Damage: 6, Cooldown: 0.5, Energy: 7},
+		{Id: 200, Kind: "fast", Type: "Dark", Name: "Bite", Cname: "咬住", Damage: 6, Cooldown: 0.5, Energy: 4},
+		{Id: 1016, Kind: "charged", Type: "Water", Name: "Hydro Pump", Cname: "水砲", Damage: 90, Cooldown: 3.8, Energy: 90},
+		{Id: 1023, Kind: "charged", Type: "Ice", Name: "Blizzard", Cname: "暴風雪", Damage: 100, Cooldown: 3.9, Energy: 100},
+	}
+	monsters := []Pokemon{
+		{Id: 7, Name: "Squirtle", Cname: "傑尼龜", MaxCP: 720, TypeI: "Water", Weaknesses: []string{"Electric", "Grass"},
+			FastMoves: []string{"Water Gun"}, ChargedMoves: []string{"Hydro Pump"}},
+		{Id: 9, Name: "Blastoise", Cname: "水箭龜", MaxCP: 2542, TypeI: "Water", Weaknesses: []string{"Electric", "Grass"},
+			FastMoves: []string{"Water Gun", "Bite"}, ChargedMoves: []string{"Hydro Pump", "Blizzard"}},
+		{Id: 131, Name: "Lapras", Cname: "拉普拉斯", MaxCP: 2980, TypeI: "Water", TypeII: "Ice", Weaknesses: []string{"Electric", "Grass", "Fighting", "Rock"},
+			FastMoves: []string{"Water Gun"}, ChargedMoves: []string{"Blizzard"}},
+		{Id: 144, Name: "Articuno", Cname: "急凍鳥", MaxCP: 2978, TypeI: "Ice", TypeII: "Flying", Weaknesses: []string{"Fire", "Electric", "Rock", "Steel"},
+			ChargedMoves: []string{"Blizzard"}},
+	}
+	d, err := newGameData(testCtx, skills, monsters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+type apiTestResponse struct {
+	code int
+	etag string
+	body []byte
+}
+
+func apiGet(t *testing.T, url, ifNoneMatch string) apiTestResponse {
+	r := newTestRequest(t, "GET", url, nil)
+	if ifNoneMatch != "" {
+		r.Header.Set("If-None-Match", ifNoneMatch)
+	}
+	w := httptest.NewRecorder()
+	apiHandler(w, r)
+	return apiTestResponse{w.Code, w.Header().Get("ETag"), w.Body.Bytes()}
+}
+
+// 回傳列表裡的 id
+func apiPageIds(t *testing.T, resp apiTestResponse) (total int, ids []int64) {
+	var page struct {
+		Total int
+		Items []struct{ Id int64 }
+	}
+	if err := json.Unmarshal(resp.body, &page); err != nil {
+		t.Fatalf("%s: %s", resp.body, err)
+	}
+	ids = []int64{}
+	for _, item := range page.Items {
+		ids = append(ids, item.Id)
+	}
+	return page.Total, ids
+}
+
+func TestAPIHandler(t *testing.T) {
+	defer gameData.Store(currentGameData())
+	gameData.Store(apiTestGameData(t))
+
+	lists := []struct {
+		url   string
+		total int
+		ids   []int64
+	}{
+		{"/api/v1/pokemon", 4, []int64{7, 9, 131, 144}},
+		{"/api/v1/pokemon/", 4, []int64{7, 9, 131, 144}},
+		{"/api/v1/pokemon?q=龜", 2, []int64{7, 9}},
+		{"/api/v1/pokemon?type=ice", 2, []int64{131, 144}},
+		{"/api/v1/pokemon?type=Water&weakness=fight", 1, []int64{131}},
+		{"/api/v1/pokemon?weakness=Fire", 1, []int64{144}},
+		{"/api/v1/pokemon?move=hydro%20pump", 2, []int64{7, 9}},
+		{"/api/v1/pokemon?move=Blizzard&type=Water", 2, []int64{9, 131}},
+		{"/api/v1/pokemon?min_cp=2542", 3, []int64{9, 131, 144}},
+		{"/api/v1/pokemon?max_cp=2542", 2, []int64{7, 9}},
+		{"/api/v1/pokemon?min_cp=2000&max_cp=2979", 2, []int64{9, 144}},
+		{"/api/v1/pokemon?min_cp=2980&max_cp=2980", 1, []int64{131}},
+		{"/api/v1/pokemon?type=Fire", 0, []int64{}},
+		{"/api/v1/pokemon?limit=2", 4, []int64{7, 9}},
+		{"/api/v1/pokemon?offset=3&limit=2", 4, []int64{144}},
+		{"/api/v1/pokemon?offset=10", 4, []int64{}},
+		{"/api/v1/skills", 4, []int64{12, 200, 1016, 1023}},
+		{"/api/v1/skills?kind=fast", 2, []int64{12, 200}},
+		{"/api/v1/skills?kind=charged&type=water", 1, []int64{1016}},
+		{"/api/v1/skills?q=水", 2, []int64{12, 1016}},
+		{"/api/v1/skills?offset=1&limit=1", 4, []int64{200}},
+	}
+	for _, test := range lists {
+		resp := apiGet(t, test.url, "")
+		if resp.code != http.StatusOK {
+			t.Errorf("%s: got status %d: %s", test.url, resp.code, resp.body)
+			continue
+		}
+		total, ids := apiPageIds(t, resp)
+		if total != test.total || fmt.Sprint(ids) != fmt.Sprint(test.ids) {
+			t.Errorf("%s: got %d %v, want %d %v", test.url, total, ids, test.total, test.ids)
+		}
+	}
+
+	// limit 超過上限時用上限
+	var page apiPage
+	json.Unmarshal(apiGet(t, "/api/v1/pokemon?limit=1000", "").body, &page)
+	if page.Limit != apiMaxLimit {
+		t.Errorf("got limit %d, want %d", page.Limit, apiMaxLimit)
+	}
+
+	var m Pokemon
+	if resp := apiGet(t, "/api/v1/pokemon/131", ""); resp.code != http.StatusOK || json.Unmarshal(resp.body, &m) != nil || m.Name != "Lapras" {
+		t.Errorf("get pokemon: got %d %s", resp.code, resp.body)
+	}
+	var s PokemonSkill
+	if resp := apiGet(t, "/api/v1/skills/1016", ""); resp.code != http.StatusOK || json.Unmarshal(resp.body, &s) != nil || s.Name != "Hydro Pump" || s.Dpe != 1 {
+		t.Errorf("get skill: got %d %s", resp.code, resp.body)
+	}
+	var result apiSearchResult
+	if resp := apiGet(t, "/api/v1/search?q=水", ""); resp.code != http.StatusOK || json.Unmarshal(resp.body, &result) != nil || len(result.Pokemon) != 1 || len(result.Skills) != 2 {
+		t.Errorf("search: got %d %s", resp.code, resp.body)
+	}
+
+	errors := []struct {
+		url  string
+		code int
+	}{
+		{"/api/v1/pokemon?limit=0", http.StatusBadRequest},
+		{"/api/v1/pokemon?limit=-1", http.StatusBadRequest},
+		{"/api/v1/pokemon?offset=x", http.StatusBadRequest},
+		{"/api/v1/pokemon?min_cp=abc", http.StatusBadRequest},
+		{"/api/v1/pokemon?min_cp=3000&max_cp=2000", http.StatusBadRequest},
+		{"/api/v1/skills?kind=special", http.StatusBadRequest},
+		{"/api/v1/pokemon/abc", http.StatusBadRequest},
+		{"/api/v1/skills/abc", http.StatusBadRequest},
+		{"/api/v1/search", http.StatusBadRequest},
+		{"/api/v1/search?q=%20", http.StatusBadRequest},
+		{"/api/v1/pokemon/999", http.StatusNotFound},
+		{"/api/v1/skills/999", http.StatusNotFound},
+		{"/api/v1/pokemon/7/moves", http.StatusNotFound},
+		{"/api/v1/items", http.StatusNotFound},
+		{"/api/v1/", http.StatusNotFound},
+	}
+	for _, test := range errors {
+		resp := apiGet(t, test.url, "")
+		var e apiError
+		if resp.code != test.code || json.Unmarshal(resp.body, &e) != nil || e.Message == "" {
+			t.Errorf("%s: got %d %s, want %d", test.url, resp.code, resp.body, test.code)
+		}
+	}
+
+	r := newTestRequest(t, "POST", "/api/v1/pokemon", nil)
+	w := httptest.NewRecorder()
+	apiHandler(w, r)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: got status %d", w.Code)
+	}
+}
+
+func TestAPINotModified(t *testing.T) {
+	defer gameData.Store(currentGameData())
+	gameData.Store(apiTestGameData(t))
+
+	resp := apiGet(t, "/api/v1/pokemon?type=Water", "")
+	if resp.code != http.StatusOK || resp.etag == "" {
+		t.Fatalf("got %d with ETag %q", resp.code, resp.etag)
+	}
+	if again := apiGet(t, "/api/v1/pokemon?type=Water", resp.etag); again.code != http.StatusNotModified || len(again.body) != 0 || again.etag != resp.etag {
+		t.Errorf("got %d %q with ETag %q, want 304", again.code, again.body, again.etag)
+	}
+	// 內容不同時 ETag 也不同
+	if other := apiGet(t, "/api/v1/pokemon?type=Ice", resp.etag); other.code != http.StatusOK || other.etag == resp.etag {
+		t.Errorf("different content: got %d with ETag %q", other.code, other.etag)
+	}
+}
+
+// 文件裡的每個路徑都要有對應的 handler，$ref 都要找得到
+func TestOpenAPISpec(t *testing.T) {
+	defer gameData.Store(currentGameData())
+	gameData.Store(apiTestGameData(t))
+
+	resp := apiGet(t, "/api/v1/openapi.json", "")
+	if resp.code != http.StatusOK {
+		t.Fatalf("got status %d", resp.code)
+	}
+	var spec map[string]interface{}
+	if err := json.Unmarshal(resp.body, &spec); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(fmt.Sprint(spec["openapi"]), "3.") {
+		t.Errorf("got openapi version %v", spec["openapi"])
+	}
+
+	var checkRefs func(v interface{})
+	checkRefs = func(v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			if ref, ok := v["$ref"].(string); ok {
+				var target interface{} = spec
+				for _, name := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+					obj, _ := target.(map[string]interface{})
+					target = obj[name]
+				}
+				if target == nil {
+					t.Errorf("unresolved $ref %s", ref)
+				}
+			}
+			for _, x := range v {
+				checkRefs(x)
+			}
+		case []interface{}:
+			for _, x := range v {
+				checkRefs(x)
+			}
+		}
+	}
+	checkRefs(spec)
+
+	paths, _ := spec["paths"].(map[string]interface{})
+	if len(paths) == 0 {
+		t.Fatal("no paths")
+	}
+	examples := map[string]string{
+		"/pokemon/{id}": "/pokemon/9",
+		"/skills/{id}":  "/skills/1016",
+		"/search":       "/search?q=a",
+	}
+	for path := range paths {
+		url := "/api/v1" + path
+		if example, ok := examples[path]; ok {
+			url = "/api/v1" + example
+		}
+		if resp := apiGet(t, url, ""); resp.code != http.StatusOK {
+			t.Errorf("%s: got status %d", path, resp.code)
+		}
+	}
+}
@@ -0,0 +1,323 @@
+package pokedict
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+const (
+	battleTimeLimit = 100 * time.Second
+	dodgeDuration   = 500 * time.Millisecond
+	// 閃避成功只會受到四分之一的傷害
+	dodgeDamageRate = 0.25
+	maxEnergy       = 100
+)
+
+type Combatant struct {
+	Pokemon Pokemon
+	Fast    PokemonSkill
+	Charged PokemonSkill
+	Level   float64
+	IV      int64
+	// 對手放充能技能時是否閃避
+	Dodge bool
+}
+
+type BattleTurn struct {
+	Time     time.Duration
+	Attacker int
+	Move     PokemonSkill
+	Damage   float64
+	Dodged   bool
+	// 防守方受到攻擊後剩下的 HP
+	DefenderHP float64
+}
+
+type BattleResult struct {
+	// 0 或 1 表示勝方，-1 表示時間到仍未分出勝負
+	Winner   int
+	HP       [2]float64
+	MaxHP    [2]float64
+	Duration time.Duration
+	Turns    []BattleTurn
+}
+
+type battleSide struct {
+	Combatant
+	stats  Stats
+	hp     float64
+	energy float64
+
+	move   *PokemonSkill
+	moveAt time.Duration
+	dodged bool
+	// 目前動作結束的時間
+	busyUntil time.Duration
+}
+
+func skillDuration(s PokemonSkill) time.Duration {
+	return time.Duration(s.Cooldown * float64(time.Second))
+}
+
+func chargedCost(s PokemonSkill) float64 {
+	return s.Energy
+}
+
+func energyCap(s PokemonSkill) float64 {
+	return math.Max(maxEnergy, chargedCost(s))
+}
+
+// 沒有指定的招式，挑對這個對手最有效的
+func (c *Combatant) chooseMoves(opponent Combatant) {
+	atk := pokemonStats(c.Pokemon, c.Level, c.IV)
+	def := pokemonStats(opponent.Pokemon, opponent.Level, opponent.IV)
+	fast, charged := c.Fast, c.Charged
+	bestDps := 0.0
+	for _, ms := range pokemonMovesets(c.Pokemon) {
+		if fast.Name != "" && ms.Fast.Id != fast.Id || charged.Name != "" && ms.Charged.Id != charged.Id {
+			continue
+		}
+		if dps := movesetDps(c.Pokemon, atk, ms, opponent.Pokemon, def); dps > bestDps {
+			bestDps = dps
+			c.Fast, c.Charged = ms.Fast, ms.Charged
+		}
+	}
+}
+
+// Simulate 逐步模擬兩隻寵物的對戰，結果是固定的 (相同的輸入必定得到相同的結果)
+func Simulate(a, b Combatant) (result BattleResult) {
+	sides := [2]*battleSide{{Combatant: a}, {Combatant: b}}
+	for i, s := range sides {
+		s.stats = pokemonStats(s.Pokemon, s.Level, s.IV)
+		s.hp = s.stats.Stamina
+		result.MaxHP[i] = s.hp
+	}
+
+	result.Winner = -1
+	now := time.Duration(0)
+	for now < battleTimeLimit {
+		for i, s := range sides {
+			if s.move != nil {
+				continue
+			}
+			move := &s.Fast
+			if s.Charged.Energy > 0 && s.energy >= chargedCost(s.Charged) {
+				move = &s.Charged
+			}
+			d := skillDuration(*move)
+			if d <= 0 {
+				d = time.Second
+			}
+			s.move = move
+			s.moveAt = s.busyUntil + d
+			s.dodged = false
+
+			// 對手看到充能技能就閃避，閃避時自己的動作也會延後
+			other := sides[1-i]
+			if move == &s.Charged && other.Dodge {
+				s.dodged = true
+				if other.move != nil {
+					other.moveAt += dodgeDuration
+				} else {
+					other.busyUntil += dodgeDuration
+				}
+			}
+		}
+
+		attacker := 0
+		if sides[1].moveAt < sides[0].moveAt {
+			attacker = 1
+		}
+		s, other := sides[attacker], sides[1-attacker]
+		now = s.moveAt
+		if now > battleTimeLimit {
+			now = battleTimeLimit
+			break
+		}
+
+		move := *s.move
+		dmg := damage(move.Damage, s.stats.Attack, other.stats.Defense, moveMultiplier(s.Pokemon, move, other.Pokemon))
+		if s.dodged {
+			dmg = math.Floor(dmg*dodgeDamageRate) + 1
+		}
+
+		if s.move == &s.Charged {
+			s.energy -= chargedCost(move)
+		} else {
+			s.energy = math.Min(s.energy+move.Energy, energyCap(s.Charged))
+		}
+		other.hp = math.Max(other.hp-dmg, 0)
+		other.energy = math.Min(other.energy+math.Ceil(dmg/2), energyCap(other.Charged))
+
+		result.Turns = append(result.Turns, BattleTurn{
+			Time:       now,
+			Attacker:   attacker,
+			Move:       move,
+			Damage:     dmg,
+			Dodged:     s.dodged,
+			DefenderHP: other.hp,
+		})
+
+		s.move = nil
+		s.busyUntil = now
+		if other.hp <= 0 {
+			result.Winner = attacker
+			break
+		}
+	}
+
+	result.Duration = now
+	for i, s := range sides {
+		result.HP[i] = s.hp
+	}
+	return
+}
+
+// 模擬指令裡一方的設定，Words 是寵物名稱和招式名稱
+type battleSideArgs struct {
+	Words []string
+	Dodge bool
+	Level float64
+	IV    int64
+}
+
+// 解析「模擬 快龍 龍之息 lv30 閃避 vs 卡比獸 iv15」，名稱後面可以接招式。
+// 等級與 IV 寫在哪一方就套用在哪一方，只有一方指定時雙方都用同樣的值
+func parseBattleArgs(args string) (sides [2]battleSideArgs, ok bool, err error) {
+	sides[0].IV, sides[1].IV = -1, -1
+	side := 0
+	for _, field := range strings.Fields(args) {
+		lower := strings.ToLower(field)
+		switch {
+		case lower == "vs" || lower == "vs.":
+			side = 1
+		case lower == "閃避" || lower == "dodge":
+			sides[side].Dodge = true
+		case strings.HasPrefix(lower, "lv") && isNumeric(lower[2:]):
+			if sides[side].Level, err = parseLevel(lower[2:]); err != nil {
+				return
+			}
+		case strings.HasPrefix(lower, "iv") && isNumeric(lower[2:]):
+			if sides[side].IV, err = parseIV(lower[2:]); err != nil {
+				return
+			}
+		default:
+			sides[side].Words = append(sides[side].Words, field)
+		}
+	}
+
+	for i := range sides {
+		s, other := &sides[i], sides[1-i]
+		if s.Level == 0 {
+			s.Level = other.Level
+		}
+		if s.Level == 0 {
+			s.Level = defaultCounterOptions.Level
+		}
+		if s.IV < 0 {
+			s.IV = other.IV
+		}
+		if s.IV < 0 {
+			s.IV = defaultCounterOptions.IV
+		}
+	}
+	ok = side == 1 && len(sides[0].Words) != 0 && len(sides[1].Words) != 0
+	return
+}
+
+// 最長一段能找到寵物的字當作名稱，剩下的是招式，招式必須是這隻寵物會的
+func battleCombatant(ctx context.Context, lang string, args battleSideArgs) (c Combatant, returnText string) {
+	c = Combatant{Level: args.Level, IV: args.IV, Dodge: args.Dodge}
+	n := len(args.Words)
+	for ; n > 0; n-- {
+		if m, ok := findMonster(ctx, strings.Join(args.Words[:n], " ")); ok {
+			c.Pokemon = m
+			break
+		}
+	}
+	if n == 0 {
+		returnText = tr(lang, "monster_not_found", strings.Join(args.Words, " "))
+		return
+	}
+
+	skills, unknown := parseSkillNames(strings.Join(args.Words[n:], " "))
+	if len(unknown) != 0 {
+		returnText = tr(lang, "compare_unknown", strings.Join(unknown, ", "))
+		return
+	}
+	for _, s := range skills {
+		if !hasMove(c.Pokemon, s.Name) {
+			returnText = tr(lang, "battle_unknown_move", c.Pokemon.LocalName(lang), s.LocalName(lang))
+			return
+		}
+		if s.Kind == "charged" {
+			c.Charged = s
+		} else {
+			c.Fast = s
+		}
+	}
+	return
+}
+
+func formatBattle(lang string, a, b Combatant, result BattleResult, maxTurns int) string {
+	combatants := [2]Combatant{a, b}
+	buf := bytes.NewBuffer([]byte{})
+	fmt.Fprintf(buf, "%s Lv%g IV%d (%s / %s) vs %s Lv%g IV%d (%s / %s)\n",
+		a.Pokemon.LocalName(lang), a.Level, a.IV, a.Fast.LocalName(lang), a.Charged.LocalName(lang),
+		b.Pokemon.LocalName(lang), b.Level, b.IV, b.Fast.LocalName(lang), b.Charged.LocalName(lang))
+	if result.Winner < 0 {
+		fmt.Fprintf(buf, "%s\n", tr(lang, "battle_draw",
+			result.HP[0], result.MaxHP[0], result.HP[1], result.MaxHP[1]))
+	} else {
+		w := result.Winner
+		fmt.Fprintf(buf, "%s\n", tr(lang, "battle_win",
+			combatants[w].Pokemon.LocalName(lang), result.HP[w], result.MaxHP[w], result.Duration.Seconds()))
+	}
+
+	turns := result.Turns
+	if maxTurns > 0 && len(turns) > maxTurns {
+		fmt.Fprintf(buf, "%s\n", tr(lang, "battle_skipped", len(turns)-maxTurns))
+		turns = turns[len(turns)-maxTurns:]
+	}
+	for _, t := range turns {
+		dodged := ""
+		if t.Dodged {
+			dodged = tr(lang, "battle_dodged")
+		}
+		fmt.Fprintf(buf, "%s\n", tr(lang, "battle_turn", t.Time.Seconds(),
+			combatants[t.Attacker].Pokemon.LocalName(lang), t.Move.LocalName(lang), t.Damage, dodged, t.DefenderHP))
+	}
+	return buf.String()
+}
+
+func battleResponse(ctx context.Context, lang, args string, maxTurns int) string {
+	sides, ok, err := parseBattleArgs(args)
+	if err != nil {
+		return argsErrorText(lang, err)
+	}
+	if !ok {
+		return tr(lang, "battle_usage")
+	}
+
+	var combatants [2]Combatant
+	for i, side := range sides {
+		c, returnText := battleCombatant(ctx, lang, side)
+		if returnText != "" {
+			return returnText
+		}
+		combatants[i] = c
+	}
+
+	a, b := combatants[0], combatants[1]
+	a.chooseMoves(combatants[1])
+	b.chooseMoves(combatants[0])
+	if a.Fast.Name == "" || b.Fast.Name == "" {
+		return tr(lang, "no_usable_move")
+	}
+	return formatBattle(lang, a, b, Simulate(a, b), maxTurns)
+}
@@ -0,0 +1,84 @@
+package pokedict
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseBattleArgs(t *testing.T) {
+	def := defaultCounterOptions
+	tests := []struct {
+		args   string
+		words  [2]string
+		levels [2]float64
+		ivs    [2]int64
+		dodge  [2]bool
+		ok     bool
+	}{
+		{"快龍 vs 卡比獸", [2]string{"快龍", "卡比獸"}, [2]float64{def.Level, def.Level}, [2]int64{def.IV, def.IV}, [2]bool{}, true},
+		// 只有一方指定時雙方都用同樣的值
+		{"快龍 vs 卡比獸 lv30 iv10", [2]string{"快龍", "卡比獸"}, [2]float64{30, 30}, [2]int64{10, 10}, [2]bool{}, true},
+		{"快龍 lv30 閃避 vs 卡比獸 lv25 iv0", [2]string{"快龍", "卡比獸"}, [2]float64{30, 25}, [2]int64{0, 0}, [2]bool{true, false}, true},
+		{"Dragonite Dragon Breath Hyper Beam vs Mr. Mime dodge", [2]string{"Dragonite Dragon Breath Hyper Beam", "Mr. Mime"}, [2]float64{def.Level, def.Level}, [2]int64{def.IV, def.IV}, [2]bool{false, true}, true},
+		{"快龍", [2]string{"快龍", ""}, [2]float64{def.Level, def.Level}, [2]int64{def.IV, def.IV}, [2]bool{}, false},
+		{"快龍 vs lv30", [2]string{"快龍", ""}, [2]float64{30, 30}, [2]int64{def.IV, def.IV}, [2]bool{}, false},
+	}
+	for _, test := range tests {
+		sides, ok, err := parseBattleArgs(test.args)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", test.args, err)
+			continue
+		}
+		for i, s := range sides {
+			if strings.Join(s.Words, " ") != test.words[i] || s.Level != test.levels[i] || s.IV != test.ivs[i] || s.Dodge != test.dodge[i] {
+				t.Errorf("%q side %d: got %+v", test.args, i, s)
+			}
+		}
+		if ok != test.ok {
+			t.Errorf("%q: got ok %v, want %v", test.args, ok, test.ok)
+		}
+	}
+
+	for _, args := range []string{"快龍 lv0 vs 卡比獸", "快龍 vs 卡比獸 iv16", "快龍 lvNaN vs 卡比獸"} {
+		if _, _, err := parseBattleArgs(args); err == nil {
+			t.Errorf("%q: expected error", args)
+		}
+	}
+}
+
+func TestBattleCombatant(t *testing.T) {
+	lang := LANG_EN
+	tests := []struct {
+		args    battleSideArgs
+		monster string
+		fast    string
+		charged string
+		text    string
+	}{
+		{battleSideArgs{Words: []string{"Dragonite"}}, "Dragonite", "", "", ""},
+		{battleSideArgs{Words: []string{"快龍", "龍之息", "破壞死光"}}, "Dragonite", "Dragon Breath", "Hyper Beam", ""},
+		{battleSideArgs{Words: strings.Fields("Mr. Mime Psychic")}, "Mr. Mime", "", "Psychic", ""},
+		{battleSideArgs{Words: strings.Fields("Snorlax Dragon Breath")}, "", "", "", tr(lang, "battle_unknown_move", "Snorlax", "Dragon Breath")},
+		{battleSideArgs{Words: strings.Fields("Snorlax Nothing")}, "", "", "", tr(lang, "compare_unknown", "Nothing")},
+		{battleSideArgs{Words: strings.Fields("NoSuchMonster")}, "", "", "", tr(lang, "monster_not_found", "NoSuchMonster")},
+	}
+	for _, test := range tests {
+		c, text := battleCombatant(testCtx, lang, test.args)
+		if text != test.text {
+			t.Errorf("%v: got %q, want %q", test.args.Words, text, test.text)
+			continue
+		}
+		if text == "" && (c.Pokemon.Name != test.monster || c.Fast.Name != test.fast || c.Charged.Name != test.charged) {
+			t.Errorf("%v: got %s (%s / %s)", test.args.Words, c.Pokemon.Name, c.Fast.Name, c.Charged.Name)
+		}
+	}
+}
+
+func TestBattleResponseMoves(t *testing.T) {
+	text := battleResponse(testCtx, LANG_EN, "Dragonite Steel Wing lv30 vs Snorlax Lick Body Slam iv10", 0)
+	header := strings.SplitN(text, "\n", 2)[0]
+	want := "Dragonite Lv30 IV10 (Steel Wing / "
+	if !strings.HasPrefix(header, want) || !strings.Contains(header, "Snorlax Lv30 IV10 (Lick / Body Slam)") {
+		t.Errorf("got header %q", header)
+	}
+}
@@ -0,0 +1,234 @@
+package pokedict
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/log"
+)
+
+// 快速回覆的選項，使用者點選後會收到 Payload
+type ReplyChoice struct {
+	Title   string
+	Payload string
+}
+
+// Reply 是平台無關的回覆，由 fbSendReply、lineSendReply 轉成各平台的訊息
+type Reply struct {
+	Texts []string
+	// 卡片的格式和 generateTemplateElements 的 item 相同，Title 是 LINE 的替代文字
+	Title string
+	Cards []map[string]interface{}
+	// 附在最後一則文字上的快速回覆
+	Choices     []ReplyChoice
+	AskLocation bool
+}
+
+func textReply(texts ...string) Reply {
+	return Reply{Texts: texts}
+}
+
+// Messenger 和 LINE 共用的指令處理，只修改 user 並回傳要送出的回覆
+type commandRouter struct {
+	// 沒有進行中的動作時，無法辨識的文字當作這個動作處理，空字串表示回覆看不懂
+	defaultAction string
+	// 開始比較久的查詢前呼叫，例如顯示輸入中
+	busy func()
+}
+
+func (r commandRouter) text(ctx context.Context, user *User, text string) (reply Reply) {
+	lang := userLanguage(user)
+	q := strings.ToLower(text)
+	cmd, args := splitCommand(text)
+	if cmd != "" {
+		q = cmd
+	}
+
+	switch q {
+	case "get started", "hi", "hello", "你好", "您好":
+		user.TodoAction = ""
+		reply = textReply(welcomeText(user))
+	case "查技", "查技能", "技能", "skill":
+		user.TodoAction = "QUERY_SKILL"
+		reply = textReply(tr(lang, "ask_skill"))
+	case "查寵", "查寵物", "寵物", "pokemon", "mon":
+		user.TodoAction = "QUERY_MONSTER"
+		reply = textReply(tr(lang, "ask_monster"))
+	case "搜怪", "找怪", "找稀有怪":
+		user.TodoAction = "FIND_MONSTER"
+		reply = askLocationReply(lang)
+	case "COUNTER":
+		user.TodoAction = ""
+		reply = counterReply(ctx, lang, args)
+	case "SIMULATE":
+		user.TodoAction = ""
+		reply = textReply(battleResponse(ctx, lang, args, 8))
+	case "COMPARE":
+		user.TodoAction = ""
+		reply = compareReply(ctx, lang, args)
+	case "TEAM":
+		user.TodoAction = ""
+		reply = textReply(teamResponse(ctx, lang, args))
+	case "時區", "timezone":
+		user.TodoAction = "SET_TIMEZONE"
+		reply = textReply(tr(lang, "ask_timezone", userLocation(user).String()))
+	case "走路", "walk":
+		user.TravelMode = TRAVEL_WALK
+		reply = textReply(tr(lang, "travel_mode_set", travelModeName(lang, TRAVEL_WALK)))
+	case "騎車", "bike":
+		user.TravelMode = TRAVEL_BIKE
+		reply = textReply(tr(lang, "travel_mode_set", travelModeName(lang, TRAVEL_BIKE)))
+	case "語言", "语言", "言語", "language":
+		user.TodoAction = "SET_LANGUAGE"
+		reply = askLanguageReply(lang)
+	default:
+		action := user.TodoAction
+		switch action {
+		case "QUERY_MONSTER", "QUERY_SKILL", "SET_TIMEZONE", "SET_LANGUAGE":
+		default:
+			user.TodoAction = ""
+			action = r.defaultAction
+		}
+
+		switch action {
+		case "QUERY_MONSTER":
+			reply = monstersReply(ctx, lang, queryMonster(ctx, text))
+		case "QUERY_SKILL":
+			skills := querySkill(ctx, text)
+			if len(skills) > 6 {
+				reply = textReply(tr(lang, "too_many_results"))
+			} else {
+				reply = textReply(formatSkills(lang, skills))
+			}
+		case "SET_TIMEZONE":
+			if err := setUserTimezone(user, strings.TrimSpace(text)); err != nil {
+				reply = textReply(tr(lang, "unknown_timezone"))
+			} else {
+				user.TodoAction = ""
+				reply = textReply(tr(lang, "timezone_set", user.Timezone))
+			}
+		case "SET_LANGUAGE":
+			reply = textReply(setUserLanguage(user, text))
+			if user.Language != "" {
+				user.TodoAction = ""
+			}
+		default:
+			reply = textReply(tr(lang, "not_understand"))
+		}
+	}
+	user.LastText = text
+	return
+}
+
+// 處理選單、按鈕和快速回覆送回來的 payload
+func (r commandRouter) payload(ctx context.Context, user *User, payload string) (reply Reply) {
+	lang := userLanguage(user)
+	payloadItems := strings.SplitN(payload, ":", 2)
+
+	switch payloadItems[0] {
+	case "GET_STARTED":
+		user.TodoAction = ""
+		reply = textReply(welcomeText(user))
+	case "QUERY_MONSTER":
+		user.TodoAction = "QUERY_MONSTER"
+		reply = textReply(tr(lang, "ask_monster"))
+	case "QUERY_SKILL":
+		user.TodoAction = "QUERY_SKILL"
+		reply = textReply(tr(lang, "ask_skill"))
+	case "FIND_MONSTER":
+		user.TodoAction = "FIND_MONSTER"
+		// 選單的 FIND_MONSTER 沒有座標，要先問位置
+		if len(payloadItems) == 1 {
+			reply = askLocationReply(lang)
+			break
+		}
+		lat, lng, ok := parseFindMonsterPayload(payload)
+		if !ok {
+			log.Errorf(ctx, "FIND_MONSTER postback arguments error: %q", payload)
+			reply = textReply(tr(lang, "query_error"))
+			break
+		}
+		reply = r.monsterPins(ctx, user, lat, lng)
+	case "KIDDING":
+		reply = textReply(tr(lang, "kidding"))
+	case "SET_LANGUAGE":
+		if len(payloadItems) == 2 {
+			user.TodoAction = ""
+			reply = textReply(setUserLanguage(user, payloadItems[1]))
+		}
+	case "QUERY_MONSTER_SKILL":
+		if len(payloadItems) != 2 {
+			break
+		}
+		mId, err := strconv.ParseInt(payloadItems[1], 10, 64)
+		monster, ok := loadGameData(ctx).MonsterById(mId)
+		if err != nil || !ok {
+			log.Errorf(ctx, "QUERY_MONSTER_SKILL postback arguments error: %q", payload)
+			reply = textReply(tr(lang, "query_error"))
+			break
+		}
+		fastMoves, chargedMoves := monsterMoves(ctx, monster)
+		reply = textReply(
+			tr(lang, "querying_skills", monster.LocalName(lang)),
+			formatMonsterSkills(lang, monster, fastMoves, chargedMoves),
+		)
+	default:
+		user.TodoAction = ""
+	}
+	return
+}
+
+// 使用者傳來位置時，正在找怪就直接查，否則先確認
+func (r commandRouter) location(ctx context.Context, user *User, lat, long float64) Reply {
+	updateUserTimezone(user, lat, long)
+	if user.TodoAction == "FIND_MONSTER" {
+		return r.monsterPins(ctx, user, lat, long)
+	}
+
+	lang := userLanguage(user)
+	return Reply{
+		Texts: []string{tr(lang, "confirm_find_monster")},
+		Choices: []ReplyChoice{
+			{Title: tr(lang, "yes"), Payload: fmt.Sprintf("FIND_MONSTER:%f,%f", lat, long)},
+			{Title: tr(lang, "no"), Payload: "KIDDING"},
+		},
+	}
+}
+
+func (r commandRouter) monsterPins(ctx context.Context, user *User, lat, long float64) Reply {
+	// 雷達查詢要好幾秒，先讓使用者知道有在處理
+	if r.busy != nil {
+		r.busy()
+	}
+	monsterPins, returnText := monsterPinsNear(ctx, user, lat, long)
+	if returnText != "" {
+		return textReply(returnText)
+	}
+	return Reply{
+		Title: tr(userLanguage(user), "rare_nearby"),
+		Cards: getMonsterPinElements(ctx, monsterPins, user),
+	}
+}
+
+func askLocationReply(lang string) Reply {
+	return Reply{Texts: []string{tr(lang, "ask_location")}, AskLocation: true}
+}
+
+func askLanguageReply(lang string) Reply {
+	reply := textReply(tr(lang, "ask_language"))
+	for _, l := range languages {
+		reply.Choices = append(reply.Choices, ReplyChoice{Title: languageNames[l], Payload: "SET_LANGUAGE:" + l})
+	}
+	return reply
+}
+
+func monstersReply(ctx context.Context, lang string, monsters []Pokemon) Reply {
+	if l := len(monsters); l == 0 {
+		return textReply(tr(lang, "no_monster_found"))
+	} else if l > 6 {
+		return textReply(tr(lang, "too_many_results"))
+	}
+	return Reply{Title: tr(lang, "monsters_found"), Cards: monsterItems(ctx, lang, monsters)}
+}
@@ -0,0 +1,93 @@
+package pokedict
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCommandRouterText(t *testing.T) {
+	fb := commandRouter{}
+	line := commandRouter{defaultAction: "QUERY_SKILL"}
+
+	tests := []struct {
+		name   string
+		router commandRouter
+		todo   string
+		text   string
+		check  func(user *User, reply Reply) bool
+	}{
+		{"ask skill", fb, "", "查技能", func(user *User, reply Reply) bool {
+			return user.TodoAction == "QUERY_SKILL" && reply.Texts[0] == tr(DEFAULT_LANGUAGE, "ask_skill")
+		}},
+		{"find monster asks location", line, "", "找怪", func(user *User, reply Reply) bool {
+			return user.TodoAction == "FIND_MONSTER" && reply.AskLocation
+		}},
+		{"language choices", fb, "", "language", func(user *User, reply Reply) bool {
+			return user.TodoAction == "SET_LANGUAGE" && len(reply.Choices) == len(languages)
+		}},
+		{"unknown text on messenger", fb, "", "Water Gun", func(user *User, reply Reply) bool {
+			return reply.Texts[0] == tr(DEFAULT_LANGUAGE, "not_understand")
+		}},
+		// LINE 沒有固定選單，其他文字都當作查技能
+		{"unknown text on line", line, "", "Water Gun", func(user *User, reply Reply) bool {
+			return strings.Contains(reply.Texts[0], "Water Gun")
+		}},
+		{"unknown text while finding monsters", line, "FIND_MONSTER", "Water Gun", func(user *User, reply Reply) bool {
+			return user.TodoAction == "" && strings.Contains(reply.Texts[0], "Water Gun")
+		}},
+		{"skill query keeps going", fb, "QUERY_SKILL", "Water Gun", func(user *User, reply Reply) bool {
+			return user.TodoAction == "QUERY_SKILL" && strings.Contains(reply.Texts[0], "Water Gun")
+		}},
+		// 替代文字不能直接用使用者輸入的內容
+		{"compare", fb, "", "compare Water Gun, Bite", func(user *User, reply Reply) bool {
+			return reply.Title == tr(DEFAULT_LANGUAGE, "compare_title") && len(reply.Cards) == 2
+		}},
+	}
+	for _, test := range tests {
+		user := &User{TodoAction: test.todo}
+		reply := test.router.text(testCtx, user, test.text)
+		if len(reply.Texts) == 0 && len(reply.Cards) == 0 || !test.check(user, reply) {
+			t.Errorf("%s: unexpected reply %+v (todo %q)", test.name, reply, user.TodoAction)
+		}
+		if user.LastText != test.text {
+			t.Errorf("%s: last text is %q", test.name, user.LastText)
+		}
+	}
+}
+
+func TestCommandRouterPayload(t *testing.T) {
+	router := commandRouter{}
+	tests := []struct {
+		payload string
+		todo    string
+		text    string
+		ask     bool
+	}{
+		{"QUERY_MONSTER", "QUERY_MONSTER", tr(DEFAULT_LANGUAGE, "ask_monster"), false},
+		{"FIND_MONSTER", "FIND_MONSTER", tr(DEFAULT_LANGUAGE, "ask_location"), true},
+		{"FIND_MONSTER:25.03", "FIND_MONSTER", tr(DEFAULT_LANGUAGE, "query_error"), false},
+		{"SET_LANGUAGE:en", "", tr(LANG_EN, "language_set", languageNames[LANG_EN]), false},
+		{"QUERY_MONSTER_SKILL:abc", "", tr(DEFAULT_LANGUAGE, "query_error"), false},
+		{"KIDDING", "", tr(DEFAULT_LANGUAGE, "kidding"), false},
+	}
+	for _, test := range tests {
+		user := &User{}
+		reply := router.payload(testCtx, user, test.payload)
+		if len(reply.Texts) == 0 || reply.Texts[0] != test.text || reply.AskLocation != test.ask || user.TodoAction != test.todo {
+			t.Errorf("%q: unexpected reply %+v (todo %q)", test.payload, reply, user.TodoAction)
+		}
+	}
+
+	user := &User{}
+	reply := router.location(testCtx, user, 25.033964, 121.564468)
+	if len(reply.Choices) != 2 || reply.Choices[0].Payload != "FIND_MONSTER:25.033964,121.564468" {
+		t.Errorf("unexpected location reply %+v", reply)
+	}
+}
+
+func TestLineFlexAltText(t *testing.T) {
+	carousel := lineFlexCarousel(strings.Repeat("水", 1000), nil)
+	if n := len([]rune(carousel["altText"].(string))); n != lineMaxAltText {
+		t.Errorf("got alt text of %d characters, want %d", n, lineMaxAltText)
+	}
+}
@@ -0,0 +1,121 @@
+package pokedict
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+func deriveSkillStats(skill *PokemonSkill) {
+	if skill.Energy > 0 {
+		skill.Dpe = skill.Damage / skill.Energy
+	}
+	if skill.Cooldown > 0 {
+		skill.Eps = skill.Energy / skill.Cooldown
+	}
+}
+
+// 技能名稱可能有空白，盡量取最長的一段符合的名稱
+func parseSkillNames(args string) (skills []PokemonSkill, unknown []string) {
+	fields := strings.Fields(strings.Replace(args, ",", " ", -1))
+	for i := 0; i < len(fields); {
+		matched := 0
+		for j := len(fields); j > i; j-- {
+			if s, ok := skillByName(strings.Join(fields[i:j], " ")); ok {
+				skills = append(skills, s)
+				matched = j - i
+				break
+			}
+		}
+		if matched == 0 {
+			unknown = append(unknown, fields[i])
+			matched = 1
+		}
+		i += matched
+	}
+	return
+}
+
+func skillKindName(lang string, s PokemonSkill) string {
+	if s.Kind == "charged" {
+		return tr(lang, "skill_kind_charged")
+	}
+	return tr(lang, "skill_kind_fast")
+}
+
+func formatSkillTable(skills []PokemonSkill) string {
+	rows := []struct {
+		label string
+		value func(s PokemonSkill) string
+	}{
+		{"Name", func(s PokemonSkill) string { return s.Name }},
+		{"Type", func(s PokemonSkill) string { return s.Type }},
+		{"Kind", func(s PokemonSkill) string { return s.Kind }},
+		{"Damage", func(s PokemonSkill) string { return fmt.Sprintf("%.0f", s.Damage) }},
+		{"Cooldown", func(s PokemonSkill) string { return fmt.Sprintf("%.2fs", s.Cooldown) }},
+		{"Energy", func(s PokemonSkill) string { return fmt.Sprintf("%.0f", s.Energy) }},
+		{"DPS", func(s PokemonSkill) string { return fmt.Sprintf("%.2f", s.Dps) }},
+		{"DPE", func(s PokemonSkill) string { return fmt.Sprintf("%.2f", s.Dpe) }},
+		{"EPS", func(s PokemonSkill) string { return fmt.Sprintf("%.2f", s.Eps) }},
+	}
+
+	width := len("Cooldown")
+	for _, s := range skills {
+		if len(s.Name) > width {
+			width = len(s.Name)
+		}
+	}
+
+	buf := bytes.NewBuffer([]byte{})
+	for _, row := range rows {
+		fmt.Fprintf(buf, "%-9s", row.label)
+		for _, s := range skills {
+			fmt.Fprintf(buf, " %-*s", width, row.value(s))
+		}
+		buf.WriteString("\n")
+	}
+	return buf.String()
+}
+
+func compareSkills(ctx context.Context, lang, args string) (skills []PokemonSkill, returnText string) {
+	loadGameData(ctx)
+	skills, unknown := parseSkillNames(args)
+	if len(unknown) != 0 {
+		returnText = tr(lang, "compare_unknown", strings.Join(unknown, ", "))
+	} else if len(skills) < 2 {
+		returnText = tr(lang, "compare_usage")
+	}
+	return
+}
+
+// 表格用等寬字排版，只放英文名稱
+func tgCompareResponse(ctx context.Context, chatId int64, lang, args string) error {
+	skills, returnText := compareSkills(ctx, lang, args)
+	if returnText != "" {
+		return tgSendTextMessage(ctx, chatId, returnText)
+	}
+	return tgSendMessage(ctx, chatId, "<pre>"+html.EscapeString(formatSkillTable(skills))+"</pre>", "HTML")
+}
+
+func compareItems(lang string, skills []PokemonSkill) []map[string]interface{} {
+	items := []map[string]interface{}{}
+	for _, s := range skills {
+		items = append(items, map[string]interface{}{
+			"title": s.DisplayName(lang),
+			"subtitle": tr(lang, "compare_subtitle",
+				typeName(lang, s.Type), skillKindName(lang, s), s.Damage, s.Cooldown, s.Energy, s.Dps, s.Dpe, s.Eps),
+		})
+	}
+	return items
+}
+
+func compareReply(ctx context.Context, lang, args string) Reply {
+	skills, returnText := compareSkills(ctx, lang, args)
+	if returnText != "" {
+		return textReply(returnText)
+	}
+	return Reply{Title: tr(lang, "compare_title"), Cards: compareItems(lang, skills)}
+}
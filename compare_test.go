@@ -0,0 +1,123 @@
+package pokedict
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParseSkillNames(t *testing.T) {
+	loadGameData(testCtx)
+	tests := []struct {
+		args    string
+		ids     []int64
+		unknown []string
+	}{
+		{"Hydro Pump Blizzard", []int64{1016, 1023}, nil},
+		{"hydro pump, blizzard", []int64{1016, 1023}, nil},
+		{"Ice Beam Ice Punch Water Gun", []int64{1024, 1025, 12}, nil},
+		{"水砲 暴風雪", []int64{1016, 1023}, nil},
+		{"Hydro Pump Foo Blizzard", []int64{1016, 1023}, []string{"Foo"}},
+		{"Hydro Blizzard", []int64{1023}, []string{"Hydro"}},
+		{"", nil, nil},
+	}
+	for _, test := range tests {
+		skills, unknown := parseSkillNames(test.args)
+		ids := []int64{}
+		for _, s := range skills {
+			ids = append(ids, s.Id)
+		}
+		if fmt.Sprint(ids) != fmt.Sprint(test.ids) || fmt.Sprint(unknown) != fmt.Sprint(test.unknown) {
+			t.Errorf("%q: got %v %v, want %v %v", test.args, ids, unknown, test.ids, test.unknown)
+		}
+	}
+
+	if _, returnText := compareSkills(testCtx, DEFAULT_LANGUAGE, "Hydro Pump Foo"); returnText != tr(DEFAULT_LANGUAGE, "compare_unknown", "Foo") {
+		t.Errorf("unknown names should be reported, got %q", returnText)
+	}
+	if _, returnText := compareSkills(testCtx, DEFAULT_LANGUAGE, "Hydro Pump"); returnText != tr(DEFAULT_LANGUAGE, "compare_usage") {
+		t.Errorf("one skill is not enough to compare, got %q", returnText)
+	}
+}
+
+func TestDeriveSkillStats(t *testing.T) {
+	tests := []struct {
+		skill    PokemonSkill
+		dpe, eps float64
+	}{
+		{PokemonSkill{Damage: 90, Energy: 90, Cooldown: 3.8}, 1, 90 / 3.8},
+		{PokemonSkill{Damage: 45, Energy: 135, Cooldown: 3.5}, 45.0 / 135, 135 / 3.5},
+		// Splash 沒有傷害
+		{PokemonSkill{Damage: 0, Energy: 7, Cooldown: 1.23}, 0, 7 / 1.23},
+		// 沒有能量或冷卻時間的資料時不能除以零
+		{PokemonSkill{Damage: 12, Energy: 0, Cooldown: 1.1}, 0, 0},
+		{PokemonSkill{Damage: 12, Energy: 7, Cooldown: 0}, 12.0 / 7, 0},
+	}
+	for _, test := range tests {
+		s := test.skill
+		deriveSkillStats(&s)
+		if s.Dpe != test.dpe || s.Eps != test.eps {
+			t.Errorf("%+v: got DPE %v EPS %v, want %v %v", test.skill, s.Dpe, s.Eps, test.dpe, test.eps)
+		}
+	}
+
+	// 載入資料時就算好
+	s, _ := skillByName("Hydro Pump")
+	if s.Dpe != 1 || s.Eps != 90/3.8 {
+		t.Errorf("loaded Hydro Pump has DPE %v EPS %v", s.Dpe, s.Eps)
+	}
+}
+
+func TestFormatSkillTable(t *testing.T) {
+	loadGameData(testCtx)
+	skills, _ := parseSkillNames("Water Gun Hydro Pump Dragon Breath")
+	lines := strings.Split(strings.TrimSuffix(formatSkillTable(skills), "\n"), "\n")
+	if len(lines) != 9 {
+		t.Fatalf("got %d rows: %q", len(lines), lines)
+	}
+
+	// 每一欄的開頭都要對齊
+	header := lines[0]
+	columns := []int{}
+	for _, s := range skills {
+		columns = append(columns, strings.Index(header, s.Name))
+	}
+	for _, line := range lines {
+		if len(line) != len(header) {
+			t.Errorf("row %q has length %d, want %d", line, len(line), len(header))
+		}
+		for i, c := range columns {
+			if line[c-1] != ' ' || line[c] == ' ' {
+				t.Errorf("column %d of row %q is not aligned", i, line)
+			}
+		}
+	}
+	if fields := strings.Fields(lines[7]); len(fields) != 4 || fields[0] != "DPE" || fields[2] != "1.00" {
+		t.Errorf("Hydro Pump DPE is missing: %q", lines[7])
+	}
+}
+
+// 副標題超過 80 個字會被截掉，DPE 和 EPS 就看不到了
+func TestCompareCardLength(t *testing.T) {
+	game := loadGameData(testCtx)
+	skills := []PokemonSkill{}
+	for _, s := range game.Skills {
+		skills = append(skills, s)
+	}
+	for _, lang := range languages {
+		for _, item := range compareItems(lang, skills) {
+			if n := len([]rune(item["title"].(string))); n > fbMaxTitle {
+				t.Errorf("%s: title %q has %d characters", lang, item["title"], n)
+			}
+			if n := len([]rune(item["subtitle"].(string))); n > fbMaxSubtitle {
+				t.Errorf("%s: subtitle %q has %d characters", lang, item["subtitle"], n)
+			}
+		}
+	}
+
+	skills, _ = parseSkillNames("Hydro Pump")
+	subtitle := compareItems(LANG_EN, skills)[0]["subtitle"].(string)
+	if want := "Water·charged 90dmg 3.8s 90E / DPS 23.7 DPE 1.0 EPS 23.7"; subtitle != want {
+		t.Errorf("got subtitle %q, want %q", subtitle, want)
+	}
+}
@@ -0,0 +1,256 @@
+package pokedict
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+type CounterOptions struct {
+	Level     float64
+	IV        int64
+	BossLevel float64
+	// 0 表示用 Boss 自己的體力
+	BossHP float64
+	Top    int
+}
+
+var defaultCounterOptions CounterOptions = CounterOptions{
+	Level:     30,
+	IV:        15,
+	BossLevel: 40,
+	Top:       6,
+}
+
+type Moveset struct {
+	Fast    PokemonSkill
+	Charged PokemonSkill
+}
+
+type Counter struct {
+	Pokemon     Pokemon
+	Moveset     Moveset
+	Dps         float64
+	TimeToWin   float64
+	DamageTaken float64
+	Faints      float64
+	Score       float64
+}
+
+func pokemonMovesets(p Pokemon) []Moveset {
+	movesets := []Moveset{}
+	for _, fastName := range p.FastMoves {
+		fast, ok := skillByName(fastName)
+		if !ok {
+			continue
+		}
+		for _, chargedName := range p.ChargedMoves {
+			charged, ok := skillByName(chargedName)
+			if !ok {
+				continue
+			}
+			movesets = append(movesets, Moveset{fast, charged})
+		}
+	}
+	return movesets
+}
+
+// 用快速技能集氣，集滿就放充能技能，以一個循環計算平均 DPS
+func movesetDps(attacker Pokemon, atk Stats, ms Moveset, defender Pokemon, def Stats) float64 {
+	if ms.Fast.Cooldown <= 0 {
+		return 0
+	}
+	fastDamage := damage(ms.Fast.Damage, atk.Attack, def.Defense, moveMultiplier(attacker, ms.Fast, defender))
+	if ms.Fast.Energy <= 0 || ms.Charged.Energy <= 0 {
+		return fastDamage / ms.Fast.Cooldown
+	}
+
+	n := math.Ceil(ms.Charged.Energy / ms.Fast.Energy)
+	chargedDamage := damage(ms.Charged.Damage, atk.Attack, def.Defense, moveMultiplier(attacker, ms.Charged, defender))
+	cycle := n*ms.Fast.Cooldown + ms.Charged.Cooldown
+	return (n*fastDamage + chargedDamage) / cycle
+}
+
+func bestMoveset(attacker Pokemon, atk Stats, defender Pokemon, def Stats) (best Moveset, bestDps float64) {
+	for _, ms := range pokemonMovesets(attacker) {
+		if dps := movesetDps(attacker, atk, ms, defender, def); dps > bestDps {
+			best, bestDps = ms, dps
+		}
+	}
+	return
+}
+
+type countersByScore []Counter
+
+func (c countersByScore) Len() int           { return len(c) }
+func (c countersByScore) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+func (c countersByScore) Less(i, j int) bool { return c[i].Score > c[j].Score }
+
+// 以 DPS³ x TDO 排名：打得快又撐得久的排前面
+func rankCounters(boss Pokemon, opts CounterOptions) []Counter {
+	bossStats := pokemonStats(boss, opts.BossLevel, MAX_IV)
+	bossHP := opts.BossHP
+	if bossHP <= 0 {
+		bossHP = bossStats.Stamina
+	}
+
+	counters := []Counter{}
+	for _, p := range currentGameData().Monsters {
+		atk := pokemonStats(p, opts.Level, opts.IV)
+		ms, dps := bestMoveset(p, atk, boss, bossStats)
+		if dps <= 0 {
+			continue
+		}
+
+		_, bossDps := bestMoveset(boss, bossStats, p, atk)
+		if bossDps < 0.1 {
+			bossDps = 0.1
+		}
+
+		timeToWin := bossHP / dps
+		taken := bossDps * timeToWin
+		tdo := dps * atk.Stamina / bossDps
+		counters = append(counters, Counter{
+			Pokemon:     p,
+			Moveset:     ms,
+			Dps:         dps,
+			TimeToWin:   timeToWin,
+			DamageTaken: taken,
+			Faints:      taken / atk.Stamina,
+			Score:       dps * dps * dps * tdo,
+		})
+	}
+
+	sort.Sort(countersByScore(counters))
+	if opts.Top > 0 && len(counters) > opts.Top {
+		counters = counters[:opts.Top]
+	}
+	return counters
+}
+
+// 解析「打 快龍 lv20 iv10」之類的參數
+var (
+	errInvalidLevel = errors.New("invalid level")
+	errInvalidIV    = errors.New("invalid iv")
+)
+
+// 等級以 0.5 為單位，NaN 和 Inf 都不接受
+func parseLevel(s string) (float64, error) {
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || !(v >= MIN_LEVEL && v <= MAX_LEVEL) || math.Mod(v*2, 1) != 0 {
+		return 0, errInvalidLevel
+	}
+	return v, nil
+}
+
+func parseIV(s string) (int64, error) {
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || v < 0 || v > MAX_IV {
+		return 0, errInvalidIV
+	}
+	return v, nil
+}
+
+// lv 或 iv 後面接的不是數字時當成名稱的一部分，是數字但超出範圍時回傳錯誤
+func parseCounterArgs(args string) (bossName string, opts CounterOptions, err error) {
+	opts = defaultCounterOptions
+	names := []string{}
+	for _, field := range strings.Fields(args) {
+		lower := strings.ToLower(field)
+		if strings.HasPrefix(lower, "lv") && isNumeric(lower[2:]) {
+			if opts.Level, err = parseLevel(lower[2:]); err != nil {
+				return
+			}
+			continue
+		} else if strings.HasPrefix(lower, "iv") && isNumeric(lower[2:]) {
+			if opts.IV, err = parseIV(lower[2:]); err != nil {
+				return
+			}
+			continue
+		}
+		names = append(names, field)
+	}
+	bossName = strings.Join(names, " ")
+	return
+}
+
+// ParseFloat 也接受 nan、inf 這類字，這裡用來判斷使用者是不是想輸入數字
+func isNumeric(s string) bool {
+	_, err := strconv.ParseFloat(s, 64)
+	return err == nil
+}
+
+func argsErrorText(lang string, err error) string {
+	if err == errInvalidIV {
+		return tr(lang, "invalid_iv", MAX_IV)
+	}
+	return tr(lang, "invalid_level", MIN_LEVEL, MAX_LEVEL)
+}
+
+func formatCounters(lang string, boss Pokemon, counters []Counter) string {
+	if len(counters) == 0 {
+		return tr(lang, "nothing_found")
+	}
+	buf := bytes.NewBuffer([]byte{})
+	fmt.Fprintf(buf, "%s\n", tr(lang, "counters_title", boss.DisplayName(lang)))
+	for i, c := range counters {
+		fmt.Fprintf(buf, "%d) %s\n-> %s / %s\n-> %s\n",
+			i+1, c.Pokemon.DisplayName(lang), c.Moveset.Fast.LocalName(lang), c.Moveset.Charged.LocalName(lang),
+			tr(lang, "counter_detail", c.TimeToWin, c.DamageTaken, c.Faints))
+	}
+	return buf.String()
+}
+
+func counterItems(lang string, counters []Counter) []map[string]interface{} {
+	items := []map[string]interface{}{}
+	for _, c := range counters {
+		m := c.Pokemon
+		items = append(items, map[string]interface{}{
+			"title": m.DisplayName(lang),
+			"subtitle": fmt.Sprintf("%s / %s\n%s", c.Moveset.Fast.LocalName(lang), c.Moveset.Charged.LocalName(lang),
+				tr(lang, "counter_detail", c.TimeToWin, c.DamageTaken, c.Faints)),
+			"image_url": fmt.Sprintf("http://pgwave.com/assets/images/pokemon/3d-h120/%d.png", m.Id),
+			"buttons": []FBButtonItem{
+				FBButtonItem{
+					Type:    "postback",
+					Title:   tr(lang, "show_skills"),
+					Payload: fmt.Sprintf("QUERY_MONSTER_SKILL:%d", m.Id),
+				},
+			},
+		})
+	}
+	return items
+}
+
+func counterResult(ctx context.Context, lang, args string) (boss Pokemon, counters []Counter, returnText string) {
+	bossName, opts, err := parseCounterArgs(args)
+	if err != nil {
+		returnText = argsErrorText(lang, err)
+		return
+	}
+	boss, ok := findMonster(ctx, bossName)
+	if !ok {
+		returnText = tr(lang, "no_monster_found")
+		return
+	}
+	counters = rankCounters(boss, opts)
+	if len(counters) == 0 {
+		returnText = tr(lang, "nothing_found")
+	}
+	return
+}
+
+func counterReply(ctx context.Context, lang, args string) Reply {
+	boss, counters, returnText := counterResult(ctx, lang, args)
+	if returnText != "" {
+		return textReply(returnText)
+	}
+	title := tr(lang, "counters_title", boss.DisplayName(lang))
+	return Reply{Texts: []string{title}, Title: title, Cards: counterItems(lang, counters)}
+}
@@ -0,0 +1,37 @@
+package pokedict
+
+import "testing"
+
+func TestParseCounterArgs(t *testing.T) {
+	tests := []struct {
+		args  string
+		name  string
+		level float64
+		iv    int64
+		err   error
+	}{
+		{"快龍", "快龍", 30, 15, nil},
+		{"快龍 lv25 iv10", "快龍", 25, 10, nil},
+		{"Mr. Mime LV30.5", "Mr. Mime", 30.5, 15, nil},
+		{"快龍 lvnan", "", 0, 0, errInvalidLevel},
+		{"快龍 lvinf", "", 0, 0, errInvalidLevel},
+		{"快龍 lv-Inf", "", 0, 0, errInvalidLevel},
+		{"快龍 lv0", "", 0, 0, errInvalidLevel},
+		{"快龍 lv41", "", 0, 0, errInvalidLevel},
+		{"快龍 lv30.3", "", 0, 0, errInvalidLevel},
+		{"快龍 iv16", "", 0, 0, errInvalidIV},
+		{"快龍 iv-1", "", 0, 0, errInvalidIV},
+		// 不是數字的 lv、iv 當成名稱的一部分
+		{"lvl iv", "lvl iv", 30, 15, nil},
+	}
+	for _, test := range tests {
+		name, opts, err := parseCounterArgs(test.args)
+		if err != test.err {
+			t.Errorf("%q: got error %v, want %v", test.args, err, test.err)
+			continue
+		}
+		if err == nil && (name != test.name || opts.Level != test.level || opts.IV != test.iv) {
+			t.Errorf("%q: got (%q, lv%v, iv%d), want (%q, lv%v, iv%d)", test.args, name, opts.Level, opts.IV, test.name, test.level, test.iv)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package pokedict
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/memcache"
+)
+
+// Facebook 最久會重送約 8 小時
+const dedupTTL = 12 * time.Hour
+
+// DedupStore 記錄已經收過的 webhook 事件，避免重送時重複回覆
+type DedupStore interface {
+	// Claim 在 key 第一次出現時回傳 true
+	Claim(ctx context.Context, key string, ttl time.Duration) (bool, error)
+	// Release 放掉 key，讓之後的重送可以再被處理
+	Release(ctx context.Context, key string) error
+}
+
+type LocalDedupStore struct {
+	mu        sync.Mutex
+	expiresAt map[string]time.Time
+	lastSweep time.Time
+}
+
+func NewLocalDedupStore() *LocalDedupStore {
+	return &LocalDedupStore{expiresAt: map[string]time.Time{}}
+}
+
+func (s *LocalDedupStore) Claim(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.lastSweep) > ttl {
+		for k, t := range s.expiresAt {
+			if now.After(t) {
+				delete(s.expiresAt, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	if t, ok := s.expiresAt[key]; ok && now.Before(t) {
+		return false, nil
+	}
+	s.expiresAt[key] = now.Add(ttl)
+	return true, nil
+}
+
+func (s *LocalDedupStore) Release(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.expiresAt, key)
+	return nil
+}
+
+// memcache 的 Add 在 key 已存在時會失敗，可以在多個 instance 之間去重
+// memcache 無法使用時改用 instance 內的紀錄
+type MemcacheDedupStore struct {
+	Fallback DedupStore
+}
+
+func (s MemcacheDedupStore) Claim(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	err := memcache.Add(ctx, &memcache.Item{
+		Key:        "dedup:" + key,
+		Value:      []byte{1},
+		Expiration: ttl,
+	})
+	switch err {
+	case nil:
+		return true, nil
+	case memcache.ErrNotStored:
+		return false, nil
+	}
+	log.Warningf(ctx, "memcache dedup: %s", err)
+	return s.Fallback.Claim(ctx, key, ttl)
+}
+
+func (s MemcacheDedupStore) Release(ctx context.Context, key string) error {
+	s.Fallback.Release(ctx, key)
+	err := memcache.Delete(ctx, "dedup:"+key)
+	if err == memcache.ErrCacheMiss {
+		return nil
+	}
+	return err
+}
+
+var dedupStore DedupStore = MemcacheDedupStore{Fallback: NewLocalDedupStore()}
+
+// 沒有可用 id 的事件回傳空字串，不做去重
+func fbEventKey(fbMsg FBMessage) string {
+	switch {
+	case fbMsg.Content != nil && fbMsg.Content.Mid != "":
+		return "fb:mid:" + fbMsg.Content.Mid
+	case fbMsg.Content != nil && fbMsg.Content.Seq != 0:
+		return fmt.Sprintf("fb:seq:%d:%d", fbMsg.Sender.Id, fbMsg.Content.Seq)
+	case fbMsg.Postback != nil && fbMsg.Timestamp != 0:
+		return fmt.Sprintf("fb:postback:%d:%d", fbMsg.Sender.Id, fbMsg.Timestamp)
+	case fbMsg.Delivery != nil:
+		return fmt.Sprintf("fb:delivery:%d:%d", fbMsg.Sender.Id, fbMsg.Delivery.Watermark)
+	}
+	return ""
+}
+
+func tgEventKey(tgEntry TGEntry) string {
+	if tgEntry.Id == 0 {
+		return ""
+	}
+	return fmt.Sprintf("tg:update:%d", tgEntry.Id)
+}
+
+// acceptEvent 去重後把事件排進佇列
+// 回傳 error 代表應該讓平台重送，此時去重紀錄也會被放掉
+func acceptEvent(ctx context.Context, key, platform string, userId int64, payload interface{}) error {
+	if key != "" {
+		first, err := dedupStore.Claim(ctx, key, dedupTTL)
+		if err != nil {
+			log.Warningf(ctx, "dedup %s: %s", key, err)
+		} else if !first {
+			log.Infof(ctx, "skip duplicated event %s", key)
+			return nil
+		}
+	}
+
+	if err := enqueueEvent(ctx, key, platform, userId, payload); err != nil {
+		if key != "" {
+			if err := dedupStore.Release(ctx, key); err != nil {
+				log.Warningf(ctx, "release %s: %s", key, err)
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+type replyProgressKey struct{}
+
+// 處理一個事件時依序替每則回覆編號，task 重試時同一個編號不會再送一次
+type replyProgress struct {
+	eventKey string
+	sent     int
+}
+
+func withReplyProgress(ctx context.Context, eventKey string) context.Context {
+	if eventKey == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, replyProgressKey{}, &replyProgress{eventKey: eventKey})
+}
+
+// sendReplyOnce 只在這則回覆還沒被之前的嘗試送出時呼叫 send，送出失敗時放掉紀錄讓重試可以再送
+func sendReplyOnce(ctx context.Context, send func() error) error {
+	p, ok := ctx.Value(replyProgressKey{}).(*replyProgress)
+	if !ok {
+		return send()
+	}
+	p.sent++
+	key := fmt.Sprintf("%s:reply:%d", p.eventKey, p.sent)
+
+	first, err := dedupStore.Claim(ctx, key, dedupTTL)
+	if err != nil {
+		log.Warningf(ctx, "dedup %s: %s", key, err)
+	} else if !first {
+		log.Infof(ctx, "skip reply %s sent by an earlier attempt", key)
+		return nil
+	}
+
+	if err := send(); err != nil {
+		if err := dedupStore.Release(ctx, key); err != nil {
+			log.Warningf(ctx, "release %s: %s", key, err)
+		}
+		return err
+	}
+	return nil
+}
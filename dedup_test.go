@@ -0,0 +1,49 @@
+package pokedict
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSendReplyOnce(t *testing.T) {
+	defer func(s DedupStore) { dedupStore = s }(dedupStore)
+	dedupStore = NewLocalDedupStore()
+
+	sent := []string{}
+	send := func(text string, fail bool) func() error {
+		return func() error {
+			if fail {
+				return errors.New("send failed")
+			}
+			sent = append(sent, text)
+			return nil
+		}
+	}
+
+	// 第一次處理時第二則回覆失敗，task 重試時只補送第二則
+	ctx := withReplyProgress(testCtx, "fb:mid:1")
+	if err := sendReplyOnce(ctx, send("title", false)); err != nil {
+		t.Fatal(err)
+	}
+	if err := sendReplyOnce(ctx, send("template", true)); err == nil {
+		t.Fatal("expected error")
+	}
+
+	ctx = withReplyProgress(testCtx, "fb:mid:1")
+	for _, text := range []string{"title", "template"} {
+		if err := sendReplyOnce(ctx, send(text, false)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(sent) != 2 || sent[0] != "title" || sent[1] != "template" {
+		t.Errorf("got %v, want [title template]", sent)
+	}
+
+	// 沒有 key 的事件不做記錄
+	for i := 0; i < 2; i++ {
+		sendReplyOnce(testCtx, send("plain", false))
+	}
+	if len(sent) != 4 {
+		t.Errorf("got %v, want plain sent twice", sent)
+	}
+}
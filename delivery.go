@@ -0,0 +1,91 @@
+package pokedict
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/memcache"
+)
+
+const (
+	METRIC_SENT      = "sent"
+	METRIC_DELIVERED = "delivered"
+)
+
+func metricKey(platform, metric string, day time.Time) string {
+	return fmt.Sprintf("metrics:%s:%s:%s", platform, metric, day.UTC().Format("20060102"))
+}
+
+// 計數放在 memcache，只用來觀察送達率，不需要精確
+func incrMetric(ctx context.Context, platform, metric string, delta int64) {
+	key := metricKey(platform, metric, time.Now())
+	if _, err := memcache.Increment(ctx, key, delta, 0); err != nil {
+		log.Warningf(ctx, "increment %s: %s", key, err)
+	}
+}
+
+func recordSent(ctx context.Context, platform string) {
+	incrMetric(ctx, platform, METRIC_SENT, 1)
+}
+
+// Messenger 的 delivery 事件代表 watermark 之前的訊息都已送達
+func recordFBDelivery(ctx context.Context, user *User, delivery *FBMessageDelivery) {
+	if delivery.Watermark <= user.DeliveredWatermark {
+		return
+	}
+	n := int64(len(delivery.Mids))
+	if n == 0 {
+		n = 1
+	}
+	incrMetric(ctx, PLATFORM_FB, METRIC_DELIVERED, n)
+	user.DeliveredWatermark = delivery.Watermark
+	log.Debugf(ctx, "delivered to %d at %d: %v", user.Id, delivery.Watermark, delivery.Mids)
+}
+
+type DeliveryStats struct {
+	Day       string  `json:"day"`
+	Sent      uint64  `json:"sent"`
+	Delivered uint64  `json:"delivered"`
+	Rate      float64 `json:"rate"`
+}
+
+func readMetric(ctx context.Context, platform, metric string, day time.Time) uint64 {
+	item, err := memcache.Get(ctx, metricKey(platform, metric, day))
+	if err != nil {
+		return 0
+	}
+	n, _ := strconv.ParseUint(string(item.Value), 10, 64)
+	return n
+}
+
+func deliveryStats(ctx context.Context, platform string, day time.Time) (stats DeliveryStats) {
+	stats.Day = day.UTC().Format("2006-01-02")
+	stats.Sent = readMetric(ctx, platform, METRIC_SENT, day)
+	stats.Delivered = readMetric(ctx, platform, METRIC_DELIVERED, day)
+	if stats.Sent != 0 {
+		stats.Rate = float64(stats.Delivered) / float64(stats.Sent)
+	}
+	return
+}
+
+func init() {
+	http.HandleFunc("/admin/deliveryStats", deliveryStatsHandler)
+}
+
+// 列出最近七天 Messenger 的送出與送達數
+func deliveryStatsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+	now := time.Now()
+	var stats []DeliveryStats
+	for i := 0; i < 7; i++ {
+		stats = append(stats, deliveryStats(ctx, PLATFORM_FB, now.AddDate(0, 0, -i)))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
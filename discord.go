@@ -0,0 +1,427 @@
+package pokedict
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+)
+
+const (
+	DISCORD_PUBLIC_KEY     = ""
+	DISCORD_APPLICATION_ID = ""
+	DISCORD_BOT_TOKEN      = ""
+	DISCORD_API_ROOT       = "https://discord.com/api/v10"
+
+	// interaction 的類型
+	discordPing         = 1
+	discordCommand      = 2
+	discordAutocomplete = 4
+
+	// 回應的類型
+	discordPong                = 1
+	discordChannelMessage      = 4
+	discordAutocompleteResults = 8
+
+	// 只有下指令的人看得到
+	discordEphemeral = 1 << 6
+
+	discordOptionString = 3
+	discordMaxChoices   = 25
+	discordMaxEmbeds    = 10
+	discordEmbedColor   = 0xE3350D
+
+	// 超過長度整個回應會被拒絕
+	discordMaxChoiceName       = 100
+	discordMaxEmbedTitle       = 256
+	discordMaxEmbedDescription = 4096
+	discordMaxFieldName        = 256
+	discordMaxFieldValue       = 1024
+)
+
+type DiscordOption struct {
+	Name    string      `json:"name"`
+	Type    int         `json:"type"`
+	Value   interface{} `json:"value,omitempty"`
+	Focused bool        `json:"focused,omitempty"`
+}
+
+type DiscordCommandData struct {
+	Id      string          `json:"id"`
+	Name    string          `json:"name"`
+	Options []DiscordOption `json:"options,omitempty"`
+}
+
+type DiscordInteraction struct {
+	Id            string             `json:"id"`
+	ApplicationId string             `json:"application_id"`
+	Type          int                `json:"type"`
+	Token         string             `json:"token"`
+	Locale        string             `json:"locale,omitempty"`
+	Data          DiscordCommandData `json:"data"`
+}
+
+type DiscordEmbedImage struct {
+	Url string `json:"url"`
+}
+
+type DiscordEmbedField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline,omitempty"`
+}
+
+type DiscordEmbed struct {
+	Title       string              `json:"title"`
+	Url         string              `json:"url,omitempty"`
+	Description string              `json:"description,omitempty"`
+	Color       int                 `json:"color,omitempty"`
+	Thumbnail   *DiscordEmbedImage  `json:"thumbnail,omitempty"`
+	Fields      []DiscordEmbedField `json:"fields,omitempty"`
+}
+
+type DiscordChoice struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type DiscordResponseData struct {
+	Content string          `json:"content,omitempty"`
+	Embeds  []DiscordEmbed  `json:"embeds,omitempty"`
+	Flags   int             `json:"flags,omitempty"`
+	Choices []DiscordChoice `json:"choices,omitempty"`
+}
+
+type DiscordResponse struct {
+	Type int                  `json:"type"`
+	Data *DiscordResponseData `json:"data,omitempty"`
+}
+
+type DiscordCommandOption struct {
+	Type         int    `json:"type"`
+	Name         string `json:"name"`
+	Description  string `json:"description"`
+	Required     bool   `json:"required,omitempty"`
+	Autocomplete bool   `json:"autocomplete,omitempty"`
+}
+
+type DiscordCommand struct {
+	Id                       string                 `json:"id,omitempty"`
+	Name                     string                 `json:"name"`
+	Description              string                 `json:"description"`
+	DescriptionLocalizations map[string]string      `json:"description_localizations,omitempty"`
+	Options                  []DiscordCommandOption `json:"options,omitempty"`
+}
+
+// 用 /admin/discordCommands 註冊到 Discord 的 slash command
+var discordCommands []DiscordCommand = []DiscordCommand{
+	DiscordCommand{
+		Name:        "pokemon",
+		Description: "Look up a Pokémon",
+		DescriptionLocalizations: map[string]string{
+			"zh-TW": "查寵物",
+			"zh-CN": "查宝可梦",
+			"ja":    "ポケモンを調べる",
+		},
+		Options: []DiscordCommandOption{
+			DiscordCommandOption{Type: discordOptionString, Name: "name", Description: "Pokémon name", Required: true, Autocomplete: true},
+		},
+	},
+	DiscordCommand{
+		Name:        "skill",
+		Description: "Look up a move",
+		DescriptionLocalizations: map[string]string{
+			"zh-TW": "查技能",
+			"zh-CN": "查技能",
+			"ja":    "技を調べる",
+		},
+		Options: []DiscordCommandOption{
+			DiscordCommandOption{Type: discordOptionString, Name: "name", Description: "Move name", Required: true, Autocomplete: true},
+		},
+	},
+}
+
+func init() {
+	http.HandleFunc("/discordInteractions", discordHandler)
+	http.HandleFunc("/admin/discordCommands", discordCommandsHandler)
+}
+
+// Discord 以 Ed25519 對 timestamp + body 簽章，公鑰是 hex 字串
+func verifyDiscordSignature(publicKey, signature, timestamp string, body []byte) bool {
+	key, err := hex.DecodeString(publicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return false
+	}
+	sig, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	message := append([]byte(timestamp), body...)
+	return ed25519.Verify(ed25519.PublicKey(key), message, sig)
+}
+
+func discordOptionValue(options []DiscordOption, name string) string {
+	for _, o := range options {
+		if o.Name == name && o.Value != nil {
+			return fmt.Sprint(o.Value)
+		}
+	}
+	return ""
+}
+
+func discordFocusedValue(options []DiscordOption) string {
+	for _, o := range options {
+		if o.Focused && o.Value != nil {
+			return fmt.Sprint(o.Value)
+		}
+	}
+	return ""
+}
+
+// embed 的欄位不能是空字串
+func discordFieldValue(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return truncateText(value, discordMaxFieldValue)
+}
+
+func discordMonsterEmbed(ctx context.Context, lang string, m Pokemon) DiscordEmbed {
+	fastMoves, chargedMoves := monsterMoves(ctx, m)
+	weaknesses := []string{}
+	for _, t := range m.Weaknesses {
+		weaknesses = append(weaknesses, typeName(lang, t))
+	}
+	return DiscordEmbed{
+		Title:     truncateText(m.DisplayName(lang), discordMaxEmbedTitle),
+		Url:       fmt.Sprintf("http://pgwave.com/zh-hant/pokemon/%d", m.Id),
+		Color:     discordEmbedColor,
+		Thumbnail: &DiscordEmbedImage{Url: fmt.Sprintf("http://pgwave.com/assets/images/pokemon/3d-h120/%d.png", m.Id)},
+		Fields: []DiscordEmbedField{
+			DiscordEmbedField{Name: tr(lang, "type_label"), Value: formatMonsterTypes(lang, m), Inline: true},
+			DiscordEmbedField{Name: tr(lang, "max_cp_label"), Value: fmt.Sprint(m.MaxCP), Inline: true},
+			DiscordEmbedField{Name: tr(lang, "weaknesses_label"), Value: discordFieldValue(strings.Join(weaknesses, ", "))},
+			DiscordEmbedField{Name: tr(lang, "fast_moves"), Value: discordFieldValue(formatMoveSummary(lang, fastMoves))},
+			DiscordEmbedField{Name: tr(lang, "charged_moves"), Value: discordFieldValue(formatMoveSummary(lang, chargedMoves))},
+		},
+	}
+}
+
+// 技能沿用比較指令的卡片內容
+func discordSkillEmbeds(lang string, skills []PokemonSkill) []DiscordEmbed {
+	embeds := []DiscordEmbed{}
+	for _, item := range compareItems(lang, skills) {
+		title, _ := item["title"].(string)
+		subtitle, _ := item["subtitle"].(string)
+		embeds = append(embeds, DiscordEmbed{
+			Title:       truncateText(title, discordMaxEmbedTitle),
+			Description: truncateText(subtitle, discordMaxEmbedDescription),
+			Color:       discordEmbedColor,
+		})
+	}
+	return embeds
+}
+
+// 先找名稱完全相同的，找不到再用關鍵字搜尋
+func discordMonsters(ctx context.Context, name string) []Pokemon {
+	if m, ok := findMonster(ctx, name); ok {
+		return []Pokemon{m}
+	}
+	return queryMonster(ctx, name)
+}
+
+func discordMessage(text string) DiscordResponse {
+	return DiscordResponse{
+		Type: discordChannelMessage,
+		Data: &DiscordResponseData{Content: text, Flags: discordEphemeral},
+	}
+}
+
+func discordCommandResponse(ctx context.Context, lang string, data DiscordCommandData) DiscordResponse {
+	name := discordOptionValue(data.Options, "name")
+	embeds := []DiscordEmbed{}
+	switch data.Name {
+	case "pokemon":
+		monsters := discordMonsters(ctx, name)
+		if len(monsters) == 0 {
+			return discordMessage(tr(lang, "no_monster_found"))
+		} else if len(monsters) > discordMaxEmbeds {
+			return discordMessage(tr(lang, "too_many_results"))
+		}
+		for _, m := range monsters {
+			embeds = append(embeds, discordMonsterEmbed(ctx, lang, m))
+		}
+	case "skill":
+		skills := querySkill(ctx, name)
+		if len(skills) == 0 {
+			return discordMessage(tr(lang, "nothing_found"))
+		} else if len(skills) > discordMaxEmbeds {
+			return discordMessage(tr(lang, "too_many_results"))
+		}
+		embeds = discordSkillEmbeds(lang, skills)
+	default:
+		return discordMessage(tr(lang, "not_understand"))
+	}
+	return DiscordResponse{
+		Type: discordChannelMessage,
+		Data: &DiscordResponseData{Embeds: embeds},
+	}
+}
+
+// 候選項目顯示在地名稱，送回來的值用英文名稱，查詢時可以精確比對
+func discordChoices(ctx context.Context, lang, command, q string) []DiscordChoice {
+	data := loadGameData(ctx)
+	choices := []DiscordChoice{}
+	switch command {
+	case "pokemon":
+		monsters := []Pokemon{}
+		for _, m := range data.Monsters {
+			if m.NameContains(q) {
+				monsters = append(monsters, m)
+			}
+		}
+		sort.Sort(monstersById(monsters))
+		for _, m := range monsters {
+			choices = append(choices, DiscordChoice{Name: truncateText(m.DisplayName(lang), discordMaxChoiceName), Value: m.Name})
+		}
+	case "skill":
+		skills := []PokemonSkill{}
+		for _, s := range data.Skills {
+			if s.NameContains(q) {
+				skills = append(skills, s)
+			}
+		}
+		sort.Sort(skillsById(skills))
+		for _, s := range skills {
+			choices = append(choices, DiscordChoice{Name: truncateText(s.DisplayName(lang), discordMaxChoiceName), Value: s.Name})
+		}
+	}
+	if len(choices) > discordMaxChoices {
+		choices = choices[:discordMaxChoices]
+	}
+	return choices
+}
+
+func discordInteractionResponse(ctx context.Context, interaction DiscordInteraction) (resp DiscordResponse, ok bool) {
+	lang := languageForLocale(interaction.Locale)
+	switch interaction.Type {
+	case discordPing:
+		return DiscordResponse{Type: discordPong}, true
+	case discordCommand:
+		return discordCommandResponse(ctx, lang, interaction.Data), true
+	case discordAutocomplete:
+		q := discordFocusedValue(interaction.Data.Options)
+		return DiscordResponse{
+			Type: discordAutocompleteResults,
+			Data: &DiscordResponseData{Choices: discordChoices(ctx, lang, interaction.Data.Name, q)},
+		}, true
+	}
+	return
+}
+
+// Discord 要求三秒內回應，查詢都在記憶體內完成，不經過事件佇列
+func discordHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	ctx := appengine.NewContext(r)
+
+	if r.Method != "POST" {
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// 簽章錯誤一定要回 401，Discord 會拿這個檢查 endpoint
+	signature := r.Header.Get("X-Signature-Ed25519")
+	timestamp := r.Header.Get("X-Signature-Timestamp")
+	if !verifyDiscordSignature(DISCORD_PUBLIC_KEY, signature, timestamp, body) {
+		http.Error(w, "invalid request signature", http.StatusUnauthorized)
+		return
+	}
+
+	var interaction DiscordInteraction
+	if err := json.Unmarshal(body, &interaction); err != nil {
+		log.Errorf(ctx, "%s", err.Error())
+		http.Error(w, "unable to parse interaction from body", http.StatusBadRequest)
+		return
+	}
+
+	log.Debugf(ctx, "%+v", interaction)
+	resp, ok := discordInteractionResponse(ctx, interaction)
+	if !ok {
+		log.Warningf(ctx, "ignore discord interaction type %d", interaction.Type)
+		http.Error(w, "unknown interaction type", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (c *MessagingClient) CallDiscord(ctx context.Context, method, path string, payload interface{}) ([]byte, error) {
+	var b []byte
+	if payload != nil {
+		var err error
+		if b, err = json.Marshal(payload); err != nil {
+			return nil, err
+		}
+	}
+
+	log.Debugf(ctx, "Discord %s %s: %s", method, path, b)
+	return c.do(ctx, PLATFORM_DISCORD, DISCORD_APPLICATION_ID, func() (*http.Request, error) {
+		req, err := http.NewRequest(method, c.DiscordAPIRoot+path, bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bot "+DISCORD_BOT_TOKEN)
+		return req, nil
+	})
+}
+
+// GET 列出目前註冊的指令，POST 用 discordCommands 整批覆蓋
+func discordCommandsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+
+	if r.Method != "GET" && r.Method != "POST" {
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+
+	method := "GET"
+	var payload interface{}
+	if r.Method == "POST" {
+		method = "PUT"
+		payload = discordCommands
+	}
+
+	client := newMessagingClient(ctx)
+	body, err := client.CallDiscord(ctx, method, fmt.Sprintf("/applications/%s/commands", DISCORD_APPLICATION_ID), payload)
+	if err != nil {
+		log.Errorf(ctx, "%s discord commands: %s", method, err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	commands := []DiscordCommand{}
+	if err := json.Unmarshal(body, &commands); err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"commands": commands,
+		"applied":  r.Method == "POST",
+	})
+}
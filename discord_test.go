@@ -0,0 +1,158 @@
+package pokedict
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+func TestVerifyDiscordSignature(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := hex.EncodeToString(publicKey)
+	body := []byte(`{"type":1}`)
+	timestamp := "1700000000"
+	signature := hex.EncodeToString(ed25519.Sign(privateKey, append([]byte(timestamp), body...)))
+
+	tests := []struct {
+		name      string
+		key       string
+		signature string
+		timestamp string
+		body      []byte
+		ok        bool
+	}{
+		{"valid", key, signature, timestamp, body, true},
+		{"tampered body", key, signature, timestamp, []byte(`{"type":2}`), false},
+		{"tampered timestamp", key, signature, "1700000001", body, false},
+		{"other key", hex.EncodeToString(otherKey), signature, timestamp, body, false},
+		{"not hex", key, "zz" + signature[2:], timestamp, body, false},
+		{"no signature", key, "", timestamp, body, false},
+		{"empty key", "", signature, timestamp, body, false},
+	}
+	for _, test := range tests {
+		if ok := verifyDiscordSignature(test.key, test.signature, test.timestamp, test.body); ok != test.ok {
+			t.Errorf("%s: got %v, want %v", test.name, ok, test.ok)
+		}
+	}
+}
+
+// 沒設定公鑰時任何請求都要回 401
+func TestDiscordHandlerSignature(t *testing.T) {
+	if DISCORD_PUBLIC_KEY != "" {
+		t.Skip("public key is configured")
+	}
+	r := newTestRequest(t, "POST", "/discordInteractions", bytes.NewReader([]byte(`{"type":1}`)))
+	r.Header.Set("X-Signature-Ed25519", "00")
+	r.Header.Set("X-Signature-Timestamp", "1700000000")
+	w := httptest.NewRecorder()
+	discordHandler(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestDiscordPing(t *testing.T) {
+	var interaction DiscordInteraction
+	if err := json.Unmarshal([]byte(`{"id":"1","type":1}`), &interaction); err != nil {
+		t.Fatal(err)
+	}
+	resp, ok := discordInteractionResponse(testCtx, interaction)
+	if !ok {
+		t.Fatal("ping is not handled")
+	}
+	b, _ := json.Marshal(resp)
+	if string(b) != `{"type":1}` {
+		t.Errorf("got %s, want PONG", b)
+	}
+
+	if _, ok := discordInteractionResponse(testCtx, DiscordInteraction{Type: 99}); ok {
+		t.Error("unknown interaction type should not be handled")
+	}
+}
+
+func TestDiscordAutocomplete(t *testing.T) {
+	for _, command := range []string{"pokemon", "skill"} {
+		interaction := DiscordInteraction{
+			Type: discordAutocomplete,
+			Data: DiscordCommandData{Name: command, Options: []DiscordOption{{Name: "name", Type: discordOptionString, Value: "", Focused: true}}},
+		}
+		resp, _ := discordInteractionResponse(testCtx, interaction)
+		if resp.Type != discordAutocompleteResults || resp.Data == nil {
+			t.Fatalf("%s: got %+v", command, resp)
+		}
+		// 空字串符合所有名稱，超過 25 個會被 Discord 拒絕
+		if n := len(resp.Data.Choices); n != discordMaxChoices {
+			t.Errorf("%s: got %d choices, want %d", command, n, discordMaxChoices)
+		}
+		for _, c := range resp.Data.Choices {
+			if c.Value == "" || len([]rune(c.Name)) > discordMaxChoiceName {
+				t.Errorf("%s: invalid choice %+v", command, c)
+			}
+		}
+	}
+
+	choices := discordChoices(testCtx, LANG_EN, "skill", "Hydro")
+	if len(choices) != 1 || choices[0].Value != "Hydro Pump" {
+		t.Errorf("got choices %+v", choices)
+	}
+}
+
+func checkDiscordEmbed(t *testing.T, lang string, e DiscordEmbed) {
+	if n := len([]rune(e.Title)); n == 0 || n > discordMaxEmbedTitle {
+		t.Errorf("%s: embed title %q has %d characters", lang, e.Title, n)
+	}
+	if n := len([]rune(e.Description)); n > discordMaxEmbedDescription {
+		t.Errorf("%s: embed %q description has %d characters", lang, e.Title, n)
+	}
+	for _, f := range e.Fields {
+		if n := len([]rune(f.Name)); n == 0 || n > discordMaxFieldName {
+			t.Errorf("%s: embed %q field name %q has %d characters", lang, e.Title, f.Name, n)
+		}
+		if n := len([]rune(f.Value)); n == 0 || n > discordMaxFieldValue {
+			t.Errorf("%s: embed %q field %q value has %d characters", lang, e.Title, f.Name, n)
+		}
+	}
+}
+
+func TestDiscordEmbedLimits(t *testing.T) {
+	game := loadGameData(testCtx)
+	skills := []PokemonSkill{}
+	for _, s := range game.Skills {
+		skills = append(skills, s)
+	}
+	for _, lang := range languages {
+		for _, m := range game.Monsters {
+			checkDiscordEmbed(t, lang, discordMonsterEmbed(testCtx, lang, m))
+		}
+		for _, e := range discordSkillEmbeds(lang, skills) {
+			checkDiscordEmbed(t, lang, e)
+		}
+	}
+
+	// 招式很多時欄位內容會被截斷
+	if v := discordFieldValue(string(bytes.Repeat([]byte("a"), 2000))); len([]rune(v)) != discordMaxFieldValue {
+		t.Errorf("field value is not truncated: %d characters", len([]rune(v)))
+	}
+	if v := discordFieldValue(""); v != "-" {
+		t.Errorf("empty field value should be replaced, got %q", v)
+	}
+
+	// 結果太多時不送 embed
+	resp := discordCommandResponse(testCtx, LANG_EN, DiscordCommandData{Name: "skill", Options: []DiscordOption{{Name: "name", Value: "a"}}})
+	if resp.Data == nil || len(resp.Data.Embeds) > discordMaxEmbeds {
+		t.Errorf("got %d embeds, want at most %d", len(resp.Data.Embeds), discordMaxEmbeds)
+	}
+}
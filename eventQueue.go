@@ -0,0 +1,325 @@
+package pokedict
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/taskqueue"
+)
+
+const (
+	EVENT_TASK_PATH = "/_tasks/event"
+	EVENT_QUEUE     = "events"
+
+	// 處理中的 instance 掛掉時，過了這段時間其他 task 才能接手
+	eventRunLease = 2 * time.Minute
+	// 前面的事件一直沒處理完 (例如排進佇列時失敗) 時，最多等這麼久就跳過它
+	eventWaitTimeout = 5 * time.Minute
+)
+
+type QueuedEvent struct {
+	// 去重用的 key，也用來記錄已經送出的回覆
+	Key      string
+	Platform string
+	UserId   int64
+	Payload  json.RawMessage
+
+	// 同一個使用者的事件依 Seq 處理，0 表示不排序
+	Seq        int64
+	AcceptedAt time.Time
+}
+
+func (e QueuedEvent) orderKey() string {
+	return fmt.Sprintf("%s:%d", e.Platform, e.UserId)
+}
+
+// EventQueue 收下 webhook 事件稍後處理
+type EventQueue interface {
+	Enqueue(ctx context.Context, event QueuedEvent) error
+}
+
+// push queue 不保證順序，失敗重試的 task 會排在之後的 task 後面，順序由 eventOrder 負責
+type TaskQueue struct {
+	Path  string
+	Queue string
+}
+
+func (q TaskQueue) Enqueue(ctx context.Context, event QueuedEvent) error {
+	b, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	task := &taskqueue.Task{
+		Path:    q.Path,
+		Payload: b,
+		Header:  http.Header{"Content-Type": []string{"application/json"}},
+		Method:  "POST",
+	}
+	_, err = taskqueue.Add(ctx, task, q.Queue)
+	return err
+}
+
+var eventQueue EventQueue = TaskQueue{Path: EVENT_TASK_PATH, Queue: EVENT_QUEUE}
+
+type eventStatus int
+
+const (
+	eventReady eventStatus = iota
+	// 前面的事件還沒處理完，稍後再試
+	eventWaiting
+	// 已經處理過或被跳過，不必再處理
+	eventFinished
+)
+
+// EventOrder 讓同一個使用者的事件依收到的順序一次處理一個
+type EventOrder interface {
+	// Next 在收到事件時給一個遞增的序號
+	Next(ctx context.Context, user string) (int64, error)
+	// Begin 在處理前呼叫，回傳 eventReady 時要在處理完後呼叫 End
+	Begin(ctx context.Context, user string, seq int64, acceptedAt time.Time) (eventStatus, error)
+	// End 結束處理，finished 為 false 時之後會重試同一個事件，後面的事件繼續等
+	End(ctx context.Context, user string, seq int64, finished bool) error
+}
+
+// 每個使用者一筆，記錄發出和處理完的序號
+type EventSequence struct {
+	Issued int64
+	Done   int64
+	// 正在處理的序號和開始時間
+	Running      int64
+	RunningSince time.Time
+}
+
+func (s *EventSequence) begin(seq int64, acceptedAt, now time.Time) eventStatus {
+	if seq <= s.Done {
+		return eventFinished
+	}
+	if s.Running != 0 && now.Sub(s.RunningSince) < eventRunLease {
+		return eventWaiting
+	}
+	if seq != s.Done+1 && now.Sub(acceptedAt) < eventWaitTimeout {
+		return eventWaiting
+	}
+	s.Running = seq
+	s.RunningSince = now
+	return eventReady
+}
+
+func (s *EventSequence) end(seq int64, finished bool) {
+	if s.Running == seq {
+		s.Running = 0
+	}
+	if finished && seq > s.Done {
+		s.Done = seq
+	}
+}
+
+type LocalEventOrder struct {
+	mu        sync.Mutex
+	sequences map[string]*EventSequence
+}
+
+func NewLocalEventOrder() *LocalEventOrder {
+	return &LocalEventOrder{sequences: map[string]*EventSequence{}}
+}
+
+func (o *LocalEventOrder) sequence(user string) *EventSequence {
+	s, ok := o.sequences[user]
+	if !ok {
+		s = &EventSequence{}
+		o.sequences[user] = s
+	}
+	return s
+}
+
+func (o *LocalEventOrder) Next(ctx context.Context, user string) (int64, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	s := o.sequence(user)
+	s.Issued++
+	return s.Issued, nil
+}
+
+func (o *LocalEventOrder) Begin(ctx context.Context, user string, seq int64, acceptedAt time.Time) (eventStatus, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.sequence(user).begin(seq, acceptedAt, time.Now()), nil
+}
+
+func (o *LocalEventOrder) End(ctx context.Context, user string, seq int64, finished bool) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.sequence(user).end(seq, finished)
+	return nil
+}
+
+// 所有 instance 共用的序號，每次修改都在 transaction 裡
+type DatastoreEventOrder struct{}
+
+func (o DatastoreEventOrder) update(ctx context.Context, user string, f func(s *EventSequence)) error {
+	return datastore.RunInTransaction(ctx, func(tc context.Context) error {
+		key := datastore.NewKey(tc, "EventSequence", user, 0, nil)
+		var s EventSequence
+		if err := datastore.Get(tc, key, &s); err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+		f(&s)
+		_, err := datastore.Put(tc, key, &s)
+		return err
+	}, nil)
+}
+
+func (o DatastoreEventOrder) Next(ctx context.Context, user string) (seq int64, err error) {
+	err = o.update(ctx, user, func(s *EventSequence) {
+		s.Issued++
+		seq = s.Issued
+	})
+	return
+}
+
+func (o DatastoreEventOrder) Begin(ctx context.Context, user string, seq int64, acceptedAt time.Time) (status eventStatus, err error) {
+	err = o.update(ctx, user, func(s *EventSequence) {
+		status = s.begin(seq, acceptedAt, time.Now())
+	})
+	return
+}
+
+func (o DatastoreEventOrder) End(ctx context.Context, user string, seq int64, finished bool) error {
+	return o.update(ctx, user, func(s *EventSequence) {
+		s.end(seq, finished)
+	})
+}
+
+var eventOrder EventOrder = DatastoreEventOrder{}
+
+func init() {
+	http.HandleFunc(EVENT_TASK_PATH, eventTaskHandler)
+}
+
+func enqueueEvent(ctx context.Context, key, platform string, userId int64, payload interface{}) error {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	event := QueuedEvent{
+		Key:        key,
+		Platform:   platform,
+		UserId:     userId,
+		Payload:    b,
+		AcceptedAt: time.Now(),
+	}
+	if event.Seq, err = eventOrder.Next(ctx, event.orderKey()); err != nil {
+		return err
+	}
+	return eventQueue.Enqueue(ctx, event)
+}
+
+// 單一事件出錯 (包括 panic) 不會影響其他事件
+func processEvent(ctx context.Context, event QueuedEvent) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	ctx = withReplyProgress(ctx, event.Key)
+
+	switch event.Platform {
+	case PLATFORM_FB:
+		var fbMsg FBMessage
+		if err := json.Unmarshal(event.Payload, &fbMsg); err != nil {
+			return err
+		}
+		log.Debugf(ctx, "%+v", fbMsg)
+		return handleFBMessage(withPage(ctx, fbMsg.Recipient.Id), fbMsg)
+	case PLATFORM_TG:
+		var tgEntry TGEntry
+		if err := json.Unmarshal(event.Payload, &tgEntry); err != nil {
+			return err
+		}
+		return handleTGEntry(ctx, tgEntry)
+	case PLATFORM_LINE:
+		var lineEvent LineEvent
+		if err := json.Unmarshal(event.Payload, &lineEvent); err != nil {
+			return err
+		}
+		return handleLineEvent(ctx, lineEvent)
+	}
+	return fmt.Errorf("unknown platform: %s", event.Platform)
+}
+
+// 送訊息或存取使用者狀態的暫時性錯誤
+func isTemporary(err error) bool {
+	e, ok := err.(interface {
+		Temporary() bool
+	})
+	return ok && e.Temporary()
+}
+
+func eventTaskHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	ctx := appengine.NewContext(r)
+
+	// 只接受 App Engine task queue 送來的請求
+	if r.Header.Get("X-AppEngine-QueueName") == "" {
+		http.Error(w, "", http.StatusForbidden)
+		return
+	}
+
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var event QueuedEvent
+	if err := json.Unmarshal(b, &event); err != nil {
+		// 內容壞掉的 task 重試也沒有用
+		log.Errorf(ctx, "invalid event task: %s", err)
+		return
+	}
+
+	// 回 503 讓 task queue 稍後重試，前面的事件處理完之後才會輪到這個
+	if event.Seq != 0 {
+		status, err := eventOrder.Begin(ctx, event.orderKey(), event.Seq, event.AcceptedAt)
+		switch {
+		case err != nil:
+			log.Warningf(ctx, "begin %s event %d: %s", event.orderKey(), event.Seq, err)
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		case status == eventWaiting:
+			http.Error(w, "waiting for earlier events", http.StatusServiceUnavailable)
+			return
+		case status == eventFinished:
+			log.Infof(ctx, "skip finished %s event %d", event.orderKey(), event.Seq)
+			fmt.Fprint(w, "")
+			return
+		}
+	}
+
+	err = processEvent(ctx, event)
+	if err != nil {
+		log.Errorf(ctx, "process %s event: %s", event.Platform, err)
+	}
+	// 暫時性的錯誤讓 task queue 重試，已經送出的回覆在重試時會跳過
+	retry := err != nil && isTemporary(err)
+
+	if event.Seq != 0 {
+		if err := eventOrder.End(ctx, event.orderKey(), event.Seq, !retry); err != nil {
+			// 沒記錄到處理完，之後的事件會卡住，重試這個事件直到記錄成功
+			log.Errorf(ctx, "end %s event %d: %s", event.orderKey(), event.Seq, err)
+			retry = true
+		}
+	}
+	if retry {
+		http.Error(w, "retry", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprint(w, "")
+}
@@ -0,0 +1,210 @@
+package pokedict
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// 同一個使用者的事件在 shard 裡排隊會被別人的慢查詢卡住，events 佇列要能同時處理
+func TestEventQueueConfig(t *testing.T) {
+	b, err := ioutil.ReadFile("queue.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, block := range strings.Split(string(b), "- name: ")[1:] {
+		if !strings.HasPrefix(block, EVENT_QUEUE+"\n") {
+			continue
+		}
+		found = true
+		if strings.Contains(block, "max_concurrent_requests: 1\n") {
+			t.Errorf("%s queue should process events concurrently:\n%s", EVENT_QUEUE, block)
+		}
+	}
+	if !found {
+		t.Errorf("queue.yaml has no %s queue", EVENT_QUEUE)
+	}
+}
+
+func TestEventSequence(t *testing.T) {
+	now := time.Now()
+	s := &EventSequence{Issued: 3}
+
+	if status := s.begin(2, now, now); status != eventWaiting {
+		t.Errorf("event 2 should wait for event 1, got %v", status)
+	}
+	if status := s.begin(1, now, now); status != eventReady {
+		t.Errorf("event 1 should be ready, got %v", status)
+	}
+	// 同一個 task 重複送來時不能同時處理
+	if status := s.begin(1, now, now); status != eventWaiting {
+		t.Errorf("event 1 is running, got %v", status)
+	}
+
+	// 暫時性錯誤，之後重試同一個事件
+	s.end(1, false)
+	if status := s.begin(2, now, now); status != eventWaiting {
+		t.Errorf("event 2 should wait for the retry of event 1, got %v", status)
+	}
+	s.begin(1, now, now)
+	s.end(1, true)
+	if status := s.begin(1, now, now); status != eventFinished {
+		t.Errorf("event 1 is finished, got %v", status)
+	}
+	if status := s.begin(2, now, now); status != eventReady {
+		t.Errorf("event 2 should be ready, got %v", status)
+	}
+
+	// 處理中的 instance 掛掉，租約過期後才能接手
+	if status := s.begin(2, now, now.Add(eventRunLease/2)); status != eventWaiting {
+		t.Errorf("event 2 is still leased, got %v", status)
+	}
+	if status := s.begin(2, now, now.Add(eventRunLease)); status != eventReady {
+		t.Errorf("expired lease should be taken over, got %v", status)
+	}
+	s.end(2, true)
+
+	// 序號 4 沒有排進佇列，5 等太久之後就跳過它
+	s.Issued = 5
+	if status := s.begin(5, now, now); status != eventWaiting {
+		t.Errorf("event 5 should wait for event 4, got %v", status)
+	}
+	if status := s.begin(5, now, now.Add(eventWaitTimeout)); status != eventReady {
+		t.Errorf("event 5 should stop waiting after the timeout, got %v", status)
+	}
+	s.end(5, true)
+	if status := s.begin(4, now, now); status != eventFinished {
+		t.Errorf("skipped event 4 should not run after event 5, got %v", status)
+	}
+}
+
+// 把事件留在記憶體裡，由測試決定 task 執行的順序
+type captureQueue struct {
+	mu     sync.Mutex
+	events []QueuedEvent
+}
+
+func (q *captureQueue) Enqueue(ctx context.Context, event QueuedEvent) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.events = append(q.events, event)
+	return nil
+}
+
+// 像 push queue 一樣把 task 送給 eventTaskHandler，回傳 HTTP 狀態碼
+func runEventTask(t *testing.T, event QueuedEvent) int {
+	b, err := json.Marshal(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newTestRequest(t, "POST", EVENT_TASK_PATH, bytes.NewReader(b))
+	r.Header.Set("X-AppEngine-QueueName", EVENT_QUEUE)
+	w := httptest.NewRecorder()
+	eventTaskHandler(w, r)
+	return w.Code
+}
+
+// 第一次送出含有 failText 的訊息時回 429，其他請求都成功，並依序記錄送出的內容
+type flakyTransport struct {
+	mu       sync.Mutex
+	failText string
+	failed   bool
+	sent     []string
+}
+
+func (tr *flakyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body := ""
+	if req.Body != nil {
+		b, _ := ioutil.ReadAll(req.Body)
+		body = string(b)
+	}
+
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	resp := &http.Response{StatusCode: 200, Header: http.Header{}, Body: ioutil.NopCloser(strings.NewReader(`{"ok":true,"result":{}}`))}
+	if req.Method == "POST" && strings.Contains(body, tr.failText) && !tr.failed {
+		tr.failed = true
+		resp.StatusCode = http.StatusTooManyRequests
+		resp.Header.Set("Retry-After", "3600")
+		return resp, nil
+	}
+	if req.Method == "POST" {
+		tr.sent = append(tr.sent, body)
+	}
+	return resp, nil
+}
+
+// push queue 會在失敗的 task 重試前先執行後面的 task，後面的事件要等前面的重試成功
+func TestEventRetryOrdering(t *testing.T) {
+	defer func(q EventQueue, o EventOrder, tr func(ctx context.Context) http.RoundTripper, l *recipientLimiter, d DedupStore, u UserStore) {
+		eventQueue, eventOrder, messagingTransport, sendLimiter, dedupStore, users = q, o, tr, l, d, u
+	}(eventQueue, eventOrder, messagingTransport, sendLimiter, dedupStore, users)
+
+	queue := &captureQueue{}
+	transport := &flakyTransport{failText: travelModeName(DEFAULT_LANGUAGE, TRAVEL_BIKE)}
+	eventQueue = queue
+	eventOrder = DatastoreEventOrder{}
+	messagingTransport = func(ctx context.Context) http.RoundTripper { return transport }
+	sendLimiter = newRecipientLimiter(0)
+	dedupStore = NewLocalDedupStore()
+	users = NewDatastoreUserStore()
+
+	const fbUser = 5001
+	for i, text := range []string{"騎車", "走路"} {
+		fbMsg := FBMessage{Sender: FBSender{fbUser}, Content: &FBMessageContent{Seq: int64(i + 1), Text: text}}
+		if err := acceptEvent(testCtx, fbEventKey(fbMsg), PLATFORM_FB, fbUser, fbMsg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(queue.events) != 2 || queue.events[0].Seq+1 != queue.events[1].Seq {
+		t.Fatalf("events should be numbered in order: %+v", queue.events)
+	}
+	bike, walk := queue.events[0], queue.events[1]
+
+	steps := []struct {
+		name  string
+		event QueuedEvent
+		code  int
+	}{
+		{"bike fails to send", bike, http.StatusServiceUnavailable},
+		{"walk waits for bike", walk, http.StatusServiceUnavailable},
+		{"bike retried", bike, http.StatusOK},
+		{"walk", walk, http.StatusOK},
+		{"walk delivered twice", walk, http.StatusOK},
+	}
+	for _, step := range steps {
+		if code := runEventTask(t, step.event); code != step.code {
+			t.Fatalf("%s: got status %d, want %d", step.name, code, step.code)
+		}
+	}
+
+	// 狀態存在 datastore，換一個 store (另一個 instance) 也讀得到
+	u, err := NewDatastoreUserStore().Load(testCtx, fbUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.TravelMode != TRAVEL_WALK {
+		t.Errorf("got travel mode %q, want %q", u.TravelMode, TRAVEL_WALK)
+	}
+
+	replies := []string{}
+	for _, body := range transport.sent {
+		for _, mode := range []string{TRAVEL_BIKE, TRAVEL_WALK} {
+			if strings.Contains(body, travelModeName(DEFAULT_LANGUAGE, mode)) {
+				replies = append(replies, mode)
+			}
+		}
+	}
+	if len(replies) != 2 || replies[0] != TRAVEL_BIKE || replies[1] != TRAVEL_WALK {
+		t.Errorf("replies are sent out of order: %v", replies)
+	}
+}
@@ -0,0 +1,278 @@
+package pokedict
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+)
+
+const FB_PROFILE_FIELDS = "greeting,get_started,persistent_menu,whitelisted_domains"
+
+type FBGreeting struct {
+	Locale string `json:"locale"`
+	Text   string `json:"text"`
+}
+
+type FBGetStarted struct {
+	Payload string `json:"payload"`
+}
+
+type FBMenuItem struct {
+	Type    string `json:"type"`
+	Title   string `json:"title"`
+	Url     string `json:"url,omitempty"`
+	Payload string `json:"payload,omitempty"`
+}
+
+type FBPersistentMenu struct {
+	Locale                string       `json:"locale"`
+	ComposerInputDisabled bool         `json:"composer_input_disabled"`
+	CallToActions         []FBMenuItem `json:"call_to_actions"`
+}
+
+// FBProfile 對應 Messenger Profile API 的設定
+type FBProfile struct {
+	Greeting           []FBGreeting       `json:"greeting,omitempty"`
+	GetStarted         *FBGetStarted      `json:"get_started,omitempty"`
+	PersistentMenu     []FBPersistentMenu `json:"persistent_menu,omitempty"`
+	WhitelistedDomains []string           `json:"whitelisted_domains,omitempty"`
+}
+
+// Messenger 的 locale 和訊息目錄語言的對應，default 給其他 locale 使用
+var fbProfileLocales = []struct {
+	locale string
+	lang   string
+}{
+	{"default", DEFAULT_LANGUAGE},
+	{"zh_CN", LANG_ZH_CN},
+	{"en_US", LANG_EN},
+	{"ja_JP", LANG_JA},
+}
+
+// 粉絲專頁應有的設定，payload 要和 handleFBMessage 處理的 postback 一致
+func newFBProfile() (profile FBProfile) {
+	for _, l := range fbProfileLocales {
+		profile.Greeting = append(profile.Greeting, FBGreeting{Locale: l.locale, Text: tr(l.lang, "welcome")})
+		profile.PersistentMenu = append(profile.PersistentMenu, FBPersistentMenu{
+			Locale: l.locale,
+			CallToActions: []FBMenuItem{
+				{Type: "postback", Title: tr(l.lang, "menu_query_monster"), Payload: "QUERY_MONSTER"},
+				{Type: "postback", Title: tr(l.lang, "menu_query_skill"), Payload: "QUERY_SKILL"},
+				{Type: "postback", Title: tr(l.lang, "menu_find_monster"), Payload: "FIND_MONSTER"},
+			},
+		})
+	}
+	profile.GetStarted = &FBGetStarted{Payload: "GET_STARTED"}
+	profile.WhitelistedDomains = []string{
+		"https://pgwave.com",
+		"https://maps.google.com.tw",
+	}
+	return
+}
+
+var fbProfile FBProfile = newFBProfile()
+
+type FBProfileChange struct {
+	Field   string          `json:"field"`
+	Current json.RawMessage `json:"current"`
+	Desired json.RawMessage `json:"desired"`
+}
+
+func rawField(v interface{}) json.RawMessage {
+	b, _ := json.Marshal(v)
+	return b
+}
+
+// 以下的正規化讓 API 回傳的順序、網址結尾的斜線、大小寫不同時不算差異
+
+func normalizeFBGreetings(greetings []FBGreeting) map[string]string {
+	m := map[string]string{}
+	for _, g := range greetings {
+		m[g.Locale] = strings.TrimSpace(g.Text)
+	}
+	return m
+}
+
+func normalizeFBGetStarted(getStarted *FBGetStarted) string {
+	if getStarted == nil {
+		return ""
+	}
+	return getStarted.Payload
+}
+
+// 選單項目的順序會顯示給使用者，要保留
+func normalizeFBMenus(menus []FBPersistentMenu) map[string]FBPersistentMenu {
+	m := map[string]FBPersistentMenu{}
+	for _, menu := range menus {
+		items := []FBMenuItem{}
+		for _, item := range menu.CallToActions {
+			item.Title = strings.TrimSpace(item.Title)
+			item.Url = strings.TrimSuffix(item.Url, "/")
+			items = append(items, item)
+		}
+		menu.CallToActions = items
+		m[menu.Locale] = menu
+	}
+	return m
+}
+
+func normalizeFBDomains(domains []string) map[string]bool {
+	m := map[string]bool{}
+	for _, d := range domains {
+		m[strings.TrimSuffix(strings.ToLower(strings.TrimSpace(d)), "/")] = true
+	}
+	return m
+}
+
+// 只比較 desired 有設定的欄位，其他欄位維持專頁上的設定
+func diffFBProfile(current, desired FBProfile) (changes []FBProfileChange) {
+	fields := []struct {
+		name             string
+		current, desired interface{}
+		// 正規化後用來比較的值
+		currentKey, desiredKey interface{}
+		empty                  bool
+	}{
+		{"greeting", current.Greeting, desired.Greeting,
+			normalizeFBGreetings(current.Greeting), normalizeFBGreetings(desired.Greeting), len(desired.Greeting) == 0},
+		{"get_started", current.GetStarted, desired.GetStarted,
+			normalizeFBGetStarted(current.GetStarted), normalizeFBGetStarted(desired.GetStarted), desired.GetStarted == nil},
+		{"persistent_menu", current.PersistentMenu, desired.PersistentMenu,
+			normalizeFBMenus(current.PersistentMenu), normalizeFBMenus(desired.PersistentMenu), len(desired.PersistentMenu) == 0},
+		{"whitelisted_domains", current.WhitelistedDomains, desired.WhitelistedDomains,
+			normalizeFBDomains(current.WhitelistedDomains), normalizeFBDomains(desired.WhitelistedDomains), len(desired.WhitelistedDomains) == 0},
+	}
+	for _, f := range fields {
+		if f.empty {
+			continue
+		}
+		if !reflect.DeepEqual(f.currentKey, f.desiredKey) {
+			changes = append(changes, FBProfileChange{Field: f.name, Current: rawField(f.current), Desired: rawField(f.desired)})
+		}
+	}
+	return
+}
+
+func messengerProfileURI(pageId int64, v url.Values) string {
+	token, _ := pageToken(pageId)
+	v.Set("access_token", token)
+	return FB_GRAPH_ROOT + "/me/messenger_profile?" + v.Encode()
+}
+
+func (c *MessagingClient) GetFBProfile(ctx context.Context, pageId int64) (profile FBProfile, err error) {
+	uri := messengerProfileURI(pageId, url.Values{"fields": {FB_PROFILE_FIELDS}})
+	body, err := c.do(ctx, PLATFORM_FB, "profile", func() (*http.Request, error) {
+		return http.NewRequest("GET", uri, nil)
+	})
+	if err != nil {
+		return
+	}
+
+	var resp struct {
+		Data []FBProfile `json:"data"`
+	}
+	if err = json.Unmarshal(body, &resp); err != nil {
+		return
+	}
+	if len(resp.Data) != 0 {
+		profile = resp.Data[0]
+	}
+	return
+}
+
+func (c *MessagingClient) SetFBProfile(ctx context.Context, pageId int64, profile FBProfile) error {
+	b, err := json.Marshal(profile)
+	if err != nil {
+		return err
+	}
+	uri := messengerProfileURI(pageId, url.Values{})
+	log.Debugf(ctx, "Messenger profile %s", b)
+	_, err = c.do(ctx, PLATFORM_FB, "profile", func() (*http.Request, error) {
+		req, err := http.NewRequest("POST", uri, bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	})
+	return err
+}
+
+// 只送出有差異的欄位
+func changedFBProfile(desired FBProfile, changes []FBProfileChange) (update FBProfile) {
+	for _, c := range changes {
+		switch c.Field {
+		case "greeting":
+			update.Greeting = desired.Greeting
+		case "get_started":
+			update.GetStarted = desired.GetStarted
+		case "persistent_menu":
+			update.PersistentMenu = desired.PersistentMenu
+		case "whitelisted_domains":
+			update.WhitelistedDomains = desired.WhitelistedDomains
+		}
+	}
+	return
+}
+
+func init() {
+	http.HandleFunc("/admin/fbProfile", fbProfileHandler)
+}
+
+// GET 列出專頁設定和 fbProfile 的差異，POST 會把差異推上去
+// 多個專頁時用 ?page=<page id> 指定
+func fbProfileHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+
+	var pageId int64
+	if p := r.FormValue("page"); p != "" {
+		var err error
+		if pageId, err = strconv.ParseInt(p, 10, 64); err != nil {
+			http.Error(w, "invalid page id", http.StatusBadRequest)
+			return
+		}
+	}
+	if _, ok := pageToken(pageId); !ok {
+		http.Error(w, "no access token for page", http.StatusBadRequest)
+		return
+	}
+	if r.Method != "GET" && r.Method != "POST" {
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+
+	client := newMessagingClient(withPage(ctx, pageId))
+	current, err := client.GetFBProfile(ctx, pageId)
+	if err != nil {
+		log.Errorf(ctx, "get messenger profile: %s", err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	changes := diffFBProfile(current, fbProfile)
+	applied := false
+	if r.Method == "POST" && len(changes) != 0 {
+		if err := client.SetFBProfile(ctx, pageId, changedFBProfile(fbProfile, changes)); err != nil {
+			log.Errorf(ctx, "set messenger profile: %s", err)
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		applied = true
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"page":    fmt.Sprint(pageId),
+		"changes": changes,
+		"applied": applied,
+	})
+}
@@ -0,0 +1,67 @@
+package pokedict
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func loadFBProfile(t *testing.T) FBProfile {
+	b, err := ioutil.ReadFile(filepath.Join("testdata", "fb_profile.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resp struct {
+		Data []FBProfile `json:"data"`
+	}
+	if err := json.Unmarshal(b, &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp.Data[0]
+}
+
+func TestDiffFBProfile(t *testing.T) {
+	// 順序、網址結尾的斜線、大小寫和多出來的欄位都不算差異
+	if changes := diffFBProfile(loadFBProfile(t), fbProfile); len(changes) != 0 {
+		t.Errorf("unexpected changes: %+v", changes)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(p *FBProfile)
+		fields []string
+	}{
+		{"menu title", func(p *FBProfile) { p.PersistentMenu[1].CallToActions[0].Title = "查神奇寶貝" }, []string{"persistent_menu"}},
+		{"menu order", func(p *FBProfile) {
+			items := p.PersistentMenu[0].CallToActions
+			items[0], items[1] = items[1], items[0]
+		}, []string{"persistent_menu"}},
+		{"missing locale", func(p *FBProfile) { p.Greeting = p.Greeting[1:] }, []string{"greeting"}},
+		{"missing domain", func(p *FBProfile) { p.WhitelistedDomains = p.WhitelistedDomains[:1] }, []string{"whitelisted_domains"}},
+		{"no get started", func(p *FBProfile) { p.GetStarted = nil }, []string{"get_started"}},
+	}
+	for _, test := range tests {
+		current := loadFBProfile(t)
+		test.modify(&current)
+		changes := diffFBProfile(current, fbProfile)
+		fields := []string{}
+		for _, c := range changes {
+			fields = append(fields, c.Field)
+		}
+		if len(fields) != len(test.fields) || len(fields) != 0 && fields[0] != test.fields[0] {
+			t.Errorf("%s: got changes %v, want %v", test.name, fields, test.fields)
+		}
+	}
+}
+
+func TestFBProfileMenuTitles(t *testing.T) {
+	for _, menu := range fbProfile.PersistentMenu {
+		for _, item := range menu.CallToActions {
+			// Messenger 的選單標題最多 30 個字元
+			if n := len([]rune(item.Title)); n == 0 || n > 30 {
+				t.Errorf("%s: invalid menu title %q", menu.Locale, item.Title)
+			}
+		}
+	}
+}
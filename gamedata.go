@@ -0,0 +1,357 @@
+package pokedict
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+)
+
+const (
+	// 設為 true 時以 datastore 為準，資料檔只用來同步
+	GAME_DATA_FROM_DATASTORE = false
+	GAME_DATA_DIR            = "data"
+)
+
+// GameData 是載入後就不再修改的遊戲資料，更新時整份換掉
+type GameData struct {
+	Skills     map[int64]PokemonSkill
+	SkillIndex map[string]PokemonSkill
+	Monsters   map[int64]Pokemon
+	LoadedAt   time.Time
+	// 依 id 排序後內容的雜湊，用來判斷資料有沒有變
+	Hash string
+}
+
+// datastore 裡最後一次同步的資料版本
+type GameDataVersion struct {
+	Hash     string
+	SyncedAt time.Time
+}
+
+type GameDataSource interface {
+	Skills(ctx context.Context) ([]PokemonSkill, error)
+	Monsters(ctx context.Context) ([]Pokemon, error)
+}
+
+var gameData atomic.Value
+
+var emptyGameData *GameData = &GameData{
+	Skills:     map[int64]PokemonSkill{},
+	SkillIndex: map[string]PokemonSkill{},
+	Monsters:   map[int64]Pokemon{},
+}
+
+func newGameDataSource() GameDataSource {
+	if GAME_DATA_FROM_DATASTORE {
+		return DatastoreGameDataSource{}
+	}
+	return FileGameDataSource{Dir: GAME_DATA_DIR}
+}
+
+// 技能的 id 或名稱重複時整份資料都不能用，呼叫端會繼續用原本的資料
+func newGameData(ctx context.Context, skills []PokemonSkill, monsters []Pokemon) (*GameData, error) {
+	d := &GameData{
+		Skills:     map[int64]PokemonSkill{},
+		SkillIndex: map[string]PokemonSkill{},
+		Monsters:   map[int64]Pokemon{},
+		LoadedAt:   time.Now(),
+	}
+
+	// Id 來自資料檔，新增技能時不會影響其他技能的 Id
+	for _, skill := range skills {
+		if err := d.checkSkill(skill); err != nil {
+			return nil, err
+		}
+		deriveSkillStats(&skill)
+		d.Skills[skill.Id] = skill
+		for _, name := range []string{skill.Name, skill.Cname, skill.Scname, skill.Jname} {
+			if name != "" {
+				d.SkillIndex[skillKey(name)] = skill
+			}
+		}
+	}
+
+	for _, p := range monsters {
+		if m, ok := d.Monsters[p.Id]; ok {
+			return nil, fmt.Errorf("duplicate pokemon id %d: %s and %s", p.Id, m.Name, p.Name)
+		}
+		for _, err := range d.checkMoves(p) {
+			log.Errorf(ctx, "check moves: %s", err)
+		}
+		d.Monsters[p.Id] = p
+	}
+	d.Hash = d.hash()
+	return d, nil
+}
+
+func (d *GameData) hash() string {
+	skills := skillsById{}
+	for _, s := range d.Skills {
+		skills = append(skills, s)
+	}
+	sort.Sort(skills)
+	monsters := monstersById{}
+	for _, m := range d.Monsters {
+		monsters = append(monsters, m)
+	}
+	sort.Sort(monsters)
+
+	b, _ := json.Marshal([]interface{}{skills, monsters})
+	return fmt.Sprintf("%x", sha1.Sum(b))
+}
+
+func (d *GameData) checkSkill(skill PokemonSkill) error {
+	if s, ok := d.Skills[skill.Id]; ok {
+		return fmt.Errorf("duplicate skill id %d: %s and %s", skill.Id, s.Name, skill.Name)
+	}
+	if s, ok := d.SkillIndex[skillKey(skill.Name)]; ok {
+		return fmt.Errorf("duplicate skill name %s: id %d and %d", skill.Name, s.Id, skill.Id)
+	}
+	return nil
+}
+
+// 寵物的招式都要能用名稱找到同一種類的技能，資料檔的名稱拼錯時招式會查不到
+func (d *GameData) checkMoves(p Pokemon) (errs []error) {
+	moves := []struct {
+		kind  string
+		names []string
+	}{
+		{"fast", p.FastMoves},
+		{"charged", p.ChargedMoves},
+	}
+	for _, m := range moves {
+		for _, name := range m.names {
+			s, ok := d.SkillByName(name)
+			if !ok {
+				errs = append(errs, fmt.Errorf("%s: unknown move %q", p.Name, name))
+			} else if s.Kind != m.kind {
+				errs = append(errs, fmt.Errorf("%s: %q is not a %s move", p.Name, name, m.kind))
+			}
+		}
+	}
+	return
+}
+
+func (d *GameData) loaded() bool {
+	return len(d.Skills) != 0 && len(d.Monsters) != 0
+}
+
+func (d *GameData) SkillById(id int64) (PokemonSkill, bool) {
+	s, ok := d.Skills[id]
+	return s, ok
+}
+
+func (d *GameData) SkillByName(name string) (PokemonSkill, bool) {
+	s, ok := d.SkillIndex[skillKey(name)]
+	return s, ok
+}
+
+func (d *GameData) MonsterById(id int64) (Pokemon, bool) {
+	m, ok := d.Monsters[id]
+	return m, ok
+}
+
+// 目前的資料，還沒載入時回傳空的資料
+func currentGameData() *GameData {
+	if d, ok := gameData.Load().(*GameData); ok {
+		return d
+	}
+	return emptyGameData
+}
+
+// 取得目前的資料，還沒載入時才載入。讀取不需要上鎖，只有載入時會上鎖
+func loadGameData(ctx context.Context) *GameData {
+	if d := currentGameData(); d.loaded() {
+		return d
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	if d := currentGameData(); d.loaded() {
+		return d
+	}
+
+	d, err := readGameData(ctx, newGameDataSource())
+	if err != nil {
+		log.Errorf(ctx, "load game data: %s", err)
+		return currentGameData()
+	}
+	gameData.Store(d)
+	log.Infof(ctx, "game data loaded: %d skills, %d pokemon", len(d.Skills), len(d.Monsters))
+	return d
+}
+
+func readGameData(ctx context.Context, source GameDataSource) (*GameData, error) {
+	skills, err := source.Skills(ctx)
+	if err != nil {
+		return nil, err
+	}
+	monsters, err := source.Monsters(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := newGameData(ctx, skills, monsters)
+	if err != nil {
+		return nil, err
+	}
+	if !d.loaded() {
+		if _, ok := source.(DatastoreGameDataSource); ok {
+			log.Warningf(ctx, "datastore has no game data, read from files")
+			return readGameData(ctx, FileGameDataSource{Dir: GAME_DATA_DIR})
+		}
+		return nil, fmt.Errorf("no game data")
+	}
+	return d, nil
+}
+
+// 把資料檔寫進 datastore 並換上新的資料，由 cron 定期呼叫
+func syncGameData(ctx context.Context) error {
+	lock.Lock()
+	defer lock.Unlock()
+
+	_, err := syncGameDataFrom(ctx, FileGameDataSource{Dir: GAME_DATA_DIR})
+	return err
+}
+
+// 內容和上次同步相同時不寫入 datastore
+func syncGameDataFrom(ctx context.Context, source GameDataSource) (changed bool, err error) {
+	d, err := readGameData(ctx, source)
+	if err != nil {
+		return
+	}
+	if currentGameData().Hash != d.Hash {
+		gameData.Store(d)
+	}
+
+	versionKey := datastore.NewKey(ctx, "GameDataVersion", "current", 0, nil)
+	var version GameDataVersion
+	if err = datastore.Get(ctx, versionKey, &version); err != nil && err != datastore.ErrNoSuchEntity {
+		return
+	}
+	if version.Hash == d.Hash {
+		log.Debugf(ctx, "game data is not changed: %s", d.Hash)
+		return false, nil
+	}
+
+	skillKeys := []*datastore.Key{}
+	skillList := []PokemonSkill{}
+	skillNames := map[string]bool{}
+	for _, s := range d.Skills {
+		skillKeys = append(skillKeys, datastore.NewKey(ctx, "PokemonSkill", s.Name, 0, nil))
+		skillList = append(skillList, s)
+		skillNames[s.Name] = true
+	}
+	if _, err = datastore.PutMulti(ctx, skillKeys, skillList); err != nil {
+		return
+	}
+
+	monsterKeys := []*datastore.Key{}
+	monsterList := []Pokemon{}
+	monsterNames := map[string]bool{}
+	for _, p := range d.Monsters {
+		monsterKeys = append(monsterKeys, datastore.NewKey(ctx, "Pokemon", p.Name, 0, nil))
+		monsterList = append(monsterList, p)
+		monsterNames[p.Name] = true
+	}
+	if _, err = datastore.PutMulti(ctx, monsterKeys, monsterList); err != nil {
+		return
+	}
+
+	// 刪除或改名的資料在 datastore 裡還留著舊的 entity
+	if err = deleteStaleEntities(ctx, "PokemonSkill", skillNames); err != nil {
+		return
+	}
+	if err = deleteStaleEntities(ctx, "Pokemon", monsterNames); err != nil {
+		return
+	}
+
+	version = GameDataVersion{Hash: d.Hash, SyncedAt: time.Now()}
+	if _, err = datastore.Put(ctx, versionKey, &version); err != nil {
+		return
+	}
+	log.Infof(ctx, "game data synced: %d skills, %d pokemon", len(d.Skills), len(d.Monsters))
+	return true, nil
+}
+
+func deleteStaleEntities(ctx context.Context, kind string, names map[string]bool) error {
+	keys, err := datastore.NewQuery(kind).KeysOnly().GetAll(ctx, nil)
+	if err != nil {
+		return err
+	}
+	stale := []*datastore.Key{}
+	for _, k := range keys {
+		if !names[k.StringID()] {
+			stale = append(stale, k)
+		}
+	}
+	if len(stale) == 0 {
+		return nil
+	}
+	log.Infof(ctx, "delete %d stale %s entities", len(stale), kind)
+	return datastore.DeleteMulti(ctx, stale)
+}
+
+type FileGameDataSource struct {
+	Dir string
+}
+
+func (s FileGameDataSource) readJSON(name string, v interface{}) error {
+	f, err := os.Open(path.Join(s.Dir, name))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return json.NewDecoder(f).Decode(v)
+}
+
+func (s FileGameDataSource) Skills(ctx context.Context) ([]PokemonSkill, error) {
+	fastSkills := []PokemonSkill{}
+	if err := s.readJSON("fastSkill.json", &fastSkills); err != nil {
+		return nil, err
+	}
+	for i := range fastSkills {
+		fastSkills[i].Kind = "fast"
+	}
+
+	chargedSkills := []PokemonSkill{}
+	if err := s.readJSON("chargeSkill.json", &chargedSkills); err != nil {
+		return nil, err
+	}
+	for i := range chargedSkills {
+		chargedSkills[i].Kind = "charged"
+	}
+	return append(fastSkills, chargedSkills...), nil
+}
+
+func (s FileGameDataSource) Monsters(ctx context.Context) ([]Pokemon, error) {
+	monsters := []Pokemon{}
+	if err := s.readJSON("pokemon.json", &monsters); err != nil {
+		return nil, err
+	}
+	return monsters, nil
+}
+
+type DatastoreGameDataSource struct{}
+
+func (s DatastoreGameDataSource) Skills(ctx context.Context) ([]PokemonSkill, error) {
+	skills := []PokemonSkill{}
+	_, err := datastore.NewQuery("PokemonSkill").GetAll(ctx, &skills)
+	return skills, err
+}
+
+func (s DatastoreGameDataSource) Monsters(ctx context.Context) ([]Pokemon, error) {
+	monsters := []Pokemon{}
+	_, err := datastore.NewQuery("Pokemon").GetAll(ctx, &monsters)
+	return monsters, err
+}
@@ -0,0 +1,133 @@
+package pokedict
+
+import (
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+type memoryGameDataSource struct {
+	skills   []PokemonSkill
+	monsters []Pokemon
+}
+
+func (s memoryGameDataSource) Skills(ctx context.Context) ([]PokemonSkill, error) {
+	return s.skills, nil
+}
+
+func (s memoryGameDataSource) Monsters(ctx context.Context) ([]Pokemon, error) {
+	return s.monsters, nil
+}
+
+func entityNames(t *testing.T, kind string) map[string]bool {
+	keys, err := datastore.NewQuery(kind).KeysOnly().GetAll(testCtx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := map[string]bool{}
+	for _, k := range keys {
+		names[k.StringID()] = true
+	}
+	return names
+}
+
+func TestSyncGameData(t *testing.T) {
+	defer gameData.Store(currentGameData())
+
+	source := memoryGameDataSource{
+		skills: []PokemonSkill{
+			{Id: 34, Name: "Bite", Kind: "fast"},
+			{Id: 12, Name: "Water Gun", Kind: "fast"},
+		},
+		monsters: []Pokemon{
+			{Id: 7, Name: "Squirtle"},
+			{Id: 8, Name: "Wartortle"},
+		},
+	}
+	if changed, err := syncGameDataFrom(testCtx, source); err != nil || !changed {
+		t.Fatalf("first sync: changed %v, error %v", changed, err)
+	}
+
+	// 沒有變動時不寫入
+	if changed, err := syncGameDataFrom(testCtx, source); err != nil || changed {
+		t.Fatalf("unchanged sync: changed %v, error %v", changed, err)
+	}
+
+	// 改名和刪除的資料不能留在 datastore
+	source.skills = source.skills[1:]
+	source.monsters = []Pokemon{{Id: 7, Name: "Squirtle"}, {Id: 8, Name: "Wartortle (renamed)"}}
+	if changed, err := syncGameDataFrom(testCtx, source); err != nil || !changed {
+		t.Fatalf("second sync: changed %v, error %v", changed, err)
+	}
+	if names := entityNames(t, "PokemonSkill"); len(names) != 1 || !names["Water Gun"] {
+		t.Errorf("unexpected skills in datastore: %v", names)
+	}
+	if names := entityNames(t, "Pokemon"); len(names) != 2 || names["Wartortle"] || !names["Wartortle (renamed)"] {
+		t.Errorf("unexpected pokemon in datastore: %v", names)
+	}
+	if d := currentGameData(); len(d.Skills) != 1 || d.Monsters[8].Name != "Wartortle (renamed)" {
+		t.Errorf("game data is not replaced: %+v", d.Monsters)
+	}
+}
+
+// 資料檔裡每隻寵物的招式都要能對應到技能
+func TestGameDataMoves(t *testing.T) {
+	source := FileGameDataSource{Dir: GAME_DATA_DIR}
+	skills, err := source.Skills(testCtx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	monsters, err := source.Monsters(testCtx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := newGameData(testCtx, skills, monsters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range monsters {
+		for _, err := range d.checkMoves(p) {
+			t.Error(err)
+		}
+	}
+
+	bad := Pokemon{Name: "Beedrill", FastMoves: []string{"X Scissor"}, ChargedMoves: []string{"Bug Bite"}}
+	if errs := d.checkMoves(bad); len(errs) != 2 {
+		t.Errorf("got %v, want an unknown move and a wrong kind", errs)
+	}
+}
+
+// id 重複的資料不能載入，也不能蓋掉原本的資料
+func TestGameDataDuplicateSkillId(t *testing.T) {
+	defer gameData.Store(currentGameData())
+
+	source := FileGameDataSource{Dir: "testdata/duplicate_skill_id"}
+	if _, err := readGameData(testCtx, source); err == nil {
+		t.Fatal("duplicate skill id should fail the load")
+	}
+
+	previous := &GameData{Skills: map[int64]PokemonSkill{1: {Id: 1, Name: "Tackle"}}, Hash: "previous"}
+	gameData.Store(previous)
+	if changed, err := syncGameDataFrom(testCtx, source); err == nil || changed {
+		t.Errorf("sync should fail: changed %v, error %v", changed, err)
+	}
+	if currentGameData() != previous {
+		t.Error("previous game data should be kept")
+	}
+
+	tests := []struct {
+		name     string
+		skills   []PokemonSkill
+		monsters []Pokemon
+	}{
+		{"skill name", []PokemonSkill{{Id: 1, Name: "Bite"}, {Id: 2, Name: "bite "}}, nil},
+		{"pokemon id", []PokemonSkill{{Id: 1, Name: "Bite"}}, []Pokemon{{Id: 7, Name: "Squirtle"}, {Id: 7, Name: "Wartortle"}}},
+	}
+	for _, test := range tests {
+		if _, err := newGameData(testCtx, test.skills, test.monsters); err == nil {
+			t.Errorf("duplicate %s should be an error", test.name)
+		}
+	}
+}
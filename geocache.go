@@ -0,0 +1,233 @@
+package pokedict
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/TomiHiltunen/geohash-golang"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/memcache"
+)
+
+const (
+	pinGeohashPrecision   = 7
+	radarGeohashPrecision = 6
+
+	// 雷達一律查最大範圍再依半徑過濾，快取才能給不同半徑的查詢共用
+	radarMaxDistance = 5
+	radarCacheTTL    = 1 * time.Minute
+	localCacheSize   = 1000
+)
+
+var ErrGeoCacheMiss = errors.New("geocache: cache miss")
+
+// GeoCache 存放以 geohash 格子為 key 的資料，例如地址與雷達查詢結果
+type GeoCache interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	GetMulti(ctx context.Context, keys []string) (map[string][]byte, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+}
+
+func addressCacheKey(hash string) string {
+	return "addr:" + hash
+}
+
+func radarCacheKey(hash string) string {
+	return "radar:" + hash
+}
+
+// 一次查出多個格子和它們周圍八個格子的快取，回傳以格子為 key 的結果
+func getWithNeighbors(ctx context.Context, cache GeoCache, keyFunc func(string) string, hashes []string) (map[string][]byte, error) {
+	keys := []string{}
+	cellOfKey := map[string]string{}
+	for _, hash := range hashes {
+		for _, cell := range append([]string{hash}, geohash.Neighbors(hash)...) {
+			key := keyFunc(cell)
+			if _, ok := cellOfKey[key]; !ok {
+				keys = append(keys, key)
+				cellOfKey[key] = cell
+			}
+		}
+	}
+
+	items, err := cache.GetMulti(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string][]byte{}
+	for key, v := range items {
+		values[cellOfKey[key]] = v
+	}
+	return values, nil
+}
+
+type lruEntry struct {
+	key       string
+	value     []byte
+	expiresAt time.Time
+}
+
+type LRUGeoCache struct {
+	mu       sync.Mutex
+	capacity int
+	ll       *list.List
+	items    map[string]*list.Element
+}
+
+func NewLRUGeoCache(capacity int) *LRUGeoCache {
+	return &LRUGeoCache{
+		capacity: capacity,
+		ll:       list.New(),
+		items:    map[string]*list.Element{},
+	}
+}
+
+func (c *LRUGeoCache) Get(ctx context.Context, key string) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.items[key]
+	if !ok {
+		return nil, ErrGeoCacheMiss
+	}
+	entry := e.Value.(*lruEntry)
+	if !entry.expiresAt.IsZero() && time.Now().After(entry.expiresAt) {
+		c.ll.Remove(e)
+		delete(c.items, key)
+		return nil, ErrGeoCacheMiss
+	}
+	c.ll.MoveToFront(e)
+	return entry.value, nil
+}
+
+func (c *LRUGeoCache) GetMulti(ctx context.Context, keys []string) (map[string][]byte, error) {
+	values := map[string][]byte{}
+	for _, key := range keys {
+		if v, err := c.Get(ctx, key); err == nil {
+			values[key] = v
+		}
+	}
+	return values, nil
+}
+
+func (c *LRUGeoCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var expiresAt time.Time
+	if ttl > 0 {
+		expiresAt = time.Now().Add(ttl)
+	}
+
+	if e, ok := c.items[key]; ok {
+		entry := e.Value.(*lruEntry)
+		entry.value = value
+		entry.expiresAt = expiresAt
+		c.ll.MoveToFront(e)
+		return nil
+	}
+
+	c.items[key] = c.ll.PushFront(&lruEntry{key: key, value: value, expiresAt: expiresAt})
+	for c.capacity > 0 && c.ll.Len() > c.capacity {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruEntry).key)
+	}
+	return nil
+}
+
+type MemcacheGeoCache struct{}
+
+func (c MemcacheGeoCache) Get(ctx context.Context, key string) ([]byte, error) {
+	item, err := memcache.Get(ctx, key)
+	if err == memcache.ErrCacheMiss {
+		return nil, ErrGeoCacheMiss
+	} else if err != nil {
+		return nil, err
+	}
+	return item.Value, nil
+}
+
+func (c MemcacheGeoCache) GetMulti(ctx context.Context, keys []string) (map[string][]byte, error) {
+	items, err := memcache.GetMulti(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string][]byte{}
+	for key, item := range items {
+		values[key] = item.Value
+	}
+	return values, nil
+}
+
+func (c MemcacheGeoCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return memcache.Set(ctx, &memcache.Item{
+		Key:        key,
+		Value:      value,
+		Expiration: ttl,
+	})
+}
+
+// 先查 instance 內的 LRU，再查 memcache，並把 memcache 的結果回填到 LRU
+type tieredGeoCache struct {
+	local  GeoCache
+	remote GeoCache
+	ttl    time.Duration
+}
+
+func (c tieredGeoCache) Get(ctx context.Context, key string) ([]byte, error) {
+	if v, err := c.local.Get(ctx, key); err == nil {
+		return v, nil
+	}
+	v, err := c.remote.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	c.local.Set(ctx, key, v, c.ttl)
+	return v, nil
+}
+
+func (c tieredGeoCache) GetMulti(ctx context.Context, keys []string) (map[string][]byte, error) {
+	values, err := c.local.GetMulti(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	missed := []string{}
+	for _, key := range keys {
+		if _, ok := values[key]; !ok {
+			missed = append(missed, key)
+		}
+	}
+	if len(missed) == 0 {
+		return values, nil
+	}
+
+	remoteValues, err := c.remote.GetMulti(ctx, missed)
+	if err != nil {
+		return values, err
+	}
+	for key, v := range remoteValues {
+		values[key] = v
+		c.local.Set(ctx, key, v, c.ttl)
+	}
+	return values, nil
+}
+
+func (c tieredGeoCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	localTTL := ttl
+	if c.ttl > 0 && (localTTL == 0 || localTTL > c.ttl) {
+		localTTL = c.ttl
+	}
+	c.local.Set(ctx, key, value, localTTL)
+	return c.remote.Set(ctx, key, value, ttl)
+}
+
+var geoCache GeoCache = tieredGeoCache{
+	local:  NewLRUGeoCache(localCacheSize),
+	remote: MemcacheGeoCache{},
+	ttl:    10 * time.Minute,
+}
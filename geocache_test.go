@@ -0,0 +1,85 @@
+package pokedict
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/TomiHiltunen/geohash-golang"
+)
+
+func TestLRUGeoCache(t *testing.T) {
+	c := NewLRUGeoCache(2)
+	c.Set(testCtx, "a", []byte("1"), 0)
+	c.Set(testCtx, "b", []byte("2"), 0)
+	c.Get(testCtx, "a")
+	c.Set(testCtx, "c", []byte("3"), 0)
+	if _, err := c.Get(testCtx, "b"); err != ErrGeoCacheMiss {
+		t.Errorf("least recently used entry should be evicted, got %v", err)
+	}
+	if v, err := c.Get(testCtx, "a"); err != nil || string(v) != "1" {
+		t.Errorf("got %q, %v", v, err)
+	}
+
+	c.Set(testCtx, "d", []byte("4"), time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	if _, err := c.Get(testCtx, "d"); err != ErrGeoCacheMiss {
+		t.Errorf("expired entry should be a miss, got %v", err)
+	}
+}
+
+func TestFillShortAddrsFromNeighbors(t *testing.T) {
+	cache := NewLRUGeoCache(100)
+	cell := geohash.EncodeWithPrecision(25.0330, 121.5654, pinGeohashPrecision)
+	neighbor := geohash.Neighbors(cell)[0]
+	b, _ := json.Marshal(Address{City: "台北市", Suburb: "信義區", Road: "市府路"})
+	cache.Set(testCtx, addressCacheKey(neighbor), b, 0)
+
+	far := geohash.EncodeWithPrecision(25.0478, 121.5170, pinGeohashPrecision)
+	pins := []PokemonPin{{Geohash: cell}, {Geohash: cell}, {Geohash: far, Latitude: 25.0478, Longitude: 121.5170}}
+	missed := fillShortAddrs(testCtx, cache, pins)
+
+	if pins[0].ShortAddr != "台北市信義區,市府路" || pins[1].ShortAddr != pins[0].ShortAddr {
+		t.Errorf("neighbor address is not used: %q, %q", pins[0].ShortAddr, pins[1].ShortAddr)
+	}
+	if len(missed) != 1 || missed[0].Geohash != far {
+		t.Errorf("only the cell without any cached neighbor should be geocoded, got %+v", missed)
+	}
+}
+
+func TestGetPokemonNearFromNeighbors(t *testing.T) {
+	defer func(c GeoCache) { geoCache = c }(geoCache)
+	geoCache = NewLRUGeoCache(100)
+
+	cell := geohash.EncodeWithPrecision(25.0330, 121.5654, radarGeohashPrecision)
+	box := geohash.Decode(cell)
+	east := geohash.EncodeWithPrecision(box.Center().Lat(), box.NorthEast().Lng()+0.001, radarGeohashPrecision)
+
+	pin := PokemonPin{
+		Id:            "snorlax",
+		Latitude:      box.Center().Lat(),
+		Longitude:     box.NorthEast().Lng(),
+		DisappearTime: (time.Now().Unix() + 600) * 1000,
+	}
+	b, _ := json.Marshal([]PokemonPin{pin})
+	geoCache.Set(testCtx, radarCacheKey(east), b, radarCacheTTL)
+
+	// 靠近東邊的格子，東邊格子的查詢範圍涵蓋整個半徑
+	lat, long := box.Center().Lat(), box.NorthEast().Lng()-0.0005
+	pins, err := getPokemonNear(testCtx, lat, long, radarMaxDistance)
+	if err != nil || len(pins) != 1 || pins[0].Id != "snorlax" {
+		t.Fatalf("expected the neighbor's cached pins, got %+v, %v", pins, err)
+	}
+	if want := getDistances(lat, long, pin.Latitude, pin.Longitude); pins[0].Distance != want {
+		t.Errorf("distance should be recomputed from the query point: got %f, want %f", pins[0].Distance, want)
+	}
+
+	// 在西邊的邊緣，東邊格子的範圍涵蓋不到，要重新查詢
+	lat, long = box.Center().Lat(), box.SouthWest().Lng()+0.0005
+	if pins, _ := getPokemonNear(testCtx, lat, long, radarMaxDistance); len(pins) != 0 {
+		t.Errorf("neighbor result should not be used when it doesn't cover the radius, got %+v", pins)
+	}
+	if _, err := geoCache.Get(testCtx, radarCacheKey(cell)); err != nil {
+		t.Errorf("the query's own cell should be cached after fetching: %v", err)
+	}
+}
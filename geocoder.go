@@ -0,0 +1,293 @@
+package pokedict
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/TomiHiltunen/geohash-golang"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/taskqueue"
+	"google.golang.org/appengine/urlfetch"
+)
+
+const (
+	NOMINATIM_URI      = "https://nominatim.openstreetmap.org/reverse"
+	NOMINATIM_LANGUAGE = "zh-TW"
+	GEOCODER_AGENT     = "PokeDict/1.0"
+
+	addressCacheTTL = 7 * 24 * time.Hour
+
+	// Nominatim 的使用規範要求每秒不超過一次查詢，由 queue.yaml 裡 geocode 佇列的 rate 限制，所有 instance 共用
+	GEOCODE_TASK_PATH = "/_tasks/geocode"
+	GEOCODE_QUEUE     = "geocode"
+	// 同一個格子排進佇列後，這段時間內不再重複排
+	geocodePendingTTL = 10 * time.Minute
+)
+
+type Address struct {
+	HouseNumber string `json:"house_number"`
+	Road        string `json:"road"`
+	Suburb      string `json:"suburb"`
+	City        string `json:"city"`
+	Town        string `json:"town"`
+	State       string `json:"state"`
+	Postcode    string `json:"postcode"`
+	Country     string `json:"country"`
+}
+
+func (a Address) IsEmpty() bool {
+	return a.Road == "" && a.Suburb == "" && a.City == "" && a.Town == "" && a.State == ""
+}
+
+func (a Address) Short() string {
+	city := a.City
+	if city == "" {
+		city = a.Town
+	}
+	if city == "" {
+		city = a.State
+	}
+	return fmt.Sprintf("%s%s,%s", city, a.Suburb, a.Road)
+}
+
+type NominatimResult struct {
+	PlaceId     string  `json:"place_id"`
+	DisplayName string  `json:"display_name"`
+	Address     Address `json:"address"`
+	Error       string  `json:"error,omitempty"`
+}
+
+type ReverseGeocoder interface {
+	ReverseGeocode(ctx context.Context, latitude, longitude float64) (Address, error)
+}
+
+type NominatimGeocoder struct {
+	Endpoint string
+	Language string
+}
+
+func (g NominatimGeocoder) ReverseGeocode(ctx context.Context, latitude, longitude float64) (addr Address, err error) {
+	tr := &urlfetch.Transport{Context: ctx}
+	r, err := getAddress(tr.RoundTrip, g.Endpoint, g.Language, latitude, longitude)
+	if err != nil {
+		return
+	}
+	addr = r.Address
+	return
+}
+
+// 不對外查詢，只用座標當作位置
+type OfflineGeocoder struct{}
+
+func (g OfflineGeocoder) ReverseGeocode(ctx context.Context, latitude, longitude float64) (Address, error) {
+	return Address{}, nil
+}
+
+type fallbackGeocoder struct {
+	primary  ReverseGeocoder
+	fallback ReverseGeocoder
+}
+
+func (g fallbackGeocoder) ReverseGeocode(ctx context.Context, latitude, longitude float64) (addr Address, err error) {
+	addr, err = g.primary.ReverseGeocode(ctx, latitude, longitude)
+	if err != nil {
+		log.Warningf(ctx, "reverse geocoding failed, use fallback: %s", err)
+		return g.fallback.ReverseGeocode(ctx, latitude, longitude)
+	}
+	return
+}
+
+var reverseGeocoder ReverseGeocoder = fallbackGeocoder{
+	primary:  NominatimGeocoder{Endpoint: NOMINATIM_URI, Language: NOMINATIM_LANGUAGE},
+	fallback: OfflineGeocoder{},
+}
+
+func getAddress(roundTrip func(*http.Request) (*http.Response, error), endpoint, language string, latitude, longitude float64) (r NominatimResult, err error) {
+	v := url.Values{}
+	v.Set("format", "json")
+	v.Set("lat", fmt.Sprintf("%f", latitude))
+	v.Set("lon", fmt.Sprintf("%f", longitude))
+	v.Set("zoom", "18")
+	v.Set("addressdetails", "1")
+	if language != "" {
+		v.Set("accept-language", language)
+	}
+
+	req, err := http.NewRequest("GET", endpoint+"?"+v.Encode(), nil)
+	if err != nil {
+		return
+	}
+	req.Header.Set("User-Agent", GEOCODER_AGENT)
+
+	resp, err := roundTrip(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		buffer := bytes.NewBuffer([]byte{})
+		io.Copy(buffer, resp.Body)
+		err = fmt.Errorf("reverse geocoding status %s: %s", resp.Status, buffer.String())
+		return
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&r)
+	if err != nil {
+		return
+	}
+	if r.Error != "" {
+		err = fmt.Errorf("reverse geocoding error: %s", r.Error)
+	}
+	return
+}
+
+func formatShortAddr(addr Address, latitude, longitude float64) string {
+	if addr.IsEmpty() {
+		return fmt.Sprintf("%.4f,%.4f (%s)", latitude, longitude, geohash.EncodeWithPrecision(latitude, longitude, pinGeohashPrecision))
+	}
+	return addr.Short()
+}
+
+// 只用快取裡的地址填入整組 pin，格子沒有快取時借用周圍格子的地址，都沒有的先顯示座標，回傳需要在背景查詢的 pin (每個格子一個)
+func fillShortAddrs(ctx context.Context, cache GeoCache, pins []PokemonPin) (missed []PokemonPin) {
+	cells := []string{}
+	cellIndex := map[string][]int{}
+	for i, p := range pins {
+		if p.Geohash == "" {
+			pins[i].Geohash = geohash.EncodeWithPrecision(p.Latitude, p.Longitude, pinGeohashPrecision)
+		}
+		cell := pins[i].Geohash
+		if _, ok := cellIndex[cell]; !ok {
+			cells = append(cells, cell)
+		}
+		cellIndex[cell] = append(cellIndex[cell], i)
+	}
+
+	keys := make([]string, len(cells))
+	for i, cell := range cells {
+		keys[i] = addressCacheKey(cell)
+	}
+	cached, err := cache.GetMulti(ctx, keys)
+	if err != nil {
+		log.Errorf(ctx, "error getting addresses: %v", err)
+		cached = map[string][]byte{}
+	}
+
+	values := map[string][]byte{}
+	missedCells := []string{}
+	for _, cell := range cells {
+		if v, ok := cached[addressCacheKey(cell)]; ok {
+			values[cell] = v
+		} else {
+			missedCells = append(missedCells, cell)
+		}
+	}
+
+	// 相鄰格子在同一條街上的機會很大，用它們的地址就不必再 geocode 一次
+	if len(missedCells) > 0 {
+		neighbors, err := getWithNeighbors(ctx, cache, addressCacheKey, missedCells)
+		if err != nil {
+			log.Errorf(ctx, "error getting neighbor addresses: %v", err)
+		}
+		for _, cell := range missedCells {
+			for _, n := range geohash.Neighbors(cell) {
+				if v, ok := neighbors[n]; ok {
+					values[cell] = v
+					break
+				}
+			}
+		}
+	}
+
+	for _, cell := range cells {
+		indexes := cellIndex[cell]
+
+		var addr Address
+		if value, ok := values[cell]; ok {
+			if err := json.Unmarshal(value, &addr); err != nil {
+				log.Errorf(ctx, err.Error())
+			}
+		} else {
+			missed = append(missed, pins[indexes[0]])
+		}
+
+		for _, i := range indexes {
+			pins[i].Address = addr
+			pins[i].ShortAddr = formatShortAddr(addr, pins[i].Latitude, pins[i].Longitude)
+		}
+	}
+	return
+}
+
+// 把沒有地址的格子排進 geocode 佇列，查到的地址下次查詢時就會出現
+func scheduleGeocode(ctx context.Context, pins []PokemonPin) {
+	for _, p := range pins {
+		pending := "geocode:" + p.Geohash
+		if first, err := dedupStore.Claim(ctx, pending, geocodePendingTTL); err == nil && !first {
+			continue
+		}
+
+		v := url.Values{}
+		v.Set("lat", strconv.FormatFloat(p.Latitude, 'f', -1, 64))
+		v.Set("long", strconv.FormatFloat(p.Longitude, 'f', -1, 64))
+		v.Set("geohash", p.Geohash)
+		if _, err := taskqueue.Add(ctx, taskqueue.NewPOSTTask(GEOCODE_TASK_PATH, v), GEOCODE_QUEUE); err != nil {
+			log.Warningf(ctx, "schedule geocode %s: %s", p.Geohash, err)
+			dedupStore.Release(ctx, pending)
+		}
+	}
+}
+
+func init() {
+	http.HandleFunc(GEOCODE_TASK_PATH, geocodeTaskHandler)
+}
+
+// 一個 task 查一個格子的地址並存進快取
+func geocodeTaskHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+
+	// 只接受 App Engine task queue 送來的請求
+	if r.Header.Get("X-AppEngine-QueueName") == "" {
+		http.Error(w, "", http.StatusForbidden)
+		return
+	}
+
+	lat, err1 := strconv.ParseFloat(r.FormValue("lat"), 64)
+	long, err2 := strconv.ParseFloat(r.FormValue("long"), 64)
+	cell := r.FormValue("geohash")
+	if err1 != nil || err2 != nil || cell == "" {
+		// 內容壞掉的 task 重試也沒有用
+		log.Errorf(ctx, "invalid geocode task: %s", r.Form.Encode())
+		return
+	}
+
+	addr, err := reverseGeocoder.ReverseGeocode(ctx, lat, long)
+	if err != nil {
+		log.Errorf(ctx, err.Error())
+		return
+	}
+	log.Infof(ctx, "Address: %+v", addr)
+	if addr.IsEmpty() {
+		return
+	}
+	b, err := json.Marshal(addr)
+	if err == nil {
+		err = geoCache.Set(ctx, addressCacheKey(cell), b, addressCacheTTL)
+	}
+	if err != nil {
+		log.Errorf(ctx, err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprint(w, "")
+}
@@ -0,0 +1,769 @@
+package pokedict
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+)
+
+const (
+	GRAPHQL_PATH = "/graphql"
+
+	gqlMaxQueryLength = 10000
+	gqlMaxDepth       = 8
+	// introspection 的 ofType 會一層層往下查
+	gqlMaxIntrospectionDepth = 16
+	// 一個 selection set 展開 fragment 後最多的欄位數，重複使用 fragment 時展開的數量會倍增
+	gqlMaxFields = 5000
+	// 回應裡最多的欄位和列表項目數
+	gqlMaxResultFields = 20000
+	// 每個欄位算 1，列表乘上預估長度，nearby 另外加上呼叫雷達的成本
+	gqlMaxComplexity = 2000
+	gqlNearbyCost    = 200
+	gqlMaxRadius     = radarMaxDistance
+)
+
+type gqlResolver func(e *gqlExecutor, source interface{}, args map[string]interface{}) (interface{}, error)
+
+type gqlFieldDef struct {
+	// 物件型別的名稱，純量為空字串
+	Type string
+	List bool
+	// 參數名稱 -> 型別，例如 "Int!"
+	Args    map[string]string
+	Resolve gqlResolver
+	// 列表的預估長度，有 limit 參數時以 limit 為準
+	ListSize int
+	Cost     int
+}
+
+type gqlMatchup struct {
+	Type       string
+	Multiplier float64
+}
+
+func gqlScalar(get func(source interface{}) interface{}) gqlFieldDef {
+	return gqlFieldDef{Resolve: func(e *gqlExecutor, source interface{}, args map[string]interface{}) (interface{}, error) {
+		return get(source), nil
+	}}
+}
+
+var gqlLangArgs map[string]string = map[string]string{"lang": "String"}
+
+var gqlListArgs map[string]string = map[string]string{"q": "String", "type": "String", "offset": "Int", "limit": "Int"}
+
+// 型別名稱 -> 欄位名稱 -> 定義，必須和 gqlSchemaSDL 一致
+var gqlSchema map[string]map[string]gqlFieldDef = map[string]map[string]gqlFieldDef{
+	"Query": {
+		"pokemon": {Type: "Pokemon", Args: map[string]string{"id": "Int", "name": "String"}, Resolve: gqlResolvePokemon},
+		"pokemons": {Type: "Pokemon", List: true, Args: gqlListArgs, Resolve: gqlResolvePokemons,
+			ListSize: apiDefaultLimit},
+		"skill": {Type: "PokemonSkill", Args: map[string]string{"id": "Int", "name": "String"}, Resolve: gqlResolveSkill},
+		"skills": {Type: "PokemonSkill", List: true, Args: map[string]string{"q": "String", "type": "String", "kind": "String", "offset": "Int", "limit": "Int"},
+			Resolve: gqlResolveSkills, ListSize: apiDefaultLimit},
+		"nearby": {Type: "PokemonPin", List: true, Args: map[string]string{"lat": "Float!", "long": "Float!", "radius": "Int", "mode": "String"},
+			Resolve: gqlResolveNearby, ListSize: 20, Cost: gqlNearbyCost},
+	},
+	"Pokemon": {
+		"id":             gqlScalar(func(s interface{}) interface{} { return s.(Pokemon).Id }),
+		"name":           gqlScalar(func(s interface{}) interface{} { return s.(Pokemon).Name }),
+		"cname":          gqlScalar(func(s interface{}) interface{} { return s.(Pokemon).Cname }),
+		"scname":         gqlScalar(func(s interface{}) interface{} { return s.(Pokemon).Scname }),
+		"jname":          gqlScalar(func(s interface{}) interface{} { return s.(Pokemon).Jname }),
+		"classification": gqlScalar(func(s interface{}) interface{} { return s.(Pokemon).Classification }),
+		"maxCP":          gqlScalar(func(s interface{}) interface{} { return s.(Pokemon).MaxCP }),
+		"types":          gqlScalar(func(s interface{}) interface{} { return pokemonTypes(s.(Pokemon)) }),
+		"weaknesses":     gqlScalar(func(s interface{}) interface{} { return gqlStrings(s.(Pokemon).Weaknesses) }),
+		"baseAttack":     gqlScalar(func(s interface{}) interface{} { return s.(Pokemon).BaseAttack }),
+		"baseDefense":    gqlScalar(func(s interface{}) interface{} { return s.(Pokemon).BaseDefense }),
+		"baseStamina":    gqlScalar(func(s interface{}) interface{} { return s.(Pokemon).BaseStamina }),
+		"localName": {Args: gqlLangArgs, Resolve: func(e *gqlExecutor, source interface{}, args map[string]interface{}) (interface{}, error) {
+			return source.(Pokemon).LocalName(languageForLocale(gqlString(args, "lang"))), nil
+		}},
+		"fastMoves": {Type: "PokemonSkill", List: true, ListSize: 3, Resolve: func(e *gqlExecutor, source interface{}, args map[string]interface{}) (interface{}, error) {
+			m := source.(Pokemon)
+			return gqlMoves(resolveMoves(m, m.FastMoves)), nil
+		}},
+		"chargedMoves": {Type: "PokemonSkill", List: true, ListSize: 4, Resolve: func(e *gqlExecutor, source interface{}, args map[string]interface{}) (interface{}, error) {
+			m := source.(Pokemon)
+			return gqlMoves(resolveMoves(m, m.ChargedMoves)), nil
+		}},
+		"matchups": {Type: "TypeMatchup", List: true, ListSize: len(allTypes), Resolve: gqlResolveMatchups},
+	},
+	"PokemonSkill": {
+		"id":       gqlScalar(func(s interface{}) interface{} { return s.(PokemonSkill).Id }),
+		"kind":     gqlScalar(func(s interface{}) interface{} { return s.(PokemonSkill).Kind }),
+		"type":     gqlScalar(func(s interface{}) interface{} { return normalizeType(s.(PokemonSkill).Type) }),
+		"name":     gqlScalar(func(s interface{}) interface{} { return s.(PokemonSkill).Name }),
+		"cname":    gqlScalar(func(s interface{}) interface{} { return s.(PokemonSkill).Cname }),
+		"scname":   gqlScalar(func(s interface{}) interface{} { return s.(PokemonSkill).Scname }),
+		"jname":    gqlScalar(func(s interface{}) interface{} { return s.(PokemonSkill).Jname }),
+		"damage":   gqlScalar(func(s interface{}) interface{} { return s.(PokemonSkill).Damage }),
+		"cooldown": gqlScalar(func(s interface{}) interface{} { return s.(PokemonSkill).Cooldown }),
+		"energy":   gqlScalar(func(s interface{}) interface{} { return s.(PokemonSkill).Energy }),
+		"dps":      gqlScalar(func(s interface{}) interface{} { return s.(PokemonSkill).Dps }),
+		"dpe":      gqlScalar(func(s interface{}) interface{} { return s.(PokemonSkill).Dpe }),
+		"eps":      gqlScalar(func(s interface{}) interface{} { return s.(PokemonSkill).Eps }),
+		"localName": {Args: gqlLangArgs, Resolve: func(e *gqlExecutor, source interface{}, args map[string]interface{}) (interface{}, error) {
+			return source.(PokemonSkill).LocalName(languageForLocale(gqlString(args, "lang"))), nil
+		}},
+	},
+	"PokemonPin": {
+		"id":        gqlScalar(func(s interface{}) interface{} { return s.(PokemonPin).Id }),
+		"latitude":  gqlScalar(func(s interface{}) interface{} { return s.(PokemonPin).Latitude }),
+		"longitude": gqlScalar(func(s interface{}) interface{} { return s.(PokemonPin).Longitude }),
+		"distance":  gqlScalar(func(s interface{}) interface{} { return s.(PokemonPin).Distance }),
+		"geohash":   gqlScalar(func(s interface{}) interface{} { return s.(PokemonPin).Geohash }),
+		"reachable": gqlScalar(func(s interface{}) interface{} { return s.(PokemonPin).Reachable }),
+		"travelMinutes": gqlScalar(func(s interface{}) interface{} {
+			return int64(math.Ceil(s.(PokemonPin).TravelTime.Minutes()))
+		}),
+		"disappearTime": gqlScalar(func(s interface{}) interface{} {
+			return time.Unix(s.(PokemonPin).DisappearTime/1000, 0).UTC().Format(time.RFC3339)
+		}),
+		"pokemon": {Type: "Pokemon", Resolve: func(e *gqlExecutor, source interface{}, args map[string]interface{}) (interface{}, error) {
+			return source.(PokemonPin).Pokemon, nil
+		}},
+	},
+	"TypeMatchup": {
+		"type":       gqlScalar(func(s interface{}) interface{} { return s.(gqlMatchup).Type }),
+		"multiplier": gqlScalar(func(s interface{}) interface{} { return s.(gqlMatchup).Multiplier }),
+	},
+}
+
+const gqlSchemaSDL = `type Query {
+  pokemon(id: Int, name: String): Pokemon
+  pokemons(q: String, type: String, offset: Int, limit: Int): [Pokemon!]!
+  skill(id: Int, name: String): PokemonSkill
+  skills(q: String, type: String, kind: String, offset: Int, limit: Int): [PokemonSkill!]!
+  "Rare Pokémon within radius km (at most 5). mode is walk or bike."
+  nearby(lat: Float!, long: Float!, radius: Int, mode: String): [PokemonPin!]!
+}
+
+type Pokemon {
+  id: Int!
+  name: String!
+  cname: String!
+  scname: String!
+  jname: String!
+  localName(lang: String): String!
+  classification: String!
+  maxCP: Int!
+  types: [String!]!
+  weaknesses: [String!]!
+  baseAttack: Int!
+  baseDefense: Int!
+  baseStamina: Int!
+  fastMoves: [PokemonSkill!]!
+  chargedMoves: [PokemonSkill!]!
+  "Damage multiplier of every attacking type against this Pokémon"
+  matchups: [TypeMatchup!]!
+}
+
+type PokemonSkill {
+  id: Int!
+  kind: String!
+  type: String!
+  name: String!
+  cname: String!
+  scname: String!
+  jname: String!
+  localName(lang: String): String!
+  damage: Float!
+  cooldown: Float!
+  energy: Float!
+  dps: Float!
+  dpe: Float!
+  eps: Float!
+}
+
+type PokemonPin {
+  id: String!
+  pokemon: Pokemon!
+  latitude: Float!
+  longitude: Float!
+  distance: Float!
+  geohash: String!
+  disappearTime: String!
+  reachable: Boolean!
+  travelMinutes: Int!
+}
+
+type TypeMatchup {
+  type: String!
+  multiplier: Float!
+}
+`
+
+func gqlStrings(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
+
+func gqlString(args map[string]interface{}, name string) string {
+	s, _ := args[name].(string)
+	return s
+}
+
+func gqlInt(args map[string]interface{}, name string, def int64) int64 {
+	if n, ok := args[name].(int64); ok {
+		return n
+	}
+	return def
+}
+
+// 資料裡找不到的技能略過
+func gqlMoves(moves []ResolvedMove) []interface{} {
+	items := []interface{}{}
+	for _, move := range moves {
+		if move.Found {
+			items = append(items, move.Skill)
+		}
+	}
+	return items
+}
+
+// 和 API 一樣，offset 不能是負數，limit 至少為 1，超過上限時用上限
+func gqlPaging(args map[string]interface{}) (offset, limit int, err error) {
+	offset = int(gqlInt(args, "offset", 0))
+	limit = int(gqlInt(args, "limit", apiDefaultLimit))
+	if offset < 0 {
+		return 0, 0, fmt.Errorf("offset must not be negative")
+	}
+	if limit < 1 {
+		return 0, 0, fmt.Errorf("limit must be positive")
+	}
+	if limit > apiMaxLimit {
+		limit = apiMaxLimit
+	}
+	return
+}
+
+func gqlPage(args map[string]interface{}, total int) (offset, end int, err error) {
+	offset, limit, err := gqlPaging(args)
+	if err != nil {
+		return
+	}
+	if offset > total {
+		offset = total
+	}
+	return offset, pageEnd(offset, limit, total), nil
+}
+
+func gqlResolvePokemon(e *gqlExecutor, source interface{}, args map[string]interface{}) (interface{}, error) {
+	if id, ok := args["id"].(int64); ok {
+		if m, ok := e.data.MonsterById(id); ok {
+			return m, nil
+		}
+		return nil, nil
+	}
+	if m, ok := findMonster(e.ctx, gqlString(args, "name")); ok {
+		return m, nil
+	}
+	return nil, nil
+}
+
+func gqlResolvePokemons(e *gqlExecutor, source interface{}, args map[string]interface{}) (interface{}, error) {
+	q, t := gqlString(args, "q"), gqlString(args, "type")
+	monsters := []Pokemon{}
+	for _, m := range e.data.Monsters {
+		if m.NameContains(q) && (t == "" || containsType(pokemonTypes(m), t)) {
+			monsters = append(monsters, m)
+		}
+	}
+	sort.Sort(monstersById(monsters))
+
+	offset, end, err := gqlPage(args, len(monsters))
+	if err != nil {
+		return nil, err
+	}
+	items := []interface{}{}
+	for _, m := range monsters[offset:end] {
+		items = append(items, m)
+	}
+	return items, nil
+}
+
+func gqlResolveSkill(e *gqlExecutor, source interface{}, args map[string]interface{}) (interface{}, error) {
+	if id, ok := args["id"].(int64); ok {
+		if s, ok := e.data.SkillById(id); ok {
+			return s, nil
+		}
+		return nil, nil
+	}
+	if s, ok := e.data.SkillByName(gqlString(args, "name")); ok {
+		return s, nil
+	}
+	return nil, nil
+}
+
+func gqlResolveSkills(e *gqlExecutor, source interface{}, args map[string]interface{}) (interface{}, error) {
+	q, t, kind := gqlString(args, "q"), gqlString(args, "type"), gqlString(args, "kind")
+	skills := []PokemonSkill{}
+	for _, s := range e.data.Skills {
+		if s.NameContains(q) && (t == "" || normalizeType(s.Type) == normalizeType(t)) && (kind == "" || s.Kind == kind) {
+			skills = append(skills, s)
+		}
+	}
+	sort.Sort(skillsById(skills))
+
+	offset, end, err := gqlPage(args, len(skills))
+	if err != nil {
+		return nil, err
+	}
+	items := []interface{}{}
+	for _, s := range skills[offset:end] {
+		items = append(items, s)
+	}
+	return items, nil
+}
+
+func gqlResolveNearby(e *gqlExecutor, source interface{}, args map[string]interface{}) (interface{}, error) {
+	radius := gqlInt(args, "radius", gqlMaxRadius)
+	if radius < 1 || radius > gqlMaxRadius {
+		return nil, fmt.Errorf("radius must be between 1 and %d", gqlMaxRadius)
+	}
+	mode := gqlString(args, "mode")
+	if mode != "" && mode != TRAVEL_WALK && mode != TRAVEL_BIKE {
+		return nil, fmt.Errorf("unknown mode: %s", mode)
+	}
+
+	pins, err := getPokemonNear(e.ctx, args["lat"].(float64), args["long"].(float64), radius)
+	if err != nil {
+		return nil, err
+	}
+	annotateTravel(pins, mode, time.Now())
+	sortByReachability(pins)
+
+	items := []interface{}{}
+	for _, p := range pins {
+		items = append(items, p)
+	}
+	return items, nil
+}
+
+func gqlResolveMatchups(e *gqlExecutor, source interface{}, args map[string]interface{}) (interface{}, error) {
+	types := pokemonTypes(source.(Pokemon))
+	items := []interface{}{}
+	for _, t := range allTypes {
+		items = append(items, gqlMatchup{t, typeEffectiveness(t, types...)})
+	}
+	return items, nil
+}
+
+// 依照查詢的欄位順序輸出
+type gqlObject struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func (o *gqlObject) set(key string, v interface{}) {
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = v
+}
+
+func (o *gqlObject) MarshalJSON() ([]byte, error) {
+	buf := bytes.NewBufferString("{")
+	for i, k := range o.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		kb, _ := json.Marshal(k)
+		vb, err := json.Marshal(o.values[k])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(kb)
+		buf.WriteByte(':')
+		buf.Write(vb)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+type gqlExecutor struct {
+	ctx       context.Context
+	data      *GameData
+	vars      map[string]interface{}
+	fragments map[string][]gqlField
+
+	// 展開過的 fragment，同一個 fragment 只展開一次
+	expanded  map[string][]gqlField
+	expanding map[string]bool
+	// 已經輸出的欄位數
+	results int
+}
+
+func newGQLExecutor(ctx context.Context, data *GameData, vars map[string]interface{}, fragments map[string][]gqlField) *gqlExecutor {
+	return &gqlExecutor{
+		ctx:       ctx,
+		data:      data,
+		vars:      vars,
+		fragments: fragments,
+		expanded:  map[string][]gqlField{},
+		expanding: map[string]bool{},
+	}
+}
+
+func gqlCoerce(typ string, v interface{}, vars map[string]interface{}) (interface{}, error) {
+	if name, ok := v.(gqlVariable); ok {
+		v = vars[string(name)]
+	}
+	base := strings.TrimSuffix(typ, "!")
+	if v == nil {
+		if base != typ {
+			return nil, fmt.Errorf("expected %s", typ)
+		}
+		return nil, nil
+	}
+
+	switch base {
+	case "Int":
+		switch n := v.(type) {
+		case int64:
+			return n, nil
+		case float64:
+			// 變數從 JSON 來，數字都是 float64
+			if n == math.Trunc(n) {
+				return int64(n), nil
+			}
+		}
+	case "Float":
+		switch n := v.(type) {
+		case int64:
+			return float64(n), nil
+		case float64:
+			return n, nil
+		}
+	case "String":
+		if s, ok := v.(string); ok {
+			return s, nil
+		}
+	case "Boolean":
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+	}
+	return nil, fmt.Errorf("expected %s", typ)
+}
+
+func (e *gqlExecutor) args(def gqlFieldDef, f gqlField) (map[string]interface{}, error) {
+	args := map[string]interface{}{}
+	for name := range f.Args {
+		if _, ok := def.Args[name]; !ok {
+			return nil, fmt.Errorf("unknown argument %q on field %q", name, f.Name)
+		}
+	}
+	for name, typ := range def.Args {
+		v, err := gqlCoerce(typ, f.Args[name], e.vars)
+		if err != nil {
+			return nil, fmt.Errorf("argument %q on field %q: %s", name, f.Name, err)
+		}
+		if v != nil {
+			args[name] = v
+		}
+	}
+	return args, nil
+}
+
+// 展開 fragment，並把同一個 key 的欄位合併
+func (e *gqlExecutor) collectFields(selections []gqlField) ([]gqlField, error) {
+	count := 0
+	return e.collect(selections, 0, &count)
+}
+
+func (e *gqlExecutor) collect(selections []gqlField, depth int, count *int) ([]gqlField, error) {
+	if depth > gqlMaxDepth {
+		return nil, fmt.Errorf("fragments are nested too deep")
+	}
+	fields := []gqlField{}
+	index := map[string]int{}
+	for _, f := range selections {
+		expanded := []gqlField{f}
+		if f.Name == "..." {
+			var err error
+			if f.Fragment != "" {
+				expanded, err = e.expandFragment(f.Fragment, depth, count)
+			} else {
+				expanded, err = e.collect(f.Selections, depth+1, count)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		for _, x := range expanded {
+			// 合併進來的子欄位也要算，否則重複的 fragment 會在下一層才爆開
+			*count += 1 + len(x.Selections)
+			if *count > gqlMaxFields {
+				return nil, fmt.Errorf("query has too many fields after expanding fragments (max %d)", gqlMaxFields)
+			}
+			i, ok := index[x.key()]
+			if !ok {
+				index[x.key()] = len(fields)
+				fields = append(fields, x)
+				continue
+			}
+			if fields[i].Name != x.Name || !reflect.DeepEqual(fields[i].Args, x.Args) {
+				return nil, fmt.Errorf("fields %q conflict", x.key())
+			}
+			fields[i].Selections = append(append([]gqlField{}, fields[i].Selections...), x.Selections...)
+		}
+	}
+	return fields, nil
+}
+
+func (e *gqlExecutor) expandFragment(name string, depth int, count *int) ([]gqlField, error) {
+	if fields, ok := e.expanded[name]; ok {
+		return fields, nil
+	}
+	selections, ok := e.fragments[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown fragment %s", name)
+	}
+	if e.expanding[name] {
+		return nil, fmt.Errorf("fragment %s spreads itself", name)
+	}
+	e.expanding[name] = true
+	fields, err := e.collect(selections, depth+1, count)
+	delete(e.expanding, name)
+	if err != nil {
+		return nil, err
+	}
+	e.expanded[name] = fields
+	return fields, nil
+}
+
+// 檢查欄位和參數，同時計算查詢的複雜度，超過 gqlMaxComplexity 就不再往下算
+func (e *gqlExecutor) complexity(typeName string, selections []gqlField, depth int) (total int, err error) {
+	maxDepth := gqlMaxDepth
+	if strings.HasPrefix(typeName, "__") {
+		maxDepth = gqlMaxIntrospectionDepth
+	}
+	if depth > maxDepth {
+		return 0, fmt.Errorf("query is nested too deep (max %d)", maxDepth)
+	}
+	fields, err := e.collectFields(selections)
+	if err != nil {
+		return
+	}
+	for _, f := range fields {
+		if f.Name == "__typename" {
+			total++
+			continue
+		}
+		def, ok := gqlSchema[typeName][f.Name]
+		if !ok {
+			return 0, fmt.Errorf("cannot query field %q on type %s", f.Name, typeName)
+		}
+		args, err := e.args(def, f)
+		if err != nil {
+			return 0, err
+		}
+
+		cost := 1 + def.Cost
+		if def.Type == "" {
+			if len(f.Selections) != 0 {
+				return 0, fmt.Errorf("field %q on type %s must not have a selection", f.Name, typeName)
+			}
+		} else {
+			if len(f.Selections) == 0 {
+				return 0, fmt.Errorf("field %q on type %s must have a selection of subfields", f.Name, typeName)
+			}
+			child, err := e.complexity(def.Type, f.Selections, depth+1)
+			if err != nil {
+				return 0, err
+			}
+			n := 1
+			if def.List {
+				n = def.ListSize
+				if _, ok := def.Args["limit"]; ok {
+					if _, n, err = gqlPaging(args); err != nil {
+						return 0, fmt.Errorf("%s: %s", f.key(), err)
+					}
+				}
+			}
+			cost += n * child
+		}
+		total += cost
+		if total > gqlMaxComplexity {
+			return total, nil
+		}
+	}
+	return
+}
+
+func (e *gqlExecutor) execute(typeName string, source interface{}, selections []gqlField) (*gqlObject, error) {
+	fields, err := e.collectFields(selections)
+	if err != nil {
+		return nil, err
+	}
+	obj := &gqlObject{values: map[string]interface{}{}}
+	for _, f := range fields {
+		if e.results += 1; e.results > gqlMaxResultFields {
+			return nil, fmt.Errorf("result is too large (max %d fields)", gqlMaxResultFields)
+		}
+		if f.Name == "__typename" {
+			obj.set(f.key(), typeName)
+			continue
+		}
+		def := gqlSchema[typeName][f.Name]
+		args, err := e.args(def, f)
+		if err != nil {
+			return nil, err
+		}
+		v, err := def.Resolve(e, source, args)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", f.key(), err)
+		}
+
+		if def.Type != "" && v != nil {
+			if def.List {
+				list := []interface{}{}
+				for _, item := range v.([]interface{}) {
+					child, err := e.execute(def.Type, item, f.Selections)
+					if err != nil {
+						return nil, err
+					}
+					list = append(list, child)
+				}
+				v = list
+			} else if v, err = e.execute(def.Type, v, f.Selections); err != nil {
+				return nil, err
+			}
+		}
+		obj.set(f.key(), v)
+	}
+	return obj, nil
+}
+
+type gqlRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+type gqlError struct {
+	Message string `json:"message"`
+}
+
+type gqlResponse struct {
+	Data   interface{} `json:"data,omitempty"`
+	Errors []gqlError  `json:"errors,omitempty"`
+}
+
+// 查詢本身有問題時 ok 為 false，執行時的錯誤放在 errors 裡
+func runGraphQL(ctx context.Context, req gqlRequest) (resp gqlResponse, ok bool) {
+	fail := func(err error) (gqlResponse, bool) {
+		return gqlResponse{Errors: []gqlError{gqlError{err.Error()}}}, false
+	}
+	if len(req.Query) > gqlMaxQueryLength {
+		return fail(fmt.Errorf("query is too long (max %d bytes)", gqlMaxQueryLength))
+	}
+	doc, err := parseGraphQL(req.Query)
+	if err != nil {
+		return fail(err)
+	}
+
+	// 只有一個 operation 時可以不指定名稱，有多個時一定要指定
+	var op *gqlOperation
+	for i := range doc.Operations {
+		if req.OperationName == "" && len(doc.Operations) == 1 || doc.Operations[i].Name == req.OperationName {
+			op = &doc.Operations[i]
+			break
+		}
+	}
+	if op == nil && req.OperationName == "" {
+		return fail(fmt.Errorf("operationName is required when the query has more than one operation"))
+	} else if op == nil {
+		return fail(fmt.Errorf("operation %q not found", req.OperationName))
+	}
+	if op.Type != "query" {
+		return fail(fmt.Errorf("%s is not supported", op.Type))
+	}
+
+	vars := map[string]interface{}{}
+	for k, v := range op.Defaults {
+		vars[k] = v
+	}
+	for k, v := range req.Variables {
+		vars[k] = v
+	}
+	e := newGQLExecutor(ctx, loadGameData(ctx), vars, doc.Fragments)
+
+	cost, err := e.complexity("Query", op.Selections, 0)
+	if err != nil {
+		return fail(err)
+	}
+	if cost > gqlMaxComplexity {
+		return fail(fmt.Errorf("query is too complex: %d (max %d)", cost, gqlMaxComplexity))
+	}
+
+	data, err := e.execute("Query", nil, op.Selections)
+	if err != nil {
+		log.Warningf(ctx, "graphql: %s", err)
+		return gqlResponse{Errors: []gqlError{gqlError{err.Error()}}}, true
+	}
+	return gqlResponse{Data: data}, true
+}
+
+func init() {
+	http.HandleFunc(GRAPHQL_PATH, graphqlHandler)
+}
+
+// GET 不帶 query 時回傳 schema，查詢用 POST JSON 或 GET ?query=
+func graphqlHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	var req gqlRequest
+	switch r.Method {
+	case "GET":
+		req.Query = r.FormValue("query")
+		req.OperationName = r.FormValue("operationName")
+		if req.Query == "" {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			fmt.Fprint(w, gqlSchemaSDL)
+			return
+		}
+		if v := r.FormValue("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+				http.Error(w, "invalid variables", http.StatusBadRequest)
+				return
+			}
+		}
+	case "POST":
+		defer r.Body.Close()
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "unable to parse graphql request from body", http.StatusBadRequest)
+			return
+		}
+	case "OPTIONS":
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		return
+	default:
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+
+	resp, ok := runGraphQL(ctx, req)
+	w.Header().Set("Content-Type", "application/json")
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,319 @@
+package pokedict
+
+import "fmt"
+
+// introspection 的型別，和 gqlSchemaSDL 一起解析，__schema 和 __type 的內容都從 SDL 來
+const gqlIntrospectionSDL = `scalar Int
+scalar Float
+scalar String
+scalar Boolean
+
+type __Schema {
+  description: String
+  types: [__Type!]!
+  queryType: __Type!
+  mutationType: __Type
+  subscriptionType: __Type
+  directives: [__Directive!]!
+}
+
+type __Type {
+  kind: __TypeKind!
+  name: String
+  description: String
+  specifiedByURL: String
+  fields(includeDeprecated: Boolean = false): [__Field!]
+  interfaces: [__Type!]
+  possibleTypes: [__Type!]
+  enumValues(includeDeprecated: Boolean = false): [__EnumValue!]
+  inputFields(includeDeprecated: Boolean = false): [__InputValue!]
+  ofType: __Type
+}
+
+type __Field {
+  name: String!
+  description: String
+  args(includeDeprecated: Boolean = false): [__InputValue!]!
+  type: __Type!
+  isDeprecated: Boolean!
+  deprecationReason: String
+}
+
+type __InputValue {
+  name: String!
+  description: String
+  type: __Type!
+  defaultValue: String
+  isDeprecated: Boolean!
+  deprecationReason: String
+}
+
+type __EnumValue {
+  name: String!
+  description: String
+  isDeprecated: Boolean!
+  deprecationReason: String
+}
+
+type __Directive {
+  name: String!
+  description: String
+  locations: [__DirectiveLocation!]!
+  args(includeDeprecated: Boolean = false): [__InputValue!]!
+  isRepeatable: Boolean!
+}
+
+enum __TypeKind {
+  SCALAR
+  OBJECT
+  INTERFACE
+  UNION
+  ENUM
+  INPUT_OBJECT
+  LIST
+  NON_NULL
+}
+
+enum __DirectiveLocation {
+  QUERY
+  MUTATION
+  SUBSCRIPTION
+  FIELD
+  FRAGMENT_DEFINITION
+  FRAGMENT_SPREAD
+  INLINE_FRAGMENT
+  VARIABLE_DEFINITION
+  SCHEMA
+  SCALAR
+  OBJECT
+  FIELD_DEFINITION
+  ARGUMENT_DEFINITION
+  INTERFACE
+  UNION
+  ENUM
+  ENUM_VALUE
+  INPUT_OBJECT
+  INPUT_FIELD_DEFINITION
+}
+`
+
+type gqlIntrospection struct {
+	types map[string]*gqlTypeInfo
+	// 依 SDL 裡的順序
+	names []string
+}
+
+func newGQLIntrospection(sdl string) (*gqlIntrospection, error) {
+	types, err := parseGraphQLSchema(sdl)
+	if err != nil {
+		return nil, err
+	}
+	schema := &gqlIntrospection{types: map[string]*gqlTypeInfo{}}
+	for _, t := range types {
+		if _, ok := schema.types[t.Name]; ok {
+			return nil, fmt.Errorf("duplicate type %s", t.Name)
+		}
+		schema.types[t.Name] = t
+		schema.names = append(schema.names, t.Name)
+	}
+	return schema, nil
+}
+
+func (s *gqlIntrospection) kind(t *gqlTypeRef) string {
+	if t.Kind != "" {
+		return t.Kind
+	}
+	return s.types[t.Name].Kind
+}
+
+var gqlIntrospectionSchema *gqlIntrospection
+
+// 指令都不支援，directives 一律是空的
+type gqlDirectiveInfo struct {
+	Name        string
+	Description string
+	Locations   []string
+	Args        []gqlInputValueInfo
+}
+
+// 空字串回傳 null
+func gqlOptional(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func gqlIntrospectionResolver(f func(source interface{}, args map[string]interface{}) interface{}) gqlFieldDef {
+	return gqlFieldDef{Resolve: func(e *gqlExecutor, source interface{}, args map[string]interface{}) (interface{}, error) {
+		return f(source, args), nil
+	}}
+}
+
+// introspection 的列表長度不固定，複雜度每個只算一個，實際大小由 gqlMaxResultFields 限制
+func gqlIntrospectionList(typeName string, args map[string]string, f func(source interface{}) []interface{}) gqlFieldDef {
+	return gqlFieldDef{Type: typeName, List: true, ListSize: 1, Args: args, Resolve: func(e *gqlExecutor, source interface{}, args map[string]interface{}) (interface{}, error) {
+		if items := f(source); items != nil {
+			return items, nil
+		}
+		return nil, nil
+	}}
+}
+
+func gqlIntrospectionObject(typeName string, f func(source interface{}) interface{}) gqlFieldDef {
+	return gqlFieldDef{Type: typeName, Resolve: func(e *gqlExecutor, source interface{}, args map[string]interface{}) (interface{}, error) {
+		return f(source), nil
+	}}
+}
+
+func gqlTypeRefs(refs ...*gqlTypeRef) []interface{} {
+	items := []interface{}{}
+	for _, t := range refs {
+		items = append(items, t)
+	}
+	return items
+}
+
+func gqlInputValues(values []gqlInputValueInfo) []interface{} {
+	items := []interface{}{}
+	for _, v := range values {
+		items = append(items, v)
+	}
+	return items
+}
+
+var gqlDeprecatedArgs map[string]string = map[string]string{"includeDeprecated": "Boolean"}
+
+var gqlIntrospectionFields map[string]map[string]gqlFieldDef = map[string]map[string]gqlFieldDef{
+	"__Schema": {
+		"description": gqlIntrospectionResolver(func(s interface{}, args map[string]interface{}) interface{} { return nil }),
+		"types": gqlIntrospectionList("__Type", nil, func(s interface{}) []interface{} {
+			refs := []*gqlTypeRef{}
+			for _, name := range s.(*gqlIntrospection).names {
+				refs = append(refs, &gqlTypeRef{Name: name})
+			}
+			return gqlTypeRefs(refs...)
+		}),
+		"queryType":        gqlIntrospectionObject("__Type", func(s interface{}) interface{} { return &gqlTypeRef{Name: "Query"} }),
+		"mutationType":     gqlIntrospectionObject("__Type", func(s interface{}) interface{} { return nil }),
+		"subscriptionType": gqlIntrospectionObject("__Type", func(s interface{}) interface{} { return nil }),
+		"directives":       gqlIntrospectionList("__Directive", nil, func(s interface{}) []interface{} { return []interface{}{} }),
+	},
+	"__Type": {
+		"kind": gqlIntrospectionResolver(func(s interface{}, args map[string]interface{}) interface{} {
+			return gqlIntrospectionSchema.kind(s.(*gqlTypeRef))
+		}),
+		"name": gqlIntrospectionResolver(func(s interface{}, args map[string]interface{}) interface{} { return gqlOptional(s.(*gqlTypeRef).Name) }),
+		"description": gqlIntrospectionResolver(func(s interface{}, args map[string]interface{}) interface{} {
+			if t, ok := gqlIntrospectionSchema.types[s.(*gqlTypeRef).Name]; ok {
+				return gqlOptional(t.Description)
+			}
+			return nil
+		}),
+		"specifiedByURL": gqlIntrospectionResolver(func(s interface{}, args map[string]interface{}) interface{} { return nil }),
+		"fields": gqlIntrospectionList("__Field", gqlDeprecatedArgs, func(s interface{}) []interface{} {
+			t, ok := gqlIntrospectionSchema.types[s.(*gqlTypeRef).Name]
+			if !ok || t.Kind != "OBJECT" {
+				return nil
+			}
+			items := []interface{}{}
+			for _, f := range t.Fields {
+				items = append(items, f)
+			}
+			return items
+		}),
+		"interfaces": gqlIntrospectionList("__Type", nil, func(s interface{}) []interface{} {
+			if gqlIntrospectionSchema.kind(s.(*gqlTypeRef)) != "OBJECT" {
+				return nil
+			}
+			return []interface{}{}
+		}),
+		"possibleTypes": gqlIntrospectionList("__Type", nil, func(s interface{}) []interface{} { return nil }),
+		"enumValues": gqlIntrospectionList("__EnumValue", gqlDeprecatedArgs, func(s interface{}) []interface{} {
+			t, ok := gqlIntrospectionSchema.types[s.(*gqlTypeRef).Name]
+			if !ok || t.Kind != "ENUM" {
+				return nil
+			}
+			items := []interface{}{}
+			for _, v := range t.EnumValues {
+				items = append(items, v)
+			}
+			return items
+		}),
+		"inputFields": gqlIntrospectionList("__InputValue", gqlDeprecatedArgs, func(s interface{}) []interface{} { return nil }),
+		"ofType": gqlIntrospectionObject("__Type", func(s interface{}) interface{} {
+			if t := s.(*gqlTypeRef).OfType; t != nil {
+				return t
+			}
+			return nil
+		}),
+	},
+	"__Field": {
+		"name": gqlIntrospectionResolver(func(s interface{}, args map[string]interface{}) interface{} { return s.(gqlFieldInfo).Name }),
+		"description": gqlIntrospectionResolver(func(s interface{}, args map[string]interface{}) interface{} {
+			return gqlOptional(s.(gqlFieldInfo).Description)
+		}),
+		"args": gqlIntrospectionList("__InputValue", gqlDeprecatedArgs, func(s interface{}) []interface{} {
+			return gqlInputValues(s.(gqlFieldInfo).Args)
+		}),
+		"type":              gqlIntrospectionObject("__Type", func(s interface{}) interface{} { return s.(gqlFieldInfo).Type }),
+		"isDeprecated":      gqlIntrospectionResolver(func(s interface{}, args map[string]interface{}) interface{} { return false }),
+		"deprecationReason": gqlIntrospectionResolver(func(s interface{}, args map[string]interface{}) interface{} { return nil }),
+	},
+	"__InputValue": {
+		"name": gqlIntrospectionResolver(func(s interface{}, args map[string]interface{}) interface{} { return s.(gqlInputValueInfo).Name }),
+		"description": gqlIntrospectionResolver(func(s interface{}, args map[string]interface{}) interface{} {
+			return gqlOptional(s.(gqlInputValueInfo).Description)
+		}),
+		"type": gqlIntrospectionObject("__Type", func(s interface{}) interface{} { return s.(gqlInputValueInfo).Type }),
+		"defaultValue": gqlIntrospectionResolver(func(s interface{}, args map[string]interface{}) interface{} {
+			if v := s.(gqlInputValueInfo).DefaultValue; v != nil {
+				return *v
+			}
+			return nil
+		}),
+		"isDeprecated":      gqlIntrospectionResolver(func(s interface{}, args map[string]interface{}) interface{} { return false }),
+		"deprecationReason": gqlIntrospectionResolver(func(s interface{}, args map[string]interface{}) interface{} { return nil }),
+	},
+	"__EnumValue": {
+		"name":              gqlIntrospectionResolver(func(s interface{}, args map[string]interface{}) interface{} { return s.(string) }),
+		"description":       gqlIntrospectionResolver(func(s interface{}, args map[string]interface{}) interface{} { return nil }),
+		"isDeprecated":      gqlIntrospectionResolver(func(s interface{}, args map[string]interface{}) interface{} { return false }),
+		"deprecationReason": gqlIntrospectionResolver(func(s interface{}, args map[string]interface{}) interface{} { return nil }),
+	},
+	"__Directive": {
+		"name": gqlIntrospectionResolver(func(s interface{}, args map[string]interface{}) interface{} { return s.(gqlDirectiveInfo).Name }),
+		"description": gqlIntrospectionResolver(func(s interface{}, args map[string]interface{}) interface{} {
+			return gqlOptional(s.(gqlDirectiveInfo).Description)
+		}),
+		"locations": gqlIntrospectionResolver(func(s interface{}, args map[string]interface{}) interface{} {
+			return gqlStrings(s.(gqlDirectiveInfo).Locations)
+		}),
+		"args": gqlIntrospectionList("__InputValue", gqlDeprecatedArgs, func(s interface{}) []interface{} {
+			return gqlInputValues(s.(gqlDirectiveInfo).Args)
+		}),
+		"isRepeatable": gqlIntrospectionResolver(func(s interface{}, args map[string]interface{}) interface{} { return false }),
+	},
+}
+
+func init() {
+	schema, err := newGQLIntrospection(gqlSchemaSDL + "\n" + gqlIntrospectionSDL)
+	if err != nil {
+		panic(fmt.Sprintf("graphql schema: %s", err))
+	}
+	gqlIntrospectionSchema = schema
+
+	for name, fields := range gqlIntrospectionFields {
+		gqlSchema[name] = fields
+	}
+	// introspection 的欄位不寫在 SDL 的 Query 裡
+	gqlSchema["Query"]["__schema"] = gqlIntrospectionObject("__Schema", func(s interface{}) interface{} { return gqlIntrospectionSchema })
+	gqlSchema["Query"]["__type"] = gqlFieldDef{Type: "__Type", Args: map[string]string{"name": "String!"},
+		Resolve: func(e *gqlExecutor, source interface{}, args map[string]interface{}) (interface{}, error) {
+			name := gqlString(args, "name")
+			if _, ok := gqlIntrospectionSchema.types[name]; !ok {
+				return nil, nil
+			}
+			return &gqlTypeRef{Name: name}, nil
+		}}
+}
@@ -0,0 +1,623 @@
+package pokedict
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// 只實作查詢會用到的 GraphQL 語法：query、變數、別名、參數、fragment
+// 不支援 mutation、subscription、directive 和 block string
+// schema 只用到 type、enum、scalar 和描述，給 introspection 用
+
+type gqlToken struct {
+	kind  byte // 'n' 名稱、'i' 整數、'f' 浮點數、's' 字串、'p' 符號，0 表示結束
+	value string
+	pos   int
+}
+
+type gqlVariable string
+
+type gqlField struct {
+	Alias      string
+	Name       string
+	Args       map[string]interface{}
+	Selections []gqlField
+	// fragment spread 的名稱；inline fragment 時 Name 為 "..." 而 Fragment 為空
+	Fragment string
+}
+
+// 回應裡用的 key，有別名時用別名
+func (f gqlField) key() string {
+	if f.Alias != "" {
+		return f.Alias
+	}
+	return f.Name
+}
+
+type gqlOperation struct {
+	Type       string
+	Name       string
+	Defaults   map[string]interface{}
+	Selections []gqlField
+}
+
+type gqlDocument struct {
+	Operations []gqlOperation
+	Fragments  map[string][]gqlField
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func gqlLex(src string) (tokens []gqlToken, err error) {
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			i++
+		case c == '#':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(src[i:], "..."):
+			tokens = append(tokens, gqlToken{'p', "...", i})
+			i += 3
+		case strings.IndexByte("!$():=@[]{}|", c) >= 0:
+			tokens = append(tokens, gqlToken{'p', string(c), i})
+			i++
+		case isNameStart(c):
+			start := i
+			for i < len(src) && (isNameStart(src[i]) || isDigit(src[i])) {
+				i++
+			}
+			tokens = append(tokens, gqlToken{'n', src[start:i], start})
+		case c == '-' || isDigit(c):
+			start := i
+			kind := byte('i')
+			i++
+			for i < len(src) && isDigit(src[i]) {
+				i++
+			}
+			if i < len(src) && src[i] == '.' {
+				kind = 'f'
+				i++
+				for i < len(src) && isDigit(src[i]) {
+					i++
+				}
+			}
+			if i < len(src) && (src[i] == 'e' || src[i] == 'E') {
+				kind = 'f'
+				i++
+				if i < len(src) && (src[i] == '+' || src[i] == '-') {
+					i++
+				}
+				for i < len(src) && isDigit(src[i]) {
+					i++
+				}
+			}
+			tokens = append(tokens, gqlToken{kind, src[start:i], start})
+		case c == '"':
+			if strings.HasPrefix(src[i:], `"""`) {
+				return nil, fmt.Errorf("block strings are not supported (position %d)", i)
+			}
+			start := i
+			for i++; i < len(src) && src[i] != '"'; i++ {
+				if src[i] == '\\' {
+					i++
+				} else if src[i] == '\n' {
+					break
+				}
+			}
+			if i >= len(src) || src[i] != '"' {
+				return nil, fmt.Errorf("unterminated string (position %d)", start)
+			}
+			i++
+			// 跳脫字元和 JSON 相同
+			var s string
+			if err := json.Unmarshal([]byte(src[start:i]), &s); err != nil {
+				return nil, fmt.Errorf("invalid string (position %d)", start)
+			}
+			tokens = append(tokens, gqlToken{'s', s, start})
+		default:
+			return nil, fmt.Errorf("unexpected character %q (position %d)", c, i)
+		}
+	}
+	return append(tokens, gqlToken{0, "", len(src)}), nil
+}
+
+type gqlParser struct {
+	tokens []gqlToken
+	pos    int
+}
+
+func (p *gqlParser) peek() gqlToken {
+	return p.tokens[p.pos]
+}
+
+func (p *gqlParser) next() gqlToken {
+	t := p.tokens[p.pos]
+	if t.kind != 0 {
+		p.pos++
+	}
+	return t
+}
+
+func (p *gqlParser) isPunct(value string) bool {
+	t := p.peek()
+	return t.kind == 'p' && t.value == value
+}
+
+func (p *gqlParser) unexpected() error {
+	t := p.peek()
+	if t.kind == 0 {
+		return fmt.Errorf("unexpected end of query")
+	}
+	return fmt.Errorf("unexpected %q (position %d)", t.value, t.pos)
+}
+
+func (p *gqlParser) expect(value string) error {
+	if !p.isPunct(value) {
+		return p.unexpected()
+	}
+	p.next()
+	return nil
+}
+
+func (p *gqlParser) name() (string, error) {
+	if p.peek().kind != 'n' {
+		return "", p.unexpected()
+	}
+	return p.next().value, nil
+}
+
+func parseGraphQL(src string) (doc gqlDocument, err error) {
+	tokens, err := gqlLex(src)
+	if err != nil {
+		return
+	}
+	p := &gqlParser{tokens: tokens}
+	doc.Fragments = map[string][]gqlField{}
+
+	for p.peek().kind != 0 {
+		if p.isPunct("{") {
+			op := gqlOperation{Type: "query"}
+			if op.Selections, err = p.selectionSet(); err != nil {
+				return
+			}
+			doc.Operations = append(doc.Operations, op)
+			continue
+		}
+
+		keyword, err := p.name()
+		if err != nil {
+			return doc, err
+		}
+		switch keyword {
+		case "query", "mutation", "subscription":
+			op, err := p.operation(keyword)
+			if err != nil {
+				return doc, err
+			}
+			doc.Operations = append(doc.Operations, op)
+		case "fragment":
+			name, err := p.name()
+			if err != nil {
+				return doc, err
+			}
+			if on, err := p.name(); err != nil || on != "on" {
+				return doc, fmt.Errorf("expected type condition for fragment %s", name)
+			}
+			if _, err := p.name(); err != nil {
+				return doc, err
+			}
+			if _, ok := doc.Fragments[name]; ok {
+				return doc, fmt.Errorf("duplicate fragment %s", name)
+			}
+			if doc.Fragments[name], err = p.selectionSet(); err != nil {
+				return doc, err
+			}
+		default:
+			return doc, fmt.Errorf("unexpected %q", keyword)
+		}
+	}
+	if len(doc.Operations) == 0 {
+		return doc, fmt.Errorf("no operation in query")
+	}
+	names := map[string]bool{}
+	for _, op := range doc.Operations {
+		if op.Name == "" && len(doc.Operations) > 1 {
+			return doc, fmt.Errorf("anonymous operation must be the only operation in the query")
+		}
+		if names[op.Name] {
+			return doc, fmt.Errorf("duplicate operation %s", op.Name)
+		}
+		names[op.Name] = true
+	}
+	return
+}
+
+func (p *gqlParser) operation(opType string) (op gqlOperation, err error) {
+	op.Type = opType
+	op.Defaults = map[string]interface{}{}
+	if p.peek().kind == 'n' {
+		op.Name = p.next().value
+	}
+
+	if p.isPunct("(") {
+		p.next()
+		variables := map[string]bool{}
+		for !p.isPunct(")") {
+			if err = p.expect("$"); err != nil {
+				return
+			}
+			pos := p.peek().pos
+			var name string
+			if name, err = p.name(); err != nil {
+				return
+			}
+			if variables[name] {
+				return op, fmt.Errorf("duplicate variable $%s (position %d)", name, pos)
+			}
+			variables[name] = true
+			if err = p.expect(":"); err != nil {
+				return
+			}
+			if err = p.skipType(); err != nil {
+				return
+			}
+			if p.isPunct("=") {
+				p.next()
+				if op.Defaults[name], err = p.value(); err != nil {
+					return
+				}
+			}
+		}
+		p.next()
+	}
+
+	if p.isPunct("@") {
+		return op, fmt.Errorf("directives are not supported")
+	}
+	op.Selections, err = p.selectionSet()
+	return
+}
+
+// 變數的型別只在使用時檢查，這裡只跳過
+func (p *gqlParser) skipType() (err error) {
+	if p.isPunct("[") {
+		p.next()
+		if err = p.skipType(); err != nil {
+			return
+		}
+		if err = p.expect("]"); err != nil {
+			return
+		}
+	} else if _, err = p.name(); err != nil {
+		return
+	}
+	if p.isPunct("!") {
+		p.next()
+	}
+	return
+}
+
+func (p *gqlParser) selectionSet() (fields []gqlField, err error) {
+	if err = p.expect("{"); err != nil {
+		return
+	}
+	for !p.isPunct("}") {
+		var f gqlField
+		if f, err = p.selection(); err != nil {
+			return
+		}
+		fields = append(fields, f)
+	}
+	p.next()
+	if len(fields) == 0 {
+		err = fmt.Errorf("empty selection set")
+	}
+	return
+}
+
+func (p *gqlParser) selection() (f gqlField, err error) {
+	if p.isPunct("...") {
+		p.next()
+		f.Name = "..."
+		if t := p.peek(); t.kind == 'n' && t.value != "on" {
+			f.Fragment = p.next().value
+			return
+		}
+		if t := p.peek(); t.kind == 'n' && t.value == "on" {
+			p.next()
+			if _, err = p.name(); err != nil {
+				return
+			}
+		}
+		f.Selections, err = p.selectionSet()
+		return
+	}
+
+	if f.Name, err = p.name(); err != nil {
+		return
+	}
+	if p.isPunct(":") {
+		p.next()
+		f.Alias = f.Name
+		if f.Name, err = p.name(); err != nil {
+			return
+		}
+	}
+
+	if p.isPunct("(") {
+		p.next()
+		f.Args = map[string]interface{}{}
+		for !p.isPunct(")") {
+			pos := p.peek().pos
+			var name string
+			if name, err = p.name(); err != nil {
+				return
+			}
+			if _, ok := f.Args[name]; ok {
+				return f, fmt.Errorf("duplicate argument %q on field %q (position %d)", name, f.Name, pos)
+			}
+			if err = p.expect(":"); err != nil {
+				return
+			}
+			if f.Args[name], err = p.value(); err != nil {
+				return
+			}
+		}
+		p.next()
+	}
+
+	if p.isPunct("@") {
+		return f, fmt.Errorf("directives are not supported")
+	}
+	if p.isPunct("{") {
+		f.Selections, err = p.selectionSet()
+	}
+	return
+}
+
+func (p *gqlParser) value() (v interface{}, err error) {
+	t := p.next()
+	switch t.kind {
+	case 'i':
+		return strconv.ParseInt(t.value, 10, 64)
+	case 'f':
+		return strconv.ParseFloat(t.value, 64)
+	case 's':
+		return t.value, nil
+	case 'n':
+		switch t.value {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+		// enum 當成字串處理
+		return t.value, nil
+	case 'p':
+		switch t.value {
+		case "$":
+			name, err := p.name()
+			return gqlVariable(name), err
+		case "[":
+			list := []interface{}{}
+			for !p.isPunct("]") {
+				item, err := p.value()
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, item)
+			}
+			p.next()
+			return list, nil
+		case "{":
+			obj := map[string]interface{}{}
+			for !p.isPunct("}") {
+				name, err := p.name()
+				if err != nil {
+					return nil, err
+				}
+				if err := p.expect(":"); err != nil {
+					return nil, err
+				}
+				if obj[name], err = p.value(); err != nil {
+					return nil, err
+				}
+			}
+			p.next()
+			return obj, nil
+		}
+	}
+	if t.kind != 0 {
+		p.pos--
+	}
+	return nil, p.unexpected()
+}
+
+// 型別參照，外層是 NON_NULL 或 LIST，最內層是具名型別
+type gqlTypeRef struct {
+	Kind   string
+	Name   string
+	OfType *gqlTypeRef
+}
+
+func (t *gqlTypeRef) String() string {
+	switch t.Kind {
+	case "NON_NULL":
+		return t.OfType.String() + "!"
+	case "LIST":
+		return "[" + t.OfType.String() + "]"
+	}
+	return t.Name
+}
+
+// 最內層的具名型別
+func (t *gqlTypeRef) named() *gqlTypeRef {
+	for t.OfType != nil {
+		t = t.OfType
+	}
+	return t
+}
+
+type gqlInputValueInfo struct {
+	Name         string
+	Description  string
+	Type         *gqlTypeRef
+	DefaultValue *string
+}
+
+type gqlFieldInfo struct {
+	Name        string
+	Description string
+	Args        []gqlInputValueInfo
+	Type        *gqlTypeRef
+}
+
+type gqlTypeInfo struct {
+	Kind        string
+	Name        string
+	Description string
+	Fields      []gqlFieldInfo
+	EnumValues  []string
+}
+
+func parseGraphQLSchema(src string) (types []*gqlTypeInfo, err error) {
+	tokens, err := gqlLex(src)
+	if err != nil {
+		return
+	}
+	p := &gqlParser{tokens: tokens}
+	for p.peek().kind != 0 {
+		t := &gqlTypeInfo{Description: p.description()}
+		var keyword string
+		if keyword, err = p.name(); err != nil {
+			return
+		}
+		if t.Name, err = p.name(); err != nil {
+			return
+		}
+		switch keyword {
+		case "type":
+			t.Kind = "OBJECT"
+			t.Fields, err = p.fieldDefinitions()
+		case "enum":
+			t.Kind = "ENUM"
+			t.EnumValues, err = p.enumValues()
+		case "scalar":
+			t.Kind = "SCALAR"
+		default:
+			err = fmt.Errorf("unexpected %q", keyword)
+		}
+		if err != nil {
+			return
+		}
+		types = append(types, t)
+	}
+	return
+}
+
+func (p *gqlParser) description() string {
+	if p.peek().kind == 's' {
+		return p.next().value
+	}
+	return ""
+}
+
+func (p *gqlParser) typeRef() (t *gqlTypeRef, err error) {
+	if p.isPunct("[") {
+		p.next()
+		t = &gqlTypeRef{Kind: "LIST"}
+		if t.OfType, err = p.typeRef(); err != nil {
+			return
+		}
+		if err = p.expect("]"); err != nil {
+			return
+		}
+	} else {
+		t = &gqlTypeRef{}
+		if t.Name, err = p.name(); err != nil {
+			return
+		}
+	}
+	if p.isPunct("!") {
+		p.next()
+		t = &gqlTypeRef{Kind: "NON_NULL", OfType: t}
+	}
+	return
+}
+
+func (p *gqlParser) fieldDefinitions() (fields []gqlFieldInfo, err error) {
+	if err = p.expect("{"); err != nil {
+		return
+	}
+	for !p.isPunct("}") {
+		f := gqlFieldInfo{Description: p.description()}
+		if f.Name, err = p.name(); err != nil {
+			return
+		}
+		if p.isPunct("(") {
+			p.next()
+			for !p.isPunct(")") {
+				arg := gqlInputValueInfo{Description: p.description()}
+				if arg.Name, err = p.name(); err != nil {
+					return
+				}
+				if err = p.expect(":"); err != nil {
+					return
+				}
+				if arg.Type, err = p.typeRef(); err != nil {
+					return
+				}
+				if p.isPunct("=") {
+					p.next()
+					var v interface{}
+					if v, err = p.value(); err != nil {
+						return
+					}
+					// 預設值用 GraphQL 的寫法，字串、數字和布林值和 JSON 相同
+					b, _ := json.Marshal(v)
+					defaultValue := string(b)
+					arg.DefaultValue = &defaultValue
+				}
+				f.Args = append(f.Args, arg)
+			}
+			p.next()
+		}
+		if err = p.expect(":"); err != nil {
+			return
+		}
+		if f.Type, err = p.typeRef(); err != nil {
+			return
+		}
+		fields = append(fields, f)
+	}
+	p.next()
+	return
+}
+
+func (p *gqlParser) enumValues() (values []string, err error) {
+	if err = p.expect("{"); err != nil {
+		return
+	}
+	for !p.isPunct("}") {
+		var v string
+		if v, err = p.name(); err != nil {
+			return
+		}
+		values = append(values, v)
+	}
+	p.next()
+	return
+}
@@ -0,0 +1,128 @@
+package pokedict
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseGraphQL(t *testing.T) {
+	doc, err := parseGraphQL(`
+		# 註解
+		query Find($id: Int = 9, $names: [String!]!) {
+			p: pokemon(id: $id) { name ...Moves }
+			skills(q: "水\n", limit: 2, offset: -1) { ... on PokemonSkill { id } }
+		}
+		fragment Moves on Pokemon { fastMoves { dps } }
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Operations) != 1 {
+		t.Fatalf("got %d operations", len(doc.Operations))
+	}
+	op := doc.Operations[0]
+	if op.Type != "query" || op.Name != "Find" || !reflect.DeepEqual(op.Defaults, map[string]interface{}{"id": int64(9)}) {
+		t.Errorf("got operation %s %s %v", op.Type, op.Name, op.Defaults)
+	}
+
+	want := []gqlField{
+		{Alias: "p", Name: "pokemon", Args: map[string]interface{}{"id": gqlVariable("id")}, Selections: []gqlField{
+			{Name: "name"},
+			{Name: "...", Fragment: "Moves"},
+		}},
+		{Name: "skills", Args: map[string]interface{}{"q": "水\n", "limit": int64(2), "offset": int64(-1)}, Selections: []gqlField{
+			{Name: "...", Selections: []gqlField{{Name: "id"}}},
+		}},
+	}
+	if !reflect.DeepEqual(op.Selections, want) {
+		t.Errorf("got selections %+v, want %+v", op.Selections, want)
+	}
+	if fragment := doc.Fragments["Moves"]; len(fragment) != 1 || fragment[0].Name != "fastMoves" {
+		t.Errorf("got fragment %+v", fragment)
+	}
+
+	// 沒有名稱的 query 可以省略 query 關鍵字
+	doc, err = parseGraphQL(`{ skill(id: 1.5e1, name: ENUM_VALUE) { id } }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if args := doc.Operations[0].Selections[0].Args; args["id"] != 15.0 || args["name"] != "ENUM_VALUE" {
+		t.Errorf("got args %v", args)
+	}
+}
+
+func TestParseGraphQLErrors(t *testing.T) {
+	tests := []struct {
+		query string
+		err   string
+	}{
+		{``, "no operation"},
+		{`fragment A on Query { id }`, "no operation"},
+		{`{ skill(id: 1) { id } `, "unexpected end"},
+		{`{ skill { } }`, "empty selection set"},
+		{`{ skill(id: 1, id: 2) { id } }`, `duplicate argument "id"`},
+		{`query ($id: Int, $id: Int) { skill(id: $id) { id } }`, "duplicate variable $id"},
+		{`{ skill(id: 1) { id } } mutation { x }`, "anonymous operation"},
+		{`{ a: skill(id: 1) { id } } { b: skill(id: 2) { id } }`, "anonymous operation"},
+		{`query A { skill(id: 1) { id } } query A { skill(id: 2) { id } }`, "duplicate operation A"},
+		{`query A { ...F } fragment F on Query { skills { id } } fragment F on Query { skills { name } }`, "duplicate fragment F"},
+		{`{ skill(name: "abc) { id } }`, "unterminated string"},
+		{`{ skill(name: """abc""") { id } }`, "block strings"},
+		{`{ skill(id: 1) @include(if: true) { id } }`, "directives"},
+		{`{ skill(id: 1) { id } } subscription`, "unexpected end"},
+		{`{ skill(id: 1) { id; } }`, "unexpected character"},
+	}
+	for _, test := range tests {
+		_, err := parseGraphQL(test.query)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: got error %v, want %q", test.query, err, test.err)
+		}
+	}
+}
+
+func TestParseGraphQLSchema(t *testing.T) {
+	types, err := parseGraphQLSchema(`
+		"A thing"
+		type Thing {
+			"The id"
+			id: Int!
+			tags(first: Int = 10, kind: String): [String!]
+		}
+		enum Color { RED GREEN }
+		scalar Date
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(types) != 3 {
+		t.Fatalf("got %d types", len(types))
+	}
+	thing := types[0]
+	if thing.Kind != "OBJECT" || thing.Name != "Thing" || thing.Description != "A thing" || len(thing.Fields) != 2 {
+		t.Fatalf("got %+v", thing)
+	}
+	if f := thing.Fields[0]; f.Description != "The id" || f.Type.String() != "Int!" || f.Type.named().Name != "Int" {
+		t.Errorf("got field %+v", f)
+	}
+	tags := thing.Fields[1]
+	if tags.Type.String() != "[String!]" || len(tags.Args) != 2 {
+		t.Fatalf("got field %+v", tags)
+	}
+	if arg := tags.Args[0]; arg.Name != "first" || arg.Type.String() != "Int" || arg.DefaultValue == nil || *arg.DefaultValue != "10" {
+		t.Errorf("got arg %+v", arg)
+	}
+	if arg := tags.Args[1]; arg.DefaultValue != nil {
+		t.Errorf("got default value %q", *arg.DefaultValue)
+	}
+	if color := types[1]; color.Kind != "ENUM" || !reflect.DeepEqual(color.EnumValues, []string{"RED", "GREEN"}) {
+		t.Errorf("got %+v", color)
+	}
+	if date := types[2]; date.Kind != "SCALAR" || date.Name != "Date" {
+		t.Errorf("got %+v", date)
+	}
+
+	if _, err := parseGraphQLSchema(`interface Node { id: Int }`); err == nil {
+		t.Error("interfaces are not supported")
+	}
+}
@@ -0,0 +1,407 @@
+package pokedict
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func runTestGraphQL(t *testing.T, query string, vars map[string]interface{}) (string, bool) {
+	resp, ok := runGraphQL(testCtx, gqlRequest{Query: query, Variables: vars})
+	b, err := json.Marshal(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b), ok
+}
+
+func TestGraphQLExecute(t *testing.T) {
+	defer gameData.Store(currentGameData())
+	gameData.Store(apiTestGameData(t))
+
+	tests := []struct {
+		query string
+		vars  map[string]interface{}
+		want  string
+	}{
+		{`{ skill(id: 1016) { name kind dpe } }`, nil,
+			`{"data":{"skill":{"name":"Hydro Pump","kind":"charged","dpe":1}}}`},
+		{`{ a: skill(name: "水砲") { id } b: skill(id: 999) { id } }`, nil,
+			`{"data":{"a":{"id":1016},"b":null}}`},
+		{`query ($type: String = "Ice") { pokemons(type: $type) { id types } }`, nil,
+			`{"data":{"pokemons":[{"id":131,"types":["Water","Ice"]},{"id":144,"types":["Ice","Flying"]}]}}`},
+		{`query ($type: String = "Ice", $limit: Int) { pokemons(type: $type, limit: $limit) { id } }`, map[string]interface{}{"type": "Water", "limit": 2.0},
+			`{"data":{"pokemons":[{"id":7},{"id":9}]}}`},
+		{`{ skills(kind: "fast") { __typename id } }`, nil,
+			`{"data":{"skills":[{"__typename":"PokemonSkill","id":12},{"__typename":"PokemonSkill","id":200}]}}`},
+		// 同一個 key 的欄位合併子欄位
+		{`{ pokemon(id: 9) { name ...Moves fastMoves { id } ... on Pokemon { maxCP } } } fragment Moves on Pokemon { fastMoves { name } }`, nil,
+			`{"data":{"pokemon":{"name":"Blastoise","fastMoves":[{"name":"Water Gun","id":12},{"name":"Bite","id":200}],"maxCP":2542}}}`},
+		{`{ pokemon(name: "Articuno") { localName(lang: "zh-TW") weaknesses } }`, nil,
+			`{"data":{"pokemon":{"localName":"急凍鳥","weaknesses":["Fire","Electric","Rock","Steel"]}}}`},
+		// 執行時的錯誤放在 errors 裡
+		{`{ pokemons(limit: 0) { id } }`, nil,
+			`{"errors":[{"message":"pokemons: limit must be positive"}]}`},
+	}
+	for _, test := range tests {
+		got, _ := runTestGraphQL(t, test.query, test.vars)
+		if got != test.want {
+			t.Errorf("%s:\ngot  %s\nwant %s", test.query, got, test.want)
+		}
+	}
+}
+
+func TestGraphQLValidation(t *testing.T) {
+	defer gameData.Store(currentGameData())
+	gameData.Store(apiTestGameData(t))
+
+	tests := []struct {
+		query         string
+		operationName string
+		err           string
+	}{
+		{`{ skill(id: 1) { id } } mutation { x }`, "", "anonymous operation"},
+		{`query A { skill(id: 1) { id } } mutation B { x }`, "", "operationName is required"},
+		{`query A { skill(id: 1) { id } } mutation B { x }`, "B", "mutation is not supported"},
+		{`query A { skill(id: 1) { id } }`, "C", `operation "C" not found`},
+		{`{ skill(id: 1 id: 2) { id } }`, "", `duplicate argument "id"`},
+		{`{ skill(id: "1") { id } }`, "", "expected Int"},
+		{`{ skill(level: 1) { id } }`, "", `unknown argument "level"`},
+		{`{ nearby(lat: 25) { id } }`, "", "expected Float!"},
+		{`{ skill(id: 1) { power } }`, "", `cannot query field "power" on type PokemonSkill`},
+		{`{ skill(id: 1) }`, "", "must have a selection"},
+		{`{ skill(id: 1) { id { x } } }`, "", "must not have a selection"},
+		{`{ a: skill(id: 1) { id } a: skill(id: 2) { id } }`, "", `fields "a" conflict`},
+		{`{ ...Missing }`, "", "unknown fragment Missing"},
+		{`{ ...A } fragment A on Query { ...B } fragment B on Query { ...A }`, "", "spreads itself"},
+		{`{ ... { ... { ... { ... { ... { ... { ... { ... { ... { skills { id } } } } } } } } } } }`, "", "nested too deep"},
+		{strings.Repeat(" ", gqlMaxQueryLength) + `{ skills { id } }`, "", "too long"},
+	}
+	for _, test := range tests {
+		resp, ok := runGraphQL(testCtx, gqlRequest{Query: test.query, OperationName: test.operationName})
+		if ok || len(resp.Errors) != 1 || !strings.Contains(resp.Errors[0].Message, test.err) || resp.Data != nil {
+			t.Errorf("%.80s: got %v %+v, want error %q", test.query, ok, resp, test.err)
+		}
+	}
+
+	// 有多個 operation 時用 operationName 選
+	got, ok := runTestGraphQL(t, `query A { skill(id: 12) { name } } query B { skill(id: 200) { name } }`, nil)
+	if ok {
+		t.Errorf("operationName is required, got %s", got)
+	}
+	resp, ok := runGraphQL(testCtx, gqlRequest{Query: `query A { skill(id: 12) { name } } query B { skill(id: 200) { name } }`, OperationName: "B"})
+	if b, _ := json.Marshal(resp); !ok || string(b) != `{"data":{"skill":{"name":"Bite"}}}` {
+		t.Errorf("got %s", b)
+	}
+}
+
+func TestGraphQLComplexity(t *testing.T) {
+	defer gameData.Store(currentGameData())
+	gameData.Store(apiTestGameData(t))
+
+	tests := []struct {
+		query string
+		cost  int
+	}{
+		{`{ skill(id: 1) { id name } }`, 3},
+		{`{ skills { id } }`, 1 + apiDefaultLimit},
+		{`{ skills(limit: 5) { id name } }`, 1 + 5*2},
+		// limit 超過上限時以上限計算
+		{`{ skills(limit: 1000) { id } }`, 1 + apiMaxLimit},
+		{`{ pokemon(id: 1) { fastMoves { id } chargedMoves { id } } }`, 1 + (1 + 3) + (1 + 4)},
+		{`{ pokemons(limit: 2) { matchups { type multiplier } } }`, 1 + 2*(1+len(allTypes)*2)},
+		{`{ nearby(lat: 25, long: 121) { id } }`, 1 + gqlNearbyCost + 20},
+		{`{ skill(id: 1) { __typename } }`, 2},
+	}
+	for _, test := range tests {
+		doc, err := parseGraphQL(test.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e := newGQLExecutor(testCtx, currentGameData(), nil, doc.Fragments)
+		cost, err := e.complexity("Query", doc.Operations[0].Selections, 0)
+		if err != nil || cost != test.cost {
+			t.Errorf("%s: got %d %v, want %d", test.query, cost, err, test.cost)
+		}
+	}
+
+	got, ok := runTestGraphQL(t, `{ pokemons(limit: 100) { matchups { type multiplier } } }`, nil)
+	if ok || !strings.Contains(got, "too complex") {
+		t.Errorf("got %s", got)
+	}
+}
+
+// fragment 各自重複展開下一個 fragment，不快取的話要展開 40^6 次
+func TestGraphQLFragmentBomb(t *testing.T) {
+	defer gameData.Store(currentGameData())
+	gameData.Store(apiTestGameData(t))
+
+	// fragment 只展開一次，但合併進來的子欄位仍然會一層層變多，要在預算內擋下
+	bombs := map[string]string{}
+	for name, leaf := range map[string]string{"flat": "skill(id: 12) { id }", "nested": "pokemon(id: 9) { fastMoves { id } }"} {
+		var query bytes.Buffer
+		query.WriteString("{ ...F0 }\n")
+		for i := 0; i < 6; i++ {
+			fmt.Fprintf(&query, "fragment F%d on Query {%s }\n", i, strings.Repeat(fmt.Sprintf(" ...F%d", i+1), 40))
+		}
+		fmt.Fprintf(&query, "fragment F6 on Query { %s }\n", leaf)
+		bombs[name] = query.String()
+	}
+	for name, query := range bombs {
+		start := time.Now()
+		if got, ok := runTestGraphQL(t, query, nil); ok || !strings.Contains(got, "too many fields") {
+			t.Errorf("%s: got %s", name, got)
+		}
+		if d := time.Since(start); d > time.Second {
+			t.Errorf("%s: took %s", name, d)
+		}
+	}
+
+	// 同一個 fragment 用幾次都只展開一次，結果和只用一次相同
+	got, ok := runTestGraphQL(t, `{ ...A ...A ...B } fragment A on Query { skill(id: 12) { ...B2 } } fragment B on Query { ...A } fragment B2 on PokemonSkill { id name }`, nil)
+	if !ok || got != `{"data":{"skill":{"id":12,"name":"Water Gun"}}}` {
+		t.Errorf("got %s", got)
+	}
+}
+
+// 欄位和參數都要和 SDL 一致，introspection 才不會說錯
+func TestGraphQLSchemaMatchesSDL(t *testing.T) {
+	types, err := parseGraphQLSchema(gqlSchemaSDL + gqlIntrospectionSDL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	known := map[string]*gqlTypeInfo{}
+	for _, typ := range types {
+		known[typ.Name] = typ
+	}
+
+	for _, typ := range types {
+		if typ.Kind != "OBJECT" {
+			continue
+		}
+		defs, ok := gqlSchema[typ.Name]
+		if !ok {
+			t.Errorf("type %s has no resolvers", typ.Name)
+			continue
+		}
+		for name := range defs {
+			found := strings.HasPrefix(name, "__") && typ.Name == "Query"
+			for _, f := range typ.Fields {
+				found = found || f.Name == name
+			}
+			if !found {
+				t.Errorf("%s.%s is not in the SDL", typ.Name, name)
+			}
+		}
+
+		for _, f := range typ.Fields {
+			def, ok := defs[f.Name]
+			if !ok {
+				t.Errorf("%s.%s has no resolver", typ.Name, f.Name)
+				continue
+			}
+			named := f.Type.named()
+			target, ok := known[named.Name]
+			if !ok {
+				t.Errorf("%s.%s: unknown type %s", typ.Name, f.Name, named.Name)
+				continue
+			}
+			wantType := ""
+			if target.Kind == "OBJECT" {
+				wantType = named.Name
+			}
+			// 只有物件列表要估算長度
+			if def.Type != wantType || wantType != "" && def.List != strings.Contains(f.Type.String(), "[") {
+				t.Errorf("%s.%s: resolver returns %q (list %v), SDL says %s", typ.Name, f.Name, def.Type, def.List, f.Type)
+			}
+			if len(def.Args) != len(f.Args) {
+				t.Errorf("%s.%s: got args %v, SDL has %d", typ.Name, f.Name, def.Args, len(f.Args))
+			}
+			for _, arg := range f.Args {
+				if def.Args[arg.Name] != arg.Type.String() {
+					t.Errorf("%s.%s(%s): got %q, SDL says %s", typ.Name, f.Name, arg.Name, def.Args[arg.Name], arg.Type)
+				}
+			}
+		}
+	}
+}
+
+// graphql-js 的 getIntrospectionQuery，GraphiQL 等工具用它讀 schema
+const gqlTestIntrospectionQuery = `
+query IntrospectionQuery {
+  __schema {
+    queryType { name }
+    mutationType { name }
+    subscriptionType { name }
+    types { ...FullType }
+    directives { name description locations args { ...InputValue } }
+  }
+}
+fragment FullType on __Type {
+  kind name description
+  fields(includeDeprecated: true) {
+    name description
+    args { ...InputValue }
+    type { ...TypeRef }
+    isDeprecated deprecationReason
+  }
+  inputFields { ...InputValue }
+  interfaces { ...TypeRef }
+  enumValues(includeDeprecated: true) { name description isDeprecated deprecationReason }
+  possibleTypes { ...TypeRef }
+}
+fragment InputValue on __InputValue {
+  name description
+  type { ...TypeRef }
+  defaultValue
+}
+fragment TypeRef on __Type {
+  kind name
+  ofType { kind name ofType { kind name ofType { kind name ofType { kind name ofType { kind name ofType { kind name ofType { kind name } } } } } } }
+}
+`
+
+func TestGraphQLIntrospection(t *testing.T) {
+	defer gameData.Store(currentGameData())
+	gameData.Store(apiTestGameData(t))
+
+	got, ok := runTestGraphQL(t, gqlTestIntrospectionQuery, nil)
+	if !ok {
+		t.Fatalf("got %s", got)
+	}
+	type typeRef struct {
+		Kind   string
+		Name   *string
+		OfType *typeRef
+	}
+	var resp struct {
+		Data struct {
+			Schema struct {
+				QueryType    struct{ Name string }
+				MutationType *struct{ Name string }
+				Types        []struct {
+					Kind   string
+					Name   string
+					Fields []struct {
+						Name string
+						Args []struct {
+							Name         string
+							Type         typeRef
+							DefaultValue *string
+						}
+						Type typeRef
+					}
+					EnumValues []struct{ Name string }
+				}
+				Directives []interface{}
+			} `json:"__schema"`
+		}
+		Errors []gqlError
+	}
+	if err := json.Unmarshal([]byte(got), &resp); err != nil || len(resp.Errors) != 0 {
+		t.Fatalf("got %s: %v", got, err)
+	}
+	schema := resp.Data.Schema
+	if schema.QueryType.Name != "Query" || schema.MutationType != nil || len(schema.Directives) != 0 {
+		t.Errorf("got schema %+v", schema)
+	}
+
+	names := map[string]string{}
+	for _, typ := range schema.Types {
+		names[typ.Name] = typ.Kind
+		if typ.Kind == "OBJECT" && len(typ.Fields) == 0 || typ.Kind != "OBJECT" && typ.Fields != nil {
+			t.Errorf("type %s %s has %d fields", typ.Kind, typ.Name, len(typ.Fields))
+		}
+		if typ.Kind == "ENUM" && len(typ.EnumValues) == 0 {
+			t.Errorf("enum %s has no values", typ.Name)
+		}
+		if typ.Name != "Query" {
+			continue
+		}
+		for _, f := range typ.Fields {
+			if f.Name != "nearby" {
+				continue
+			}
+			// [PokemonPin!]!
+			if f.Type.Kind != "NON_NULL" || f.Type.OfType.Kind != "LIST" || f.Type.OfType.OfType.Kind != "NON_NULL" || *f.Type.OfType.OfType.OfType.Name != "PokemonPin" {
+				t.Errorf("got nearby type %s", got)
+			}
+			if len(f.Args) != 4 || f.Args[0].Name != "lat" || f.Args[0].Type.Kind != "NON_NULL" || *f.Args[0].Type.OfType.Name != "Float" {
+				t.Errorf("got nearby args %+v", f.Args)
+			}
+		}
+	}
+	for name, kind := range map[string]string{"Query": "OBJECT", "Pokemon": "OBJECT", "PokemonPin": "OBJECT", "Int": "SCALAR", "Boolean": "SCALAR", "__Type": "OBJECT", "__TypeKind": "ENUM"} {
+		if names[name] != kind {
+			t.Errorf("type %s: got %q, want %s", name, names[name], kind)
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{`{ __type(name: "TypeMatchup") { kind name fields { name type { kind ofType { name } } } } }`,
+			`{"data":{"__type":{"kind":"OBJECT","name":"TypeMatchup","fields":[{"name":"type","type":{"kind":"NON_NULL","ofType":{"name":"String"}}},{"name":"multiplier","type":{"kind":"NON_NULL","ofType":{"name":"Float"}}}]}}}`},
+		{`{ __type(name: "Nope") { name } }`, `{"data":{"__type":null}}`},
+		{`{ __type(name: "__Type") { fields { name args { name defaultValue } } } }`, ""},
+		{`{ __type(name: "Query") { fields(includeDeprecated: true) { name description } } }`, ""},
+	}
+	for _, test := range tests {
+		got, ok := runTestGraphQL(t, test.query, nil)
+		if !ok || strings.Contains(got, `"errors"`) || test.want != "" && got != test.want {
+			t.Errorf("%s:\ngot  %s\nwant %s", test.query, got, test.want)
+		}
+	}
+	if got, _ := runTestGraphQL(t, `{ __type(name: "__Type") { fields { name args { defaultValue } } } }`, nil); !strings.Contains(got, `"defaultValue":"false"`) {
+		t.Errorf("default value of includeDeprecated is missing: %s", got)
+	}
+	if got, _ := runTestGraphQL(t, `{ __type(name: "Query") { fields { name description } } }`, nil); !strings.Contains(got, "Rare Pokémon within radius") {
+		t.Errorf("field description is missing: %s", got)
+	}
+
+	// introspection 的列表不估算長度，回應太大時停止
+	var aliases bytes.Buffer
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(&aliases, " t%d: types { fields { name description type { kind name } args { name } } }", i)
+	}
+	got, _ = runTestGraphQL(t, "{ __schema {"+aliases.String()+" } }", nil)
+	if !strings.Contains(got, "result is too large") {
+		t.Errorf("got %.200s", got)
+	}
+}
+
+func TestGraphQLHandler(t *testing.T) {
+	defer gameData.Store(currentGameData())
+	gameData.Store(apiTestGameData(t))
+
+	tests := []struct {
+		method string
+		url    string
+		body   string
+		code   int
+		want   string
+	}{
+		{"POST", GRAPHQL_PATH, `{"query":"query ($id: Int!) { skill(id: $id) { name } }","variables":{"id":12}}`, http.StatusOK, `{"data":{"skill":{"name":"Water Gun"}}}`},
+		{"POST", GRAPHQL_PATH, `{"query":"query A { skill(id: 12) { id } } query B { skill(id: 200) { id } }","operationName":"B"}`, http.StatusOK, `{"data":{"skill":{"id":200}}}`},
+		{"GET", GRAPHQL_PATH + "?query=%7B%20skill(id%3A%20200)%20%7B%20name%20%7D%20%7D", "", http.StatusOK, `{"data":{"skill":{"name":"Bite"}}}`},
+		{"POST", GRAPHQL_PATH, `{"query":"{ skill(id: 1 id: 2) { id } }"}`, http.StatusBadRequest, `duplicate argument`},
+		{"POST", GRAPHQL_PATH, `{"query":`, http.StatusBadRequest, "unable to parse"},
+		{"GET", GRAPHQL_PATH + "?query=%7B%20skills%20%7B%20id%20%7D%20%7D&variables=x", "", http.StatusBadRequest, "invalid variables"},
+		{"GET", GRAPHQL_PATH, "", http.StatusOK, "type Query {"},
+		{"DELETE", GRAPHQL_PATH, "", http.StatusMethodNotAllowed, ""},
+	}
+	for _, test := range tests {
+		r := newTestRequest(t, test.method, test.url, strings.NewReader(test.body))
+		w := httptest.NewRecorder()
+		graphqlHandler(w, r)
+		if w.Code != test.code || !strings.Contains(w.Body.String(), test.want) {
+			t.Errorf("%s %s %s: got %d %s", test.method, test.url, test.body, w.Code, w.Body)
+		}
+	}
+}
@@ -0,0 +1,668 @@
+package pokedict
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	LANG_ZH_TW = "zh-TW"
+	LANG_ZH_CN = "zh-CN"
+	LANG_EN    = "en"
+	LANG_JA    = "ja"
+
+	DEFAULT_LANGUAGE = LANG_ZH_TW
+)
+
+var languages []string = []string{LANG_ZH_TW, LANG_ZH_CN, LANG_EN, LANG_JA}
+
+var languageNames map[string]string = map[string]string{
+	LANG_ZH_TW: "繁體中文",
+	LANG_ZH_CN: "简体中文",
+	LANG_EN:    "English",
+	LANG_JA:    "日本語",
+}
+
+// 使用者輸入的語言名稱
+var languageAliases map[string]string = map[string]string{
+	"繁體": LANG_ZH_TW, "繁體中文": LANG_ZH_TW, "中文": LANG_ZH_TW, "zh-tw": LANG_ZH_TW,
+	"简体": LANG_ZH_CN, "简体中文": LANG_ZH_CN, "簡體": LANG_ZH_CN, "簡體中文": LANG_ZH_CN, "zh-cn": LANG_ZH_CN,
+	"english": LANG_EN, "英文": LANG_EN, "en": LANG_EN,
+	"日本語": LANG_JA, "日文": LANG_JA, "japanese": LANG_JA, "ja": LANG_JA,
+}
+
+func parseLanguage(text string) (string, bool) {
+	lang, ok := languageAliases[strings.ToLower(strings.TrimSpace(text))]
+	return lang, ok
+}
+
+// 把 zh_TW、zh-Hant、en-US 之類的 locale 對應到支援的語言
+func languageForLocale(locale string) string {
+	locale = strings.ToLower(strings.Replace(locale, "_", "-", -1))
+	switch {
+	case locale == "":
+		return DEFAULT_LANGUAGE
+	case locale == "zh-cn" || locale == "zh-sg" || strings.HasPrefix(locale, "zh-hans"):
+		return LANG_ZH_CN
+	case strings.HasPrefix(locale, "zh"):
+		return LANG_ZH_TW
+	case strings.HasPrefix(locale, "ja"):
+		return LANG_JA
+	case strings.HasPrefix(locale, "en"):
+		return LANG_EN
+	}
+	return DEFAULT_LANGUAGE
+}
+
+// 訊息目錄，缺少的翻譯會用繁體中文
+var messageCatalog map[string]map[string]string = map[string]map[string]string{
+	"welcome": {
+		LANG_ZH_TW: "你好，歡迎使用 PokéDict。請輸入任何遊戲內容，機器人會為您搜尋適當的神奇寶貝資訊。",
+		LANG_ZH_CN: "你好，欢迎使用 PokéDict。请输入任何游戏内容，机器人会为您搜索适当的宝可梦信息。",
+		LANG_EN:    "Hi, welcome to PokéDict. Type anything about the game and I will look up the Pokémon information for you.",
+		LANG_JA:    "こんにちは、PokéDict へようこそ。ゲームのことを何でも入力してください。ポケモンの情報を探します。",
+	},
+	"welcome_named": {
+		LANG_ZH_TW: "%s 你好，歡迎使用 PokéDict。請輸入任何遊戲內容，機器人會為您搜尋適當的神奇寶貝資訊。",
+		LANG_ZH_CN: "%s 你好，欢迎使用 PokéDict。请输入任何游戏内容，机器人会为您搜索适当的宝可梦信息。",
+		LANG_EN:    "Hi %s, welcome to PokéDict. Type anything about the game and I will look up the Pokémon information for you.",
+		LANG_JA:    "%s さん、PokéDict へようこそ。ゲームのことを何でも入力してください。ポケモンの情報を探します。",
+	},
+	"not_understand": {
+		LANG_ZH_TW: "我不懂你的意思。",
+		LANG_ZH_CN: "我不懂你的意思。",
+		LANG_EN:    "Sorry, I don't understand.",
+		LANG_JA:    "すみません、よく分かりません。",
+	},
+	"nothing_found": {
+		LANG_ZH_TW: "什麼也沒找到",
+		LANG_ZH_CN: "什么也没找到",
+		LANG_EN:    "Nothing found",
+		LANG_JA:    "見つかりませんでした",
+	},
+	"no_monster_found": {
+		LANG_ZH_TW: "沒有找到任何寵物",
+		LANG_ZH_CN: "没有找到任何宝可梦",
+		LANG_EN:    "No Pokémon found",
+		LANG_JA:    "ポケモンが見つかりませんでした",
+	},
+	"too_many_results": {
+		LANG_ZH_TW: "範圍太大，多打些字吧",
+		LANG_ZH_CN: "范围太大，多打些字吧",
+		LANG_EN:    "Too many results, please type a bit more",
+		LANG_JA:    "候補が多すぎます。もう少し詳しく入力してください",
+	},
+	"query_failed": {
+		LANG_ZH_TW: "查詢失敗",
+		LANG_ZH_CN: "查询失败",
+		LANG_EN:    "Lookup failed",
+		LANG_JA:    "検索に失敗しました",
+	},
+	"query_error": {
+		LANG_ZH_TW: "查詢過程發生錯誤",
+		LANG_ZH_CN: "查询过程发生错误",
+		LANG_EN:    "Something went wrong during the lookup",
+		LANG_JA:    "検索中にエラーが発生しました",
+	},
+	"ask_skill": {
+		LANG_ZH_TW: "想要找什麼技能？(請輸入技能名稱或關鍵字)",
+		LANG_ZH_CN: "想要找什么技能？(请输入技能名称或关键字)",
+		LANG_EN:    "Which move are you looking for? (Type a name or keyword)",
+		LANG_JA:    "どの技を探しますか？(技の名前かキーワードを入力してください)",
+	},
+	"ask_monster": {
+		LANG_ZH_TW: "想要找什麼寵物？(請輸入寵物名稱或關鍵字)",
+		LANG_ZH_CN: "想要找什么宝可梦？(请输入宝可梦名称或关键字)",
+		LANG_EN:    "Which Pokémon are you looking for? (Type a name or keyword)",
+		LANG_JA:    "どのポケモンを探しますか？(ポケモンの名前かキーワードを入力してください)",
+	},
+	"ask_location": {
+		LANG_ZH_TW: "你在哪？？按下面的按鈕把你的現在位置傳給我吧！",
+		LANG_ZH_CN: "你在哪？？按下面的按钮把你的当前位置发给我吧！",
+		LANG_EN:    "Where are you? Tap the button below to send me your location!",
+		LANG_JA:    "どこにいますか？下のボタンで現在地を送ってください！",
+	},
+	"confirm_find_monster": {
+		LANG_ZH_TW: "找怪嗎?",
+		LANG_ZH_CN: "找怪吗?",
+		LANG_EN:    "Search for Pokémon nearby?",
+		LANG_JA:    "近くのポケモンを探しますか？",
+	},
+	"menu_query_monster": {
+		LANG_ZH_TW: "查寵物",
+		LANG_ZH_CN: "查宝可梦",
+		LANG_EN:    "Pokémon",
+		LANG_JA:    "ポケモンを調べる",
+	},
+	"menu_query_skill": {
+		LANG_ZH_TW: "查技能",
+		LANG_ZH_CN: "查技能",
+		LANG_EN:    "Moves",
+		LANG_JA:    "技を調べる",
+	},
+	"menu_find_monster": {
+		LANG_ZH_TW: "找怪",
+		LANG_ZH_CN: "找怪",
+		LANG_EN:    "Find nearby",
+		LANG_JA:    "近くを探す",
+	},
+	"yes": {
+		LANG_ZH_TW: "是",
+		LANG_ZH_CN: "是",
+		LANG_EN:    "Yes",
+		LANG_JA:    "はい",
+	},
+	"no": {
+		LANG_ZH_TW: "不是",
+		LANG_ZH_CN: "不是",
+		LANG_EN:    "No",
+		LANG_JA:    "いいえ",
+	},
+	"kidding": {
+		LANG_ZH_TW: "你在呼嚨我嗎？",
+		LANG_ZH_CN: "你在忽悠我吗？",
+		LANG_EN:    "Are you kidding me?",
+		LANG_JA:    "からかってますか？",
+	},
+	"no_rare_nearby": {
+		LANG_ZH_TW: "附近沒有稀有怪",
+		LANG_ZH_CN: "附近没有稀有怪",
+		LANG_EN:    "No rare Pokémon nearby",
+		LANG_JA:    "近くにレアなポケモンはいません",
+	},
+	"rare_nearby": {
+		LANG_ZH_TW: "附近的稀有怪",
+		LANG_ZH_CN: "附近的稀有怪",
+		LANG_EN:    "Rare Pokémon nearby",
+		LANG_JA:    "近くのレアなポケモン",
+	},
+	"send_location": {
+		LANG_ZH_TW: "傳送位置",
+		LANG_ZH_CN: "发送位置",
+		LANG_EN:    "Send location",
+		LANG_JA:    "位置情報を送る",
+	},
+	"pin_subtitle": {
+		LANG_ZH_TW: "位置: %s\n直線距離 %0.2fkm\n消失時間 %s (%s)\n%s",
+		LANG_ZH_CN: "位置: %s\n直线距离 %0.2fkm\n消失时间 %s (%s)\n%s",
+		LANG_EN:    "Location: %s\nDistance %0.2fkm\nDisappears at %s (%s)\n%s",
+		LANG_JA:    "場所: %s\n直線距離 %0.2fkm\n消える時刻 %s (%s)\n%s",
+	},
+	"monster_subtitle": {
+		LANG_ZH_TW: "%s · 最大CP %d\nDPS 速技 %s · 充能技 %s",
+		LANG_ZH_CN: "%s · 最大CP %d\nDPS 快速技 %s · 充能技 %s",
+		LANG_EN:    "%s · MaxCP %d\nDPS fast %s · charged %s",
+		LANG_JA:    "%s · 最大CP %d\nDPS 通常技 %s · ゲージ技 %s",
+	},
+	"moves_not_in_data": {
+		LANG_ZH_TW: "%d 招資料中找不到",
+		LANG_ZH_CN: "%d 招数据中找不到",
+		LANG_EN:    "%d move(s) not in data",
+		LANG_JA:    "データなしの技: %d",
+	},
+	"show_skills": {
+		LANG_ZH_TW: "顯示技能資訊",
+		LANG_ZH_CN: "显示技能信息",
+		LANG_EN:    "Show moves",
+		LANG_JA:    "技を表示",
+	},
+	"querying_skills": {
+		LANG_ZH_TW: "查詢「%s」技能",
+		LANG_ZH_CN: "查询「%s」技能",
+		LANG_EN:    "Moves of %s",
+		LANG_JA:    "「%s」の技",
+	},
+	"skills_found": {
+		LANG_ZH_TW: "找到的技能如下:",
+		LANG_ZH_CN: "找到的技能如下:",
+		LANG_EN:    "Moves found:",
+		LANG_JA:    "見つかった技:",
+	},
+	"monsters_found": {
+		LANG_ZH_TW: "找到的寵物如下:",
+		LANG_ZH_CN: "找到的宝可梦如下:",
+		LANG_EN:    "Pokémon found:",
+		LANG_JA:    "見つかったポケモン:",
+	},
+	"type_label": {
+		LANG_ZH_TW: "屬性",
+		LANG_ZH_CN: "属性",
+		LANG_EN:    "Type",
+		LANG_JA:    "タイプ",
+	},
+	"weaknesses_label": {
+		LANG_ZH_TW: "弱點",
+		LANG_ZH_CN: "弱点",
+		LANG_EN:    "Weaknesses",
+		LANG_JA:    "弱点",
+	},
+	"max_cp_label": {
+		LANG_ZH_TW: "最大CP",
+		LANG_ZH_CN: "最大CP",
+		LANG_EN:    "Max CP",
+		LANG_JA:    "最大CP",
+	},
+	"fast_moves": {
+		LANG_ZH_TW: "速技",
+		LANG_ZH_CN: "快速技",
+		LANG_EN:    "Fast moves",
+		LANG_JA:    "通常技",
+	},
+	"charged_moves": {
+		LANG_ZH_TW: "充能技",
+		LANG_ZH_CN: "充能技",
+		LANG_EN:    "Charged moves",
+		LANG_JA:    "ゲージ技",
+	},
+	"move_not_in_data": {
+		LANG_ZH_TW: "資料中找不到",
+		LANG_ZH_CN: "数据中找不到",
+		LANG_EN:    "not in data",
+		LANG_JA:    "データなし",
+	},
+	"same_type_bonus": {
+		LANG_ZH_TW: "本系",
+		LANG_ZH_CN: "本系",
+		LANG_EN:    "STAB",
+		LANG_JA:    "タイプ一致",
+	},
+	"move_detail": {
+		LANG_ZH_TW: "-> DPS: %.2f, 能量: %.0f",
+		LANG_ZH_CN: "-> DPS: %.2f, 能量: %.0f",
+		LANG_EN:    "-> DPS: %.2f, Energy: %.0f",
+		LANG_JA:    "-> DPS: %.2f, エネルギー: %.0f",
+	},
+	"ask_timezone": {
+		LANG_ZH_TW: "請輸入時區名稱，例如 Asia/Taipei (目前: %s)",
+		LANG_ZH_CN: "请输入时区名称，例如 Asia/Shanghai (目前: %s)",
+		LANG_EN:    "Please type a time zone name such as Asia/Taipei (current: %s)",
+		LANG_JA:    "タイムゾーン名を入力してください。例: Asia/Tokyo (現在: %s)",
+	},
+	"unknown_timezone": {
+		LANG_ZH_TW: "找不到這個時區，請輸入像 Asia/Taipei 的名稱",
+		LANG_ZH_CN: "找不到这个时区，请输入像 Asia/Shanghai 的名称",
+		LANG_EN:    "Unknown time zone, please type a name like Asia/Taipei",
+		LANG_JA:    "タイムゾーンが見つかりません。Asia/Tokyo のような名前を入力してください",
+	},
+	"timezone_set": {
+		LANG_ZH_TW: "時區已設定為 %s",
+		LANG_ZH_CN: "时区已设置为 %s",
+		LANG_EN:    "Time zone set to %s",
+		LANG_JA:    "タイムゾーンを %s に設定しました",
+	},
+	"travel_mode_set": {
+		LANG_ZH_TW: "好的，之後會用%s的速度估算能不能趕到",
+		LANG_ZH_CN: "好的，之后会用%s的速度估算能不能赶到",
+		LANG_EN:    "OK, from now on I will estimate arrival times %s",
+		LANG_JA:    "了解です。これからは%sの速さで間に合うか計算します",
+	},
+	"travel_walk": {
+		LANG_ZH_TW: "走路",
+		LANG_ZH_CN: "走路",
+		LANG_EN:    "on foot",
+		LANG_JA:    "徒歩",
+	},
+	"travel_bike": {
+		LANG_ZH_TW: "騎車",
+		LANG_ZH_CN: "骑车",
+		LANG_EN:    "by bike",
+		LANG_JA:    "自転車",
+	},
+	"travel": {
+		LANG_ZH_TW: "%s約 %d 分鐘 (%s)",
+		LANG_ZH_CN: "%s约 %d 分钟 (%s)",
+		LANG_EN:    "About %[2]d min %[1]s (%[3]s)",
+		LANG_JA:    "%s約 %d 分 (%s)",
+	},
+	"reachable": {
+		LANG_ZH_TW: "來得及",
+		LANG_ZH_CN: "来得及",
+		LANG_EN:    "in time",
+		LANG_JA:    "間に合う",
+	},
+	"unreachable": {
+		LANG_ZH_TW: "來不及",
+		LANG_ZH_CN: "来不及",
+		LANG_EN:    "too late",
+		LANG_JA:    "間に合わない",
+	},
+	"countdown_gone": {
+		LANG_ZH_TW: "已消失",
+		LANG_ZH_CN: "已消失",
+		LANG_EN:    "gone",
+		LANG_JA:    "消えました",
+	},
+	"countdown_hours": {
+		LANG_ZH_TW: "剩 %d 小時 %d 分",
+		LANG_ZH_CN: "剩 %d 小时 %d 分",
+		LANG_EN:    "%dh %dm left",
+		LANG_JA:    "残り %d 時間 %d 分",
+	},
+	"countdown_minutes": {
+		LANG_ZH_TW: "剩 %d 分 %d 秒",
+		LANG_ZH_CN: "剩 %d 分 %d 秒",
+		LANG_EN:    "%dm %ds left",
+		LANG_JA:    "残り %d 分 %d 秒",
+	},
+	"countdown_seconds": {
+		LANG_ZH_TW: "剩 %d 秒",
+		LANG_ZH_CN: "剩 %d 秒",
+		LANG_EN:    "%ds left",
+		LANG_JA:    "残り %d 秒",
+	},
+	"ask_language": {
+		LANG_ZH_TW: "請選擇語言",
+		LANG_ZH_CN: "请选择语言",
+		LANG_EN:    "Please choose a language",
+		LANG_JA:    "言語を選んでください",
+	},
+	"language_set": {
+		LANG_ZH_TW: "語言已設定為%s",
+		LANG_ZH_CN: "语言已设置为%s",
+		LANG_EN:    "Language set to %s",
+		LANG_JA:    "言語を%sに設定しました",
+	},
+	"unknown_language": {
+		LANG_ZH_TW: "不支援這個語言",
+		LANG_ZH_CN: "不支持这个语言",
+		LANG_EN:    "This language is not supported",
+		LANG_JA:    "この言語には対応していません",
+	},
+	"counters_title": {
+		LANG_ZH_TW: "打 %s 推薦:",
+		LANG_ZH_CN: "打 %s 推荐:",
+		LANG_EN:    "Best counters for %s:",
+		LANG_JA:    "%s 対策のおすすめ:",
+	},
+	"counter_detail": {
+		LANG_ZH_TW: "約 %.0f 秒, 承受 %.0f 傷害 (倒下 %.1f 次)",
+		LANG_ZH_CN: "约 %.0f 秒, 承受 %.0f 伤害 (倒下 %.1f 次)",
+		LANG_EN:    "~%.0fs, takes %.0f damage (faints %.1f times)",
+		LANG_JA:    "約 %.0f 秒, 被ダメージ %.0f (%.1f 回ひんし)",
+	},
+	"invalid_level": {
+		LANG_ZH_TW: "等級必須是 %d 到 %d 之間，以 0.5 為單位，例如 lv30 或 lv30.5",
+		LANG_ZH_CN: "等级必须是 %d 到 %d 之间，以 0.5 为单位，例如 lv30 或 lv30.5",
+		LANG_EN:    "Level must be between %d and %d in steps of 0.5, e.g. lv30 or lv30.5",
+		LANG_JA:    "レベルは %d から %d までの 0.5 刻みで入力してください。例: lv30, lv30.5",
+	},
+	"invalid_iv": {
+		LANG_ZH_TW: "IV 必須是 0 到 %d 之間的整數，例如 iv15",
+		LANG_ZH_CN: "IV 必须是 0 到 %d 之间的整数，例如 iv15",
+		LANG_EN:    "IV must be a whole number between 0 and %d, e.g. iv15",
+		LANG_JA:    "IV は 0 から %d までの整数で入力してください。例: iv15",
+	},
+	"battle_usage": {
+		LANG_ZH_TW: "請輸入兩隻寵物，例如「模擬 快龍 vs 卡比獸」。名稱後面可以指定招式、等級和 IV，例如「模擬 快龍 龍之息 破壞死光 lv30 vs 卡比獸 iv15」",
+		LANG_ZH_CN: "请输入两只宝可梦，例如「模擬 快龙 vs 卡比兽」。名称后面可以指定招式、等级和 IV，例如「模擬 快龙 龙息 破坏光线 lv30 vs 卡比兽 iv15」",
+		LANG_EN:    "Please give two Pokémon, e.g. \"simulate Dragonite vs Snorlax\". Moves, level and IV can follow each name, e.g. \"simulate Dragonite Dragon Breath Hyper Beam lv30 vs Snorlax iv15\"",
+		LANG_JA:    "ポケモンを2匹入力してください。例:「simulate カイリュー vs カビゴン」。名前の後に技、レベル、IV も指定できます。例:「simulate カイリュー りゅうのいぶき はかいこうせん lv30 vs カビゴン iv15」",
+	},
+	"battle_unknown_move": {
+		LANG_ZH_TW: "%s 不會 %s",
+		LANG_ZH_CN: "%s 不会 %s",
+		LANG_EN:    "%s cannot learn %s",
+		LANG_JA:    "%sは%sを覚えません",
+	},
+	"monster_not_found": {
+		LANG_ZH_TW: "找不到「%s」",
+		LANG_ZH_CN: "找不到「%s」",
+		LANG_EN:    "Cannot find \"%s\"",
+		LANG_JA:    "「%s」が見つかりません",
+	},
+	"no_usable_move": {
+		LANG_ZH_TW: "找不到可用的技能",
+		LANG_ZH_CN: "找不到可用的技能",
+		LANG_EN:    "No usable moves found",
+		LANG_JA:    "使える技が見つかりません",
+	},
+	"battle_draw": {
+		LANG_ZH_TW: "時間到，不分勝負 (%.0f/%.0f vs %.0f/%.0f)",
+		LANG_ZH_CN: "时间到，不分胜负 (%.0f/%.0f vs %.0f/%.0f)",
+		LANG_EN:    "Time out, draw (%.0f/%.0f vs %.0f/%.0f)",
+		LANG_JA:    "時間切れ、引き分け (%.0f/%.0f vs %.0f/%.0f)",
+	},
+	"battle_win": {
+		LANG_ZH_TW: "勝利: %s，剩餘 HP %.0f/%.0f，耗時 %.1f 秒",
+		LANG_ZH_CN: "胜利: %s，剩余 HP %.0f/%.0f，耗时 %.1f 秒",
+		LANG_EN:    "Winner: %s, HP left %.0f/%.0f, took %.1fs",
+		LANG_JA:    "勝者: %s、残り HP %.0f/%.0f、%.1f 秒",
+	},
+	"battle_skipped": {
+		LANG_ZH_TW: "... (省略 %d 回合)",
+		LANG_ZH_CN: "... (省略 %d 回合)",
+		LANG_EN:    "... (%d turns omitted)",
+		LANG_JA:    "... (%d ターン省略)",
+	},
+	"battle_dodged": {
+		LANG_ZH_TW: " (被閃避)",
+		LANG_ZH_CN: " (被闪避)",
+		LANG_EN:    " (dodged)",
+		LANG_JA:    " (回避)",
+	},
+	"battle_turn": {
+		LANG_ZH_TW: "%5.1fs %s: %s %.0f%s -> 剩 %.0f",
+		LANG_ZH_CN: "%5.1fs %s: %s %.0f%s -> 剩 %.0f",
+		LANG_EN:    "%5.1fs %s: %s %.0f%s -> %.0f left",
+		LANG_JA:    "%5.1fs %s: %s %.0f%s -> 残り %.0f",
+	},
+	"skill_kind_fast": {
+		LANG_ZH_TW: "快速",
+		LANG_ZH_CN: "快速",
+		LANG_EN:    "fast",
+		LANG_JA:    "通常",
+	},
+	"skill_kind_charged": {
+		LANG_ZH_TW: "充能",
+		LANG_ZH_CN: "充能",
+		LANG_EN:    "charged",
+		LANG_JA:    "ゲージ",
+	},
+	"compare_unknown": {
+		LANG_ZH_TW: "找不到技能: %s",
+		LANG_ZH_CN: "找不到技能: %s",
+		LANG_EN:    "Unknown moves: %s",
+		LANG_JA:    "技が見つかりません: %s",
+	},
+	"compare_usage": {
+		LANG_ZH_TW: "請輸入兩個以上的技能，例如「比較 Hydro Pump Blizzard」",
+		LANG_ZH_CN: "请输入两个以上的技能，例如「比較 Hydro Pump Blizzard」",
+		LANG_EN:    "Please give two or more moves, e.g. \"compare Hydro Pump Blizzard\"",
+		LANG_JA:    "技を2つ以上入力してください。例:「compare Hydro Pump Blizzard」",
+	},
+	"compare_title": {
+		LANG_ZH_TW: "技能比較",
+		LANG_ZH_CN: "技能比较",
+		LANG_EN:    "Move comparison",
+		LANG_JA:    "技の比較",
+	},
+	"compare_subtitle": {
+		LANG_ZH_TW: "%s·%s 傷害%.0f %.1f秒 能量%.0f / DPS %.1f DPE %.1f EPS %.1f",
+		LANG_ZH_CN: "%s·%s 伤害%.0f %.1f秒 能量%.0f / DPS %.1f DPE %.1f EPS %.1f",
+		LANG_EN:    "%s·%s %.0fdmg %.1fs %.0fE / DPS %.1f DPE %.1f EPS %.1f",
+		LANG_JA:    "%s·%s 威力%.0f %.1f秒 エネルギー%.0f / DPS %.1f DPE %.1f EPS %.1f",
+	},
+	"team_members": {
+		LANG_ZH_TW: "隊伍: %s",
+		LANG_ZH_CN: "队伍: %s",
+		LANG_EN:    "Team: %s",
+		LANG_JA:    "パーティ: %s",
+	},
+	"team_no_shared_weakness": {
+		LANG_ZH_TW: "沒有共同弱點",
+		LANG_ZH_CN: "没有共同弱点",
+		LANG_EN:    "No shared weaknesses",
+		LANG_JA:    "共通の弱点はありません",
+	},
+	"team_shared_weakness": {
+		LANG_ZH_TW: "共同弱點: %s",
+		LANG_ZH_CN: "共同弱点: %s",
+		LANG_EN:    "Shared weaknesses: %s",
+		LANG_JA:    "共通の弱点: %s",
+	},
+	"team_full_coverage": {
+		LANG_ZH_TW: "所有屬性都打得到效果絕佳",
+		LANG_ZH_CN: "所有属性都打得到效果绝佳",
+		LANG_EN:    "Super effective against every type",
+		LANG_JA:    "すべてのタイプに効果バツグンを取れます",
+	},
+	"team_uncovered": {
+		LANG_ZH_TW: "打不出效果絕佳: %s",
+		LANG_ZH_CN: "打不出效果绝佳: %s",
+		LANG_EN:    "No super effective moves against: %s",
+		LANG_JA:    "効果バツグンを取れない: %s",
+	},
+	"team_replace": {
+		LANG_ZH_TW: "*) 用 %s 換掉 %s (%s)",
+		LANG_ZH_CN: "*) 用 %s 换掉 %s (%s)",
+		LANG_EN:    "*) Replace %[2]s with %[1]s (%[3]s)",
+		LANG_JA:    "*) %[2]s を %[1]s に入れ替え (%[3]s)",
+	},
+	"team_add": {
+		LANG_ZH_TW: "*) 加入 %s (%s)",
+		LANG_ZH_CN: "*) 加入 %s (%s)",
+		LANG_EN:    "*) Add %s (%s)",
+		LANG_JA:    "*) %s を追加 (%s)",
+	},
+	"team_gap_weak": {
+		LANG_ZH_TW: "怕%s",
+		LANG_ZH_CN: "怕%s",
+		LANG_EN:    "weak to %s",
+		LANG_JA:    "%sに弱い",
+	},
+	"team_gap_cover": {
+		LANG_ZH_TW: "打%s",
+		LANG_ZH_CN: "打%s",
+		LANG_EN:    "hits %s",
+		LANG_JA:    "%sを突ける",
+	},
+	"team_unknown": {
+		LANG_ZH_TW: "找不到寵物: %s",
+		LANG_ZH_CN: "找不到宝可梦: %s",
+		LANG_EN:    "Unknown Pokémon: %s",
+		LANG_JA:    "ポケモンが見つかりません: %s",
+	},
+	"team_usage": {
+		LANG_ZH_TW: "請輸入隊伍成員，例如「隊伍 快龍 卡比獸 暴鯉龍」",
+		LANG_ZH_CN: "请输入队伍成员，例如「隊伍 快龙 卡比兽 暴鲤龙」",
+		LANG_EN:    "Please list the team members, e.g. \"team Dragonite Snorlax Gyarados\"",
+		LANG_JA:    "パーティのメンバーを入力してください。例:「team カイリュー カビゴン ギャラドス」",
+	},
+	"team_too_large": {
+		LANG_ZH_TW: "隊伍最多 %d 隻",
+		LANG_ZH_CN: "队伍最多 %d 只",
+		LANG_EN:    "A team has at most %d Pokémon",
+		LANG_JA:    "パーティは最大 %d 匹です",
+	},
+}
+
+var typeNames map[string]map[string]string = map[string]map[string]string{
+	"Normal":   {LANG_ZH_TW: "一般", LANG_ZH_CN: "一般", LANG_JA: "ノーマル"},
+	"Fire":     {LANG_ZH_TW: "火", LANG_ZH_CN: "火", LANG_JA: "ほのお"},
+	"Water":    {LANG_ZH_TW: "水", LANG_ZH_CN: "水", LANG_JA: "みず"},
+	"Electric": {LANG_ZH_TW: "電", LANG_ZH_CN: "电", LANG_JA: "でんき"},
+	"Grass":    {LANG_ZH_TW: "草", LANG_ZH_CN: "草", LANG_JA: "くさ"},
+	"Ice":      {LANG_ZH_TW: "冰", LANG_ZH_CN: "冰", LANG_JA: "こおり"},
+	"Fighting": {LANG_ZH_TW: "格鬥", LANG_ZH_CN: "格斗", LANG_JA: "かくとう"},
+	"Poison":   {LANG_ZH_TW: "毒", LANG_ZH_CN: "毒", LANG_JA: "どく"},
+	"Ground":   {LANG_ZH_TW: "地面", LANG_ZH_CN: "地面", LANG_JA: "じめん"},
+	"Flying":   {LANG_ZH_TW: "飛行", LANG_ZH_CN: "飞行", LANG_JA: "ひこう"},
+	"Psychic":  {LANG_ZH_TW: "超能力", LANG_ZH_CN: "超能力", LANG_JA: "エスパー"},
+	"Bug":      {LANG_ZH_TW: "蟲", LANG_ZH_CN: "虫", LANG_JA: "むし"},
+	"Rock":     {LANG_ZH_TW: "岩石", LANG_ZH_CN: "岩石", LANG_JA: "いわ"},
+	"Ghost":    {LANG_ZH_TW: "幽靈", LANG_ZH_CN: "幽灵", LANG_JA: "ゴースト"},
+	"Dragon":   {LANG_ZH_TW: "龍", LANG_ZH_CN: "龙", LANG_JA: "ドラゴン"},
+	"Dark":     {LANG_ZH_TW: "惡", LANG_ZH_CN: "恶", LANG_JA: "あく"},
+	"Steel":    {LANG_ZH_TW: "鋼", LANG_ZH_CN: "钢", LANG_JA: "はがね"},
+	"Fairy":    {LANG_ZH_TW: "妖精", LANG_ZH_CN: "妖精", LANG_JA: "フェアリー"},
+}
+
+func tr(lang, key string, args ...interface{}) string {
+	texts, ok := messageCatalog[key]
+	if !ok {
+		return key
+	}
+	format, ok := texts[lang]
+	if !ok {
+		format = texts[DEFAULT_LANGUAGE]
+	}
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// 英文或沒有翻譯的屬性直接用英文名稱
+func typeName(lang, t string) string {
+	t = normalizeType(t)
+	if name, ok := typeNames[t][lang]; ok {
+		return name
+	}
+	return t
+}
+
+func localName(lang, name, cname, scname, jname string) string {
+	switch lang {
+	case LANG_EN:
+		return name
+	case LANG_ZH_CN:
+		if scname != "" {
+			return scname
+		}
+	case LANG_JA:
+		if jname != "" {
+			return jname
+		}
+	}
+	if cname != "" {
+		return cname
+	}
+	return name
+}
+
+// 在地名稱後面附上英文名稱，方便對照遊戲外的資料
+func displayName(local, name string) string {
+	if local == name {
+		return name
+	}
+	return fmt.Sprintf("%s (%s)", local, name)
+}
+
+func (m Pokemon) LocalName(lang string) string {
+	return localName(lang, m.Name, m.Cname, m.Scname, m.Jname)
+}
+
+func (m Pokemon) DisplayName(lang string) string {
+	return displayName(m.LocalName(lang), m.Name)
+}
+
+func (s PokemonSkill) LocalName(lang string) string {
+	return localName(lang, s.Name, s.Cname, s.Scname, s.Jname)
+}
+
+func (s PokemonSkill) DisplayName(lang string) string {
+	return displayName(s.LocalName(lang), s.Name)
+}
+
+// 英文不分大小寫，其他語言要完全相同
+func (m Pokemon) HasName(name string) bool {
+	if name == "" {
+		return false
+	}
+	return strings.EqualFold(m.Name, name) || name == m.Cname || name == m.Scname || name == m.Jname
+}
+
+// 模糊比對，英文不分大小寫，空字串全部符合
+func nameContains(q, name, cname, scname, jname string) bool {
+	q = strings.ToLower(strings.TrimSpace(q))
+	return q == "" || strings.Contains(strings.ToLower(name), q) ||
+		strings.Contains(cname, q) || strings.Contains(scname, q) || strings.Contains(jname, q)
+}
+
+func (m Pokemon) NameContains(q string) bool {
+	return nameContains(q, m.Name, m.Cname, m.Scname, m.Jname)
+}
+
+func (s PokemonSkill) NameContains(q string) bool {
+	return nameContains(q, s.Name, s.Cname, s.Scname, s.Jname)
+}
@@ -0,0 +1,430 @@
+package pokedict
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+)
+
+const (
+	LINE_CHANNEL_SECRET = ""
+	LINE_CHANNEL_TOKEN  = ""
+	LINE_API_ROOT       = "https://api.line.me/v2/bot"
+
+	// 一次 reply 或 push 最多五則訊息，carousel 最多 12 張卡片
+	lineMaxMessages = 5
+	lineMaxBubbles  = 12
+	lineMaxAltText  = 400
+)
+
+type LineWebhook struct {
+	Destination string      `json:"destination"`
+	Events      []LineEvent `json:"events"`
+}
+
+type LineSource struct {
+	Type    string `json:"type"`
+	UserId  string `json:"userId,omitempty"`
+	GroupId string `json:"groupId,omitempty"`
+	RoomId  string `json:"roomId,omitempty"`
+}
+
+type LineEvent struct {
+	Type           string        `json:"type"`
+	WebhookEventId string        `json:"webhookEventId,omitempty"`
+	ReplyToken     string        `json:"replyToken,omitempty"`
+	Timestamp      int64         `json:"timestamp"`
+	Source         LineSource    `json:"source"`
+	Message        *LineMessage  `json:"message,omitempty"`
+	Postback       *LinePostback `json:"postback,omitempty"`
+}
+
+type LineMessage struct {
+	Id        string  `json:"id"`
+	Type      string  `json:"type"`
+	Text      string  `json:"text,omitempty"`
+	Address   string  `json:"address,omitempty"`
+	Latitude  float64 `json:"latitude,omitempty"`
+	Longitude float64 `json:"longitude,omitempty"`
+}
+
+type LinePostback struct {
+	Data string `json:"data"`
+}
+
+func init() {
+	http.HandleFunc("/lineCallback", lineCBHandler)
+}
+
+// X-Line-Signature 是以 channel secret 對 body 做 HMAC-SHA256 再 base64
+func verifyLineSignature(secret string, body []byte, signature string) bool {
+	expected, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil || secret == "" {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// LINE 的使用者 id 是字串，雜湊成負數當作 UserStore 的 key，不會和 Messenger 的 id 重複
+func lineUserKey(userId string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(userId))
+	return -int64(h.Sum64() >> 1)
+}
+
+// 群組和聊天室裡沒有提供 userId 的事件，狀態記在整個群組或聊天室上
+func lineStateKey(source LineSource) (key int64, ok bool) {
+	id := source.UserId
+	if id == "" {
+		id = lineSourceId(source)
+	}
+	if id == "" {
+		return
+	}
+	return lineUserKey(id), true
+}
+
+func lineEventKey(event LineEvent) string {
+	switch {
+	case event.WebhookEventId != "":
+		return "line:event:" + event.WebhookEventId
+	case event.Message != nil && event.Message.Id != "":
+		return "line:message:" + event.Message.Id
+	}
+	return ""
+}
+
+// 群組裡的訊息要回到群組
+func lineSourceId(source LineSource) string {
+	switch source.Type {
+	case "group":
+		return source.GroupId
+	case "room":
+		return source.RoomId
+	}
+	return source.UserId
+}
+
+func lineCBHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	ctx := appengine.NewContext(r)
+
+	if r.Method != "POST" {
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !verifyLineSignature(LINE_CHANNEL_SECRET, body, r.Header.Get("X-Line-Signature")) {
+		log.Warningf(ctx, "invalid line signature")
+		http.Error(w, "Invalid Signature", http.StatusForbidden)
+		return
+	}
+
+	var webhook LineWebhook
+	if err := json.Unmarshal(body, &webhook); err != nil {
+		// LINE 收到非 2xx 會重送，格式錯誤的內容只記錄下來
+		log.Errorf(ctx, "unable to parse line webhook: %s: %s", err, body)
+		fmt.Fprint(w, "")
+		return
+	}
+
+	failed := false
+	for _, event := range webhook.Events {
+		log.Debugf(ctx, "%+v", event)
+		key, ok := lineStateKey(event.Source)
+		if !ok {
+			log.Warningf(ctx, "ignore line event without source: %+v", event.Source)
+			continue
+		}
+		err := acceptEvent(ctx, lineEventKey(event), PLATFORM_LINE, key, event)
+		if err != nil {
+			log.Errorf(ctx, "enqueue line event from %s: %s", lineSourceId(event.Source), err)
+			failed = true
+		}
+	}
+	if failed {
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprint(w, "")
+}
+
+func (c *MessagingClient) ReplyLine(ctx context.Context, replyToken string, messages []interface{}) error {
+	return c.postLine(ctx, "/message/reply", replyToken, map[string]interface{}{
+		"replyToken": replyToken,
+		"messages":   messages,
+	})
+}
+
+func (c *MessagingClient) PushLine(ctx context.Context, to string, messages []interface{}) error {
+	return c.postLine(ctx, "/message/push", to, map[string]interface{}{
+		"to":       to,
+		"messages": messages,
+	})
+}
+
+func (c *MessagingClient) postLine(ctx context.Context, path, recipient string, payload interface{}) error {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	log.Debugf(ctx, "LINE %s: %s", path, b)
+	_, err = c.do(ctx, PLATFORM_LINE, recipient, func() (*http.Request, error) {
+		req, err := http.NewRequest("POST", c.LineAPIRoot+path, bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+LINE_CHANNEL_TOKEN)
+		return req, nil
+	})
+	if err == nil {
+		recordSent(ctx, PLATFORM_LINE)
+	}
+	return err
+}
+
+// LineReplier 優先用 reply token 回覆 (免費)，token 用過或過期後改用 push
+type LineReplier struct {
+	Client     *MessagingClient
+	To         string
+	ReplyToken string
+}
+
+func newLineReplier(ctx context.Context, event LineEvent) *LineReplier {
+	return &LineReplier{
+		Client:     newMessagingClient(ctx),
+		To:         lineSourceId(event.Source),
+		ReplyToken: event.ReplyToken,
+	}
+}
+
+func (s *LineReplier) Send(ctx context.Context, messages ...interface{}) error {
+	if len(messages) > lineMaxMessages {
+		messages = messages[:lineMaxMessages]
+	}
+	return sendReplyOnce(ctx, func() error {
+		return s.send(ctx, messages)
+	})
+}
+
+func (s *LineReplier) send(ctx context.Context, messages []interface{}) error {
+	if s.ReplyToken != "" {
+		// reply token 只能用一次
+		token := s.ReplyToken
+		s.ReplyToken = ""
+		err := s.Client.ReplyLine(ctx, token, messages)
+		if !isSendError(err, SendErrorBadRequest) {
+			return err
+		}
+		log.Infof(ctx, "reply to %s failed, push instead: %s", s.To, err)
+	}
+	return s.Client.PushLine(ctx, s.To, messages)
+}
+
+func lineText(text string, quickReplies []map[string]interface{}) map[string]interface{} {
+	message := map[string]interface{}{
+		"type": "text",
+		"text": text,
+	}
+	if quickReplies != nil {
+		message["quickReply"] = map[string]interface{}{"items": quickReplies}
+	}
+	return message
+}
+
+func lineQuickReply(action map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"type":   "action",
+		"action": action,
+	}
+}
+
+func linePostbackAction(label, data string) map[string]interface{} {
+	return map[string]interface{}{
+		"type":        "postback",
+		"label":       label,
+		"data":        data,
+		"displayText": label,
+	}
+}
+
+// 把 Messenger 的按鈕轉成 LINE 的 action
+func lineButtonAction(b FBButtonItem) map[string]interface{} {
+	if b.Type == "postback" {
+		return linePostbackAction(b.Title, b.Payload)
+	}
+	return map[string]interface{}{
+		"type":  "uri",
+		"label": b.Title,
+		"uri":   b.Url,
+	}
+}
+
+// LINE 只接受 https 的圖片
+func lineImageURL(uri string) string {
+	if strings.HasPrefix(uri, "http://") {
+		return "https://" + strings.TrimPrefix(uri, "http://")
+	}
+	return uri
+}
+
+// 把 generic template 的一個 element 轉成 Flex Message 的 bubble
+func lineBubble(element map[string]interface{}) map[string]interface{} {
+	title, _ := element["title"].(string)
+	subtitle, _ := element["subtitle"].(string)
+	imageURL, _ := element["image_url"].(string)
+	itemURL, _ := element["item_url"].(string)
+	buttons, _ := element["buttons"].([]FBButtonItem)
+
+	body := []map[string]interface{}{
+		map[string]interface{}{"type": "text", "text": title, "weight": "bold", "size": "lg", "wrap": true},
+	}
+	if subtitle != "" {
+		body = append(body, map[string]interface{}{"type": "text", "text": subtitle, "size": "sm", "color": "#666666", "wrap": true})
+	}
+	bubble := map[string]interface{}{
+		"type": "bubble",
+		"body": map[string]interface{}{"type": "box", "layout": "vertical", "spacing": "sm", "contents": body},
+	}
+
+	if imageURL != "" {
+		hero := map[string]interface{}{
+			"type":        "image",
+			"url":         lineImageURL(imageURL),
+			"size":        "full",
+			"aspectRatio": "1:1",
+			"aspectMode":  "fit",
+		}
+		if itemURL != "" {
+			hero["action"] = map[string]interface{}{"type": "uri", "uri": itemURL}
+		}
+		bubble["hero"] = hero
+	}
+
+	if len(buttons) != 0 {
+		footer := []map[string]interface{}{}
+		for _, b := range buttons {
+			footer = append(footer, map[string]interface{}{"type": "button", "style": "link", "action": lineButtonAction(b)})
+		}
+		bubble["footer"] = map[string]interface{}{"type": "box", "layout": "vertical", "contents": footer}
+	}
+	return bubble
+}
+
+func lineFlexCarousel(altText string, elements []map[string]interface{}) map[string]interface{} {
+	if len(elements) > lineMaxBubbles {
+		elements = elements[:lineMaxBubbles]
+	}
+	bubbles := []map[string]interface{}{}
+	for _, element := range elements {
+		bubbles = append(bubbles, lineBubble(element))
+	}
+	return map[string]interface{}{
+		"type":    "flex",
+		"altText": truncateText(altText, lineMaxAltText),
+		"contents": map[string]interface{}{
+			"type":     "carousel",
+			"contents": bubbles,
+		},
+	}
+}
+
+func handleLineEvent(ctx context.Context, event LineEvent) error {
+	key, ok := lineStateKey(event.Source)
+	if !ok {
+		return nil
+	}
+	return users.Update(ctx, key, func(user *User) error {
+		return handleLineUserEvent(ctx, user, event)
+	})
+}
+
+func handleLineUserEvent(ctx context.Context, user *User, event LineEvent) (err error) {
+	s := newLineReplier(ctx, event)
+	// LINE 沒有固定選單，其他文字都當作查技能
+	router := commandRouter{defaultAction: "QUERY_SKILL"}
+
+	var reply Reply
+	switch event.Type {
+	case "follow":
+		user.TodoAction = ""
+		reply = textReply(welcomeText(user))
+	case "message":
+		if event.Message == nil {
+			break
+		}
+		switch event.Message.Type {
+		case "text":
+			reply = router.text(ctx, user, event.Message.Text)
+		case "location":
+			reply = router.location(ctx, user, event.Message.Latitude, event.Message.Longitude)
+		}
+	case "postback":
+		if event.Postback != nil {
+			reply = router.payload(ctx, user, event.Postback.Data)
+		}
+	}
+
+	err = lineSendReply(ctx, s, user, reply)
+	if isSendError(err, SendErrorUserBlocked) {
+		log.Infof(ctx, "line source %s is not available: %s", lineSourceId(event.Source), err)
+		err = nil
+	}
+	return
+}
+
+func lineQuickReplies(lang string, reply Reply) (quickReplies []map[string]interface{}) {
+	if reply.AskLocation {
+		quickReplies = append(quickReplies, lineQuickReply(map[string]interface{}{"type": "location", "label": tr(lang, "send_location")}))
+	}
+	for _, c := range reply.Choices {
+		quickReplies = append(quickReplies, lineQuickReply(linePostbackAction(c.Title, c.Payload)))
+	}
+	return
+}
+
+// 整個回覆用一次 reply 送出，卡片送不出去時改回覆查詢失敗
+func lineSendReply(ctx context.Context, s *LineReplier, user *User, reply Reply) (err error) {
+	lang := userLanguage(user)
+	messages := []interface{}{}
+	for i, text := range reply.Texts {
+		var quickReplies []map[string]interface{}
+		if i == len(reply.Texts)-1 {
+			quickReplies = lineQuickReplies(lang, reply)
+		}
+		messages = append(messages, lineText(text, quickReplies))
+	}
+	if len(reply.Cards) != 0 {
+		messages = append(messages, lineFlexCarousel(reply.Title, reply.Cards))
+	}
+	if len(messages) == 0 {
+		return
+	}
+
+	err = s.Send(ctx, messages...)
+	if len(reply.Cards) != 0 && isSendError(err, SendErrorBadRequest) {
+		log.Errorf(ctx, "send flex message: %s", err)
+		err = s.Send(ctx, lineText(tr(lang, "query_failed"), nil))
+	}
+	return
+}
@@ -0,0 +1,91 @@
+package pokedict
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func lineSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyLineSignature(t *testing.T) {
+	body := []byte(`{"events":[{"type":"message","message":{"type":"text","text":"Bite"}}]}`)
+	signature := lineSignature("secret", body)
+
+	tests := []struct {
+		name      string
+		secret    string
+		body      []byte
+		signature string
+		ok        bool
+	}{
+		{"valid", "secret", body, signature, true},
+		{"tampered body", "secret", []byte(strings.Replace(string(body), "Bite", "Bote", 1)), signature, false},
+		{"wrong secret", "other", body, signature, false},
+		{"not base64", "secret", body, "not base64!", false},
+		{"no signature", "secret", body, "", false},
+		// 沒設定 secret 時任何人都能用空字串簽名，一律拒絕
+		{"empty secret", "", body, lineSignature("", body), false},
+	}
+	for _, test := range tests {
+		if ok := verifyLineSignature(test.secret, test.body, test.signature); ok != test.ok {
+			t.Errorf("%s: got %v, want %v", test.name, ok, test.ok)
+		}
+	}
+}
+
+// 群組和聊天室裡沒有 userId 的事件也要處理，狀態記在群組或聊天室上
+func TestLineGroupEvents(t *testing.T) {
+	defer func(tr func(ctx context.Context) http.RoundTripper, l *recipientLimiter, u UserStore) {
+		messagingTransport, sendLimiter, users = tr, l, u
+	}(messagingTransport, sendLimiter, users)
+	transport := &flakyTransport{failed: true}
+	messagingTransport = func(ctx context.Context) http.RoundTripper { return transport }
+	sendLimiter = newRecipientLimiter(0)
+	users = NewLocalUserStore()
+
+	sources := []LineSource{
+		{Type: "group", GroupId: "Cgroup"},
+		{Type: "room", RoomId: "Rroom"},
+	}
+	for i, source := range sources {
+		event := LineEvent{
+			Type:       "message",
+			ReplyToken: "token-" + lineSourceId(source),
+			Source:     source,
+			Message:    &LineMessage{Id: "message-" + source.Type, Type: "text", Text: "騎車"},
+		}
+		if err := handleLineEvent(withReplyProgress(testCtx, lineEventKey(event)), event); err != nil {
+			t.Fatalf("%s: %s", source.Type, err)
+		}
+
+		key, ok := lineStateKey(source)
+		if !ok {
+			t.Fatalf("%s: no state key", source.Type)
+		}
+		if u, _ := users.Load(testCtx, key); u.TravelMode != TRAVEL_BIKE {
+			t.Errorf("%s: got travel mode %q, want %q", source.Type, u.TravelMode, TRAVEL_BIKE)
+		}
+		if len(transport.sent) != i+1 || !strings.Contains(transport.sent[i], event.ReplyToken) {
+			t.Errorf("%s: reply was not sent: %v", source.Type, transport.sent)
+		}
+	}
+
+	// 同一個群組裡有 userId 的事件仍然依使用者記錄
+	withUser := LineSource{Type: "group", GroupId: "Cgroup", UserId: "Uuser"}
+	if key, _ := lineStateKey(withUser); key != lineUserKey("Uuser") {
+		t.Errorf("group event with a user id should be keyed by the user")
+	}
+	if _, ok := lineStateKey(LineSource{Type: "group"}); ok {
+		t.Errorf("event without any source id should be ignored")
+	}
+}
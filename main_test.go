@@ -0,0 +1,43 @@
+package pokedict
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/aetest"
+)
+
+// 需要 App Engine context 的測試共用同一個 dev_appserver
+var (
+	testInstance aetest.Instance
+	testCtx      context.Context
+)
+
+// 測試 handler 用的請求，handler 裡的 appengine.NewContext 才拿得到 context
+func newTestRequest(t *testing.T, method, url string, body io.Reader) *http.Request {
+	r, err := testInstance.NewRequest(method, url, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestMain(m *testing.M) {
+	inst, err := aetest.NewInstance(&aetest.Options{StronglyConsistentDatastore: true})
+	if err != nil {
+		panic(err)
+	}
+	r, err := inst.NewRequest("GET", "/", nil)
+	if err != nil {
+		panic(err)
+	}
+	testInstance = inst
+	testCtx = appengine.NewContext(r)
+	code := m.Run()
+	inst.Close()
+	os.Exit(code)
+}
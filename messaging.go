@@ -0,0 +1,382 @@
+package pokedict
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/urlfetch"
+)
+
+const (
+	PLATFORM_FB   = "fb"
+	PLATFORM_TG   = "tg"
+	PLATFORM_LINE = "line"
+	// Discord 的 interaction 是同步回應，只有管理指令會用到 API
+	PLATFORM_DISCORD = "discord"
+
+	sendMaxRetries  = 3
+	sendBaseBackoff = 500 * time.Millisecond
+	sendMaxBackoff  = 5 * time.Second
+	// 對同一個收件人連續送訊息的最短間隔
+	recipientInterval = 200 * time.Millisecond
+)
+
+type SendErrorKind int
+
+const (
+	SendErrorUnknown SendErrorKind = iota
+	SendErrorRateLimited
+	SendErrorUserBlocked
+	SendErrorInvalidToken
+	SendErrorServer
+	SendErrorBadRequest
+)
+
+type SendError struct {
+	Kind       SendErrorKind
+	Platform   string
+	StatusCode int
+	Code       int
+	Message    string
+	RetryAfter time.Duration
+}
+
+func (e *SendError) Error() string {
+	return fmt.Sprintf("%s send error (status %d, code %d): %s", e.Platform, e.StatusCode, e.Code, e.Message)
+}
+
+func (e *SendError) Temporary() bool {
+	return e.Kind == SendErrorRateLimited || e.Kind == SendErrorServer
+}
+
+func isSendError(err error, kind SendErrorKind) bool {
+	e, ok := err.(*SendError)
+	return ok && e.Kind == kind
+}
+
+type fbErrorResponse struct {
+	Error struct {
+		Message      string `json:"message"`
+		Type         string `json:"type"`
+		Code         int    `json:"code"`
+		ErrorSubcode int    `json:"error_subcode"`
+	} `json:"error"`
+}
+
+func parseFBError(statusCode int, body []byte) *SendError {
+	e := &SendError{Platform: PLATFORM_FB, StatusCode: statusCode, Message: string(body)}
+	var r fbErrorResponse
+	if err := json.Unmarshal(body, &r); err == nil && r.Error.Code != 0 {
+		e.Code = r.Error.Code
+		e.Message = r.Error.Message
+	}
+
+	switch {
+	case e.Code == 4 || e.Code == 32 || e.Code == 613 || statusCode == 429:
+		e.Kind = SendErrorRateLimited
+	case e.Code == 190:
+		e.Kind = SendErrorInvalidToken
+	case e.Code == 551 || e.Code == 10 && r.Error.ErrorSubcode == 2018108 || e.Code == 200 && r.Error.ErrorSubcode == 1545041:
+		e.Kind = SendErrorUserBlocked
+	case statusCode >= 500:
+		e.Kind = SendErrorServer
+	case statusCode >= 400:
+		e.Kind = SendErrorBadRequest
+	}
+	return e
+}
+
+type tgErrorResponse struct {
+	Ok          bool   `json:"ok"`
+	ErrorCode   int    `json:"error_code"`
+	Description string `json:"description"`
+	Parameters  struct {
+		RetryAfter int `json:"retry_after"`
+	} `json:"parameters"`
+}
+
+func parseTGError(statusCode int, body []byte) *SendError {
+	e := &SendError{Platform: PLATFORM_TG, StatusCode: statusCode, Message: string(body)}
+	var r tgErrorResponse
+	if err := json.Unmarshal(body, &r); err == nil && r.ErrorCode != 0 {
+		e.Code = r.ErrorCode
+		e.Message = r.Description
+		e.RetryAfter = time.Duration(r.Parameters.RetryAfter) * time.Second
+	}
+
+	switch {
+	case statusCode == 429:
+		e.Kind = SendErrorRateLimited
+	case statusCode == 401 || statusCode == 404:
+		e.Kind = SendErrorInvalidToken
+	case statusCode == 403:
+		e.Kind = SendErrorUserBlocked
+	case statusCode >= 500:
+		e.Kind = SendErrorServer
+	case statusCode >= 400:
+		e.Kind = SendErrorBadRequest
+	}
+	return e
+}
+
+type lineErrorResponse struct {
+	Message string `json:"message"`
+}
+
+func parseLineError(statusCode int, body []byte) *SendError {
+	e := &SendError{Platform: PLATFORM_LINE, StatusCode: statusCode, Message: string(body)}
+	var r lineErrorResponse
+	if err := json.Unmarshal(body, &r); err == nil && r.Message != "" {
+		e.Message = r.Message
+	}
+
+	// LINE 的錯誤沒有細分代碼，只能看狀態碼
+	switch {
+	case statusCode == 429:
+		e.Kind = SendErrorRateLimited
+	case statusCode == 401:
+		e.Kind = SendErrorInvalidToken
+	case statusCode >= 500:
+		e.Kind = SendErrorServer
+	case statusCode >= 400:
+		e.Kind = SendErrorBadRequest
+	}
+	return e
+}
+
+type discordErrorResponse struct {
+	Message    string  `json:"message"`
+	Code       int     `json:"code"`
+	RetryAfter float64 `json:"retry_after"`
+}
+
+func parseDiscordError(statusCode int, body []byte) *SendError {
+	e := &SendError{Platform: PLATFORM_DISCORD, StatusCode: statusCode, Message: string(body)}
+	var r discordErrorResponse
+	if err := json.Unmarshal(body, &r); err == nil && r.Message != "" {
+		e.Code = r.Code
+		e.Message = r.Message
+		e.RetryAfter = time.Duration(r.RetryAfter * float64(time.Second))
+	}
+
+	switch {
+	case statusCode == 429:
+		e.Kind = SendErrorRateLimited
+	case statusCode == 401:
+		e.Kind = SendErrorInvalidToken
+	case statusCode >= 500:
+		e.Kind = SendErrorServer
+	case statusCode >= 400:
+		e.Kind = SendErrorBadRequest
+	}
+	return e
+}
+
+// 記錄每個收件人上次送出的時間，同一個 instance 內共用
+type recipientLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     map[string]time.Time
+}
+
+func newRecipientLimiter(interval time.Duration) *recipientLimiter {
+	return &recipientLimiter{interval: interval, next: map[string]time.Time{}}
+}
+
+// 回傳要等待多久才能送給這個收件人，並預約下一個時段
+func (l *recipientLimiter) reserve(recipient string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	at, ok := l.next[recipient]
+	if !ok || at.Before(now) {
+		at = now
+	}
+	l.next[recipient] = at.Add(l.interval)
+
+	// 避免 map 無限長大
+	if len(l.next) > 10000 {
+		for k, t := range l.next {
+			if t.Before(now) {
+				delete(l.next, k)
+			}
+		}
+	}
+	return at.Sub(now)
+}
+
+var sendLimiter *recipientLimiter = newRecipientLimiter(recipientInterval)
+
+type MessagingClient struct {
+	RoundTrip   func(*http.Request) (*http.Response, error)
+	MaxRetries  int
+	BaseBackoff time.Duration
+	Limiter     *recipientLimiter
+	Sleep       func(time.Duration)
+
+	FBMessageURI string
+	TGAPIRoot    string
+	LineAPIRoot  string
+
+	DiscordAPIRoot string
+}
+
+// 測試時換成假的 transport
+var messagingTransport func(ctx context.Context) http.RoundTripper = func(ctx context.Context) http.RoundTripper {
+	return &urlfetch.Transport{Context: ctx}
+}
+
+func newMessagingClient(ctx context.Context) *MessagingClient {
+	return &MessagingClient{
+		RoundTrip:    messagingTransport(ctx).RoundTrip,
+		MaxRetries:   sendMaxRetries,
+		BaseBackoff:  sendBaseBackoff,
+		Limiter:      sendLimiter,
+		Sleep:        time.Sleep,
+		FBMessageURI: fbMessageURI(pageFromContext(ctx)),
+		TGAPIRoot:    TG_APIROOT,
+		LineAPIRoot:  LINE_API_ROOT,
+
+		DiscordAPIRoot: DISCORD_API_ROOT,
+	}
+}
+
+func (c *MessagingClient) backoff(attempt int, e *SendError) time.Duration {
+	d := c.BaseBackoff << uint(attempt)
+	if e != nil && e.RetryAfter > 0 {
+		d = e.RetryAfter
+	}
+	if d > sendMaxBackoff {
+		d = sendMaxBackoff
+	}
+	return d
+}
+
+// 連線錯誤時不知道對方有沒有收到，只有重送也沒關係的方法才重試
+func isIdempotent(method string) bool {
+	return method == "GET" || method == "HEAD" || method == "PUT" || method == "DELETE"
+}
+
+// 送出請求，5xx 與 429 會重試，其他錯誤直接回傳
+// 要求等待超過 sendMaxBackoff 時不在這裡等，直接回傳錯誤讓呼叫端晚點再試
+func (c *MessagingClient) do(ctx context.Context, platform, recipient string, newRequest func() (*http.Request, error)) (body []byte, err error) {
+	for attempt := 0; ; attempt++ {
+		if c.Limiter != nil {
+			if wait := c.Limiter.reserve(platform + ":" + recipient); wait > 0 {
+				c.Sleep(wait)
+			}
+		}
+
+		req, err := newRequest()
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := c.RoundTrip(req)
+		if err != nil {
+			if attempt < c.MaxRetries && isIdempotent(req.Method) {
+				log.Warningf(ctx, "send to %s failed, retry: %s", recipient, err)
+				c.Sleep(c.backoff(attempt, nil))
+				continue
+			}
+			return nil, err
+		}
+
+		body, err = ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == 200 {
+			return body, nil
+		}
+
+		var sendErr *SendError
+		switch platform {
+		case PLATFORM_TG:
+			sendErr = parseTGError(resp.StatusCode, body)
+		case PLATFORM_LINE:
+			sendErr = parseLineError(resp.StatusCode, body)
+		case PLATFORM_DISCORD:
+			sendErr = parseDiscordError(resp.StatusCode, body)
+		default:
+			sendErr = parseFBError(resp.StatusCode, body)
+		}
+		if retryAfter := resp.Header.Get("Retry-After"); retryAfter != "" && sendErr.RetryAfter == 0 {
+			if seconds, err := strconv.Atoi(retryAfter); err == nil {
+				sendErr.RetryAfter = time.Duration(seconds) * time.Second
+			}
+		}
+
+		log.Infof(ctx, "Deliver status: %s, %s", resp.Status, sendErr.Message)
+		if !sendErr.Temporary() || attempt >= c.MaxRetries || sendErr.RetryAfter > sendMaxBackoff {
+			return body, sendErr
+		}
+		c.Sleep(c.backoff(attempt, sendErr))
+	}
+}
+
+func (c *MessagingClient) SendFB(ctx context.Context, recipientId int64, message interface{}) error {
+	err := sendReplyOnce(ctx, func() error {
+		return c.postFB(ctx, recipientId, map[string]interface{}{
+			"recipient": FBRecipient{recipientId},
+			"message":   message,
+		})
+	})
+	if err == nil {
+		recordSent(ctx, PLATFORM_FB)
+	}
+	return err
+}
+
+// SendFBAction 送出 typing_on、typing_off 或 mark_seen
+func (c *MessagingClient) SendFBAction(ctx context.Context, recipientId int64, action string) error {
+	return c.postFB(ctx, recipientId, map[string]interface{}{
+		"recipient":     FBRecipient{recipientId},
+		"sender_action": action,
+	})
+}
+
+func (c *MessagingClient) postFB(ctx context.Context, recipientId int64, payload interface{}) error {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	log.Debugf(ctx, "Payload %s", b)
+	_, err = c.do(ctx, PLATFORM_FB, strconv.FormatInt(recipientId, 10), func() (*http.Request, error) {
+		req, err := http.NewRequest("POST", c.FBMessageURI, bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	})
+	return err
+}
+
+func (c *MessagingClient) CallTG(ctx context.Context, method string, chatId int64, v url.Values) ([]byte, error) {
+	v.Set("chat_id", strconv.FormatInt(chatId, 10))
+	form := v.Encode()
+
+	log.Debugf(ctx, "Telegram %s: %s", method, form)
+	return c.do(ctx, PLATFORM_TG, v.Get("chat_id"), func() (*http.Request, error) {
+		req, err := http.NewRequest("POST", c.TGAPIRoot+"/"+method, strings.NewReader(form))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return req, nil
+	})
+}
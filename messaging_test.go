@@ -0,0 +1,172 @@
+package pokedict
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+type fakeResponse struct {
+	status     int
+	retryAfter string
+	body       string
+}
+
+// 依序回應 responses，用完後一律回 200
+func newFakeServer(responses []fakeResponse, calls *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		i := *calls
+		*calls++
+		if i >= len(responses) {
+			w.Write([]byte(`{"ok":true}`))
+			return
+		}
+		if responses[i].retryAfter != "" {
+			w.Header().Set("Retry-After", responses[i].retryAfter)
+		}
+		w.WriteHeader(responses[i].status)
+		w.Write([]byte(responses[i].body))
+	}))
+}
+
+func newTestClient(root string, sleeps *[]time.Duration) *MessagingClient {
+	return &MessagingClient{
+		RoundTrip:    http.DefaultTransport.RoundTrip,
+		MaxRetries:   sendMaxRetries,
+		BaseBackoff:  sendBaseBackoff,
+		Sleep:        func(d time.Duration) { *sleeps = append(*sleeps, d) },
+		FBMessageURI: root,
+		TGAPIRoot:    root,
+
+		DiscordAPIRoot: root,
+	}
+}
+
+func TestMessagingClientRetry(t *testing.T) {
+	fbServerError := fakeResponse{500, "", `{"error":{"message":"An unknown error occurred","code":1}}`}
+	tests := []struct {
+		name      string
+		platform  string
+		responses []fakeResponse
+		calls     int
+		sleeps    []time.Duration
+		kind      SendErrorKind
+	}{
+		{
+			name:      "429 with Retry-After header",
+			platform:  PLATFORM_TG,
+			responses: []fakeResponse{{429, "2", `{"ok":false,"error_code":429,"description":"Too Many Requests"}`}},
+			calls:     2,
+			sleeps:    []time.Duration{2 * time.Second},
+		},
+		{
+			name:      "429 with retry_after in body",
+			platform:  PLATFORM_TG,
+			responses: []fakeResponse{{429, "", `{"ok":false,"error_code":429,"description":"Too Many Requests","parameters":{"retry_after":3}}`}},
+			calls:     2,
+			sleeps:    []time.Duration{3 * time.Second},
+		},
+		{
+			name:      "429 asking to wait longer than the max backoff",
+			platform:  PLATFORM_TG,
+			responses: []fakeResponse{{429, "60", `{"ok":false,"error_code":429,"description":"Too Many Requests"}`}},
+			calls:     1,
+			kind:      SendErrorRateLimited,
+		},
+		{
+			name:      "5xx then success",
+			platform:  PLATFORM_FB,
+			responses: []fakeResponse{fbServerError, fbServerError},
+			calls:     3,
+			sleeps:    []time.Duration{500 * time.Millisecond, time.Second},
+		},
+		{
+			name:      "5xx until retries run out",
+			platform:  PLATFORM_FB,
+			responses: []fakeResponse{fbServerError, fbServerError, fbServerError, fbServerError},
+			calls:     4,
+			sleeps:    []time.Duration{500 * time.Millisecond, time.Second, 2 * time.Second},
+			kind:      SendErrorServer,
+		},
+		{
+			name:      "permanent 4xx",
+			platform:  PLATFORM_FB,
+			responses: []fakeResponse{{400, "", `{"error":{"message":"Invalid parameter","code":100}}`}},
+			calls:     1,
+			kind:      SendErrorBadRequest,
+		},
+		{
+			name:      "user blocked the bot",
+			platform:  PLATFORM_TG,
+			responses: []fakeResponse{{403, "", `{"ok":false,"error_code":403,"description":"Forbidden: bot was blocked by the user"}`}},
+			calls:     1,
+			kind:      SendErrorUserBlocked,
+		},
+	}
+
+	for _, test := range tests {
+		calls := 0
+		sleeps := []time.Duration{}
+		server := newFakeServer(test.responses, &calls)
+		c := newTestClient(server.URL, &sleeps)
+
+		var err error
+		switch test.platform {
+		case PLATFORM_TG:
+			_, err = c.CallTG(testCtx, "sendMessage", 1, url.Values{"text": {"hi"}})
+		default:
+			err = c.SendFB(testCtx, 1, map[string]string{"text": "hi"})
+		}
+		server.Close()
+
+		if test.kind == SendErrorUnknown {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", test.name, err)
+			}
+		} else if !isSendError(err, test.kind) {
+			t.Errorf("%s: got error %v, want kind %d", test.name, err, test.kind)
+		}
+		if calls != test.calls {
+			t.Errorf("%s: got %d calls, want %d", test.name, calls, test.calls)
+		}
+		if len(sleeps) != len(test.sleeps) {
+			t.Errorf("%s: got sleeps %v, want %v", test.name, sleeps, test.sleeps)
+			continue
+		}
+		for i := range sleeps {
+			if sleeps[i] != test.sleeps[i] || sleeps[i] > sendMaxBackoff {
+				t.Errorf("%s: got sleeps %v, want %v", test.name, sleeps, test.sleeps)
+				break
+			}
+		}
+	}
+}
+
+func TestMessagingClientTransportError(t *testing.T) {
+	attempts := 0
+	sleeps := []time.Duration{}
+	c := newTestClient("http://example.invalid", &sleeps)
+	c.RoundTrip = func(req *http.Request) (*http.Response, error) {
+		attempts++
+		return nil, errors.New("connection reset by peer")
+	}
+
+	// 送訊息的 POST 可能已經送達，不能重送
+	if err := c.SendFB(testCtx, 1, map[string]string{"text": "hi"}); err == nil {
+		t.Error("SendFB: expected error")
+	}
+	if attempts != 1 {
+		t.Errorf("POST: got %d attempts, want 1", attempts)
+	}
+
+	attempts = 0
+	if _, err := c.CallDiscord(testCtx, "PUT", "/commands", []interface{}{}); err == nil {
+		t.Error("CallDiscord: expected error")
+	}
+	if attempts != sendMaxRetries+1 {
+		t.Errorf("PUT: got %d attempts, want %d", attempts, sendMaxRetries+1)
+	}
+}
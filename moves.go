@@ -0,0 +1,113 @@
+package pokedict
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+type ResolvedMove struct {
+	Name  string
+	Skill PokemonSkill
+	// 名稱在技能資料中找不到時為 false
+	Found    bool
+	SameType bool
+}
+
+func skillKey(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+func resolveMoves(m Pokemon, names []string) []ResolvedMove {
+	moves := []ResolvedMove{}
+	for _, name := range names {
+		move := ResolvedMove{Name: name}
+		if s, ok := skillByName(name); ok {
+			move.Skill = s
+			move.Found = true
+			move.SameType = isSameType(m, s.Type)
+		}
+		moves = append(moves, move)
+	}
+	return moves
+}
+
+func monsterMoves(ctx context.Context, m Pokemon) (fast, charged []ResolvedMove) {
+	loadGameData(ctx)
+	return resolveMoves(m, m.FastMoves), resolveMoves(m, m.ChargedMoves)
+}
+
+// 一行列出所有招式，★ 表示屬性一致，? 表示資料中找不到
+func formatMoveSummary(lang string, moves []ResolvedMove) string {
+	items := []string{}
+	for _, move := range moves {
+		if !move.Found {
+			items = append(items, fmt.Sprintf("%s(?)", move.Name))
+			continue
+		}
+		stab := ""
+		if move.SameType {
+			stab = "★"
+		}
+		items = append(items, fmt.Sprintf("%s(%s%s %.1f DPS %.0fE)", move.Skill.LocalName(lang), typeName(lang, move.Skill.Type), stab, move.Skill.Dps, move.Skill.Energy))
+	}
+	return strings.Join(items, ", ")
+}
+
+// Messenger 卡片只放得下每一類最高的 DPS，各招式的屬性和能量在技能明細裡
+func formatBestDps(moves []ResolvedMove) string {
+	var best *ResolvedMove
+	for i, move := range moves {
+		if move.Found && (best == nil || move.Skill.Dps > best.Skill.Dps) {
+			best = &moves[i]
+		}
+	}
+	if best == nil {
+		return "?"
+	}
+	stab := ""
+	if best.SameType {
+		stab = "★"
+	}
+	return fmt.Sprintf("%.1f%s", best.Skill.Dps, stab)
+}
+
+func countUnresolved(groups ...[]ResolvedMove) (n int) {
+	for _, moves := range groups {
+		for _, move := range moves {
+			if !move.Found {
+				n++
+			}
+		}
+	}
+	return
+}
+
+func formatMonsterSkills(lang string, m Pokemon, fast, charged []ResolvedMove) string {
+	buf := bytes.NewBuffer([]byte{})
+	groups := []struct {
+		title string
+		moves []ResolvedMove
+	}{
+		{tr(lang, "fast_moves"), fast},
+		{tr(lang, "charged_moves"), charged},
+	}
+	for _, g := range groups {
+		fmt.Fprintf(buf, "%s:\n", g.title)
+		for _, move := range g.moves {
+			if !move.Found {
+				fmt.Fprintf(buf, "*) %s (%s)\n", move.Name, tr(lang, "move_not_in_data"))
+				continue
+			}
+			s := move.Skill
+			stab := ""
+			if move.SameType {
+				stab = " ★" + tr(lang, "same_type_bonus")
+			}
+			fmt.Fprintf(buf, "*) %s [%s]%s\n%s\n", s.DisplayName(lang), typeName(lang, s.Type), stab, tr(lang, "move_detail", s.Dps, s.Energy))
+		}
+	}
+	return buf.String()
+}
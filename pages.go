@@ -0,0 +1,37 @@
+package pokedict
+
+import (
+	"net/url"
+
+	"golang.org/x/net/context"
+)
+
+const FB_GRAPH_ROOT = "https://graph.facebook.com/v2.6"
+
+// 各粉絲專頁的 access token，key 為 page id
+// 沒有列在這裡的專頁使用 PAGE_TOKEN
+var pageTokens map[int64]string = map[int64]string{}
+
+func pageToken(pageId int64) (token string, ok bool) {
+	if token, ok = pageTokens[pageId]; ok && token != "" {
+		return
+	}
+	return PAGE_TOKEN, PAGE_TOKEN != ""
+}
+
+func fbMessageURI(pageId int64) string {
+	token, _ := pageToken(pageId)
+	return FB_GRAPH_ROOT + "/me/messages?access_token=" + url.QueryEscape(token)
+}
+
+type pageIdKey struct{}
+
+// 處理訊息時把 page id 放進 context，回覆時才知道要用哪個專頁的 token
+func withPage(ctx context.Context, pageId int64) context.Context {
+	return context.WithValue(ctx, pageIdKey{}, pageId)
+}
+
+func pageFromContext(ctx context.Context) int64 {
+	pageId, _ := ctx.Value(pageIdKey{}).(int64)
+	return pageId
+}
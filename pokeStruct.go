@@ -1,28 +1,39 @@
 package pokedict
 
+import "time"
+
 type PokemonSkill struct {
 	Id       int64
 	Kind     string
 	Type     string
 	Name     string
 	Cname    string
+	Scname   string
+	Jname    string
 	Damage   float64
 	Cooldown float64
 	Energy   float64
 	Dps      float64
+	Dpe      float64
+	Eps      float64
 }
 
 type Pokemon struct {
-	Id             int64 "json:,string"
+	Id             int64
 	Classification string
 	Name           string
 	Cname          string
+	Scname         string
+	Jname          string
 	MaxCP          int64
 	TypeI          string `json:"Type I"`
 	TypeII         string `json:"Type II,omitempty"`
 	Weaknesses     []string
 	FastMoves      []string `json:"Fast Attack(s)"`
 	ChargedMoves   []string `json:"Special Attack(s)"`
+	BaseAttack     int64    `json:",omitempty"`
+	BaseDefense    int64    `json:",omitempty"`
+	BaseStamina    int64    `json:",omitempty"`
 }
 
 type PokemonPin struct {
@@ -35,4 +46,7 @@ type PokemonPin struct {
 	Distance      float64
 	Address       Address
 	ShortAddr     string
+	TravelTime    time.Duration
+	ArrivalTime   int64
+	Reachable     bool
 }
@@ -4,11 +4,11 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
-	"io"
+	"io/ioutil"
 	"math"
 	"net/http"
 	"net/url"
-	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -19,22 +19,21 @@ import (
 
 	"golang.org/x/net/context"
 	"google.golang.org/appengine"
-	"google.golang.org/appengine/datastore"
 	"google.golang.org/appengine/log"
-	"google.golang.org/appengine/memcache"
 	"google.golang.org/appengine/urlfetch"
 )
 
 const (
-	BOT_TOKEN    = ""
-	PAGE_TOKEN   = ""
-	FBMessageURI = "https://graph.facebook.com/v2.6/me/messages?access_token=" + PAGE_TOKEN
+	BOT_TOKEN  = ""
+	PAGE_TOKEN = ""
 
 	TG_TOKEN      = ""
 	TG_APIROOT    = "https://api.telegram.org/bot" + TG_TOKEN
 	TG_MessageURI = TG_APIROOT + "/sendMessage"
 
-	WELCOME_TEXT = `你好，歡迎使用 PokéDict。請輸入任何遊戲內容，機器人會為您搜尋適當的神奇寶貝資訊。`
+	// generic template 的標題和副標題最多 80 個字，超過會整則送不出去
+	fbMaxTitle    = 80
+	fbMaxSubtitle = 80
 )
 
 var lock sync.Mutex = sync.Mutex{}
@@ -72,6 +71,8 @@ type FBMessageQuickReply struct {
 }
 
 type FBMessageContent struct {
+	Mid         string                `json:"mid,omitempty"`
+	IsEcho      bool                  `json:"is_echo,omitempty"`
 	Text        string                `json:"text"`
 	Seq         int64                 `json:"seq,omitempty"`
 	Attachments []FBMessageAttachment `json:"attachments,omitempty"`
@@ -107,8 +108,9 @@ type FBButtonItem struct {
 }
 
 type FBMessageDelivery struct {
-	Watermark int64 `json:"watermark"`
-	Seq       int64 `json:"seq"`
+	Mids      []string `json:"mids,omitempty"`
+	Watermark int64    `json:"watermark"`
+	Seq       int64    `json:"seq"`
 }
 
 type FBMessagePostback struct {
@@ -121,10 +123,11 @@ type TGEntry struct {
 }
 
 type TGUser struct {
-	Id        int64  `json:"id"`
-	FirstName string `json:"first_name"`
-	LastName  string `json:"last_name"`
-	Username  string `json:"username"`
+	Id           int64  `json:"id"`
+	FirstName    string `json:"first_name"`
+	LastName     string `json:"last_name"`
+	Username     string `json:"username"`
+	LanguageCode string `json:"language_code,omitempty"`
 }
 
 type TGChat struct {
@@ -144,177 +147,154 @@ type TGMessage struct {
 }
 
 type User struct {
-	Id                int64
-	TodoAction        string
-	LastText          string
-	FollowedPokemonId []int64
+	Id                 int64
+	TodoAction         string
+	LastText           string `datastore:",noindex"`
+	TravelMode         string
+	DeliveredWatermark int64
+	Timezone           string
+	TimezoneFixed      bool
+	FollowedPokemonId  []int64
+
+	// 從 Messenger 取得的使用者資料
+	FirstName        string
+	Locale           string
+	ProfileCheckedAt int64
+
+	// 使用者用「語言」指令選的語言，沒選時依 Locale 決定
+	Language string
 }
 
-var users map[int64]*User = map[int64]*User{}
-var monsterMap map[int64]Pokemon = map[int64]Pokemon{}
-var skillMap map[int64]PokemonSkill = map[int64]PokemonSkill{}
+var users UserStore = NewDatastoreUserStore()
 
 func init() {
 	http.HandleFunc("/tgCallback", tgCBHandler)
 	http.HandleFunc("/fbCallback", fbCBHandler)
+	http.HandleFunc("/_ah/warmup", warmupHandler)
 	http.HandleFunc("/", handler)
 }
 
-func loadSkillData(ctx context.Context) {
-	lock.Lock()
-	defer lock.Unlock()
-
-	if len(skillMap) != 0 {
+func handler(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprint(w, "Hi, this is an FB Bot for PokéDict.")
+	ctx := appengine.NewContext(r)
+	if r.Header.Get("X-Appengine-Cron") == "true" {
+		if err := syncGameData(ctx); err != nil {
+			log.Errorf(ctx, "sync game data: %s", err)
+		}
 		return
 	}
+	loadGameData(ctx)
+}
 
-	skillKeys := []*datastore.Key{}
-	skillList := []PokemonSkill{}
-
-	f, err := os.Open("data/fastSkill.json")
-	if err != nil {
-		log.Errorf(ctx, err.Error())
-	}
-	d := json.NewDecoder(f)
-
-	fastSkills := []PokemonSkill{}
-	err = d.Decode(&fastSkills)
-	if err != nil {
-		log.Errorf(ctx, err.Error())
-	}
-	f.Close()
-
-	for i, skill := range fastSkills {
-		skill.Id = int64(i)
-		skill.Kind = "fast"
-		skillKeys = append(skillKeys, datastore.NewKey(ctx, "PokemonSkill", skill.Name, 0, nil))
-		skillList = append(skillList, skill)
-		skillMap[skill.Id] = skill
-	}
-
-	f, err = os.Open("data/chargeSkill.json")
-	if err != nil {
-		log.Errorf(ctx, err.Error())
-	}
-	d = json.NewDecoder(f)
-
-	chargedSkills := []PokemonSkill{}
-	err = d.Decode(&chargedSkills)
-	if err != nil {
-		log.Errorf(ctx, err.Error())
+func warmupHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+	if d := loadGameData(ctx); !d.loaded() {
+		http.Error(w, "fail to load game data", http.StatusInternalServerError)
+		return
 	}
-	f.Close()
+	fmt.Fprint(w, "")
+}
 
-	for i, skill := range chargedSkills {
-		skill.Id = int64(i) + 1000
-		skill.Kind = "charged"
-		skillKeys = append(skillKeys, datastore.NewKey(ctx, "PokemonSkill", skill.Name, 0, nil))
-		skillList = append(skillList, skill)
-		skillMap[skill.Id] = skill
-	}
+func tgSendTextMessage(ctx context.Context, chatId int64, text string) (err error) {
+	return tgSendMessage(ctx, chatId, text, "")
+}
 
-	log.Debugf(ctx, "%+v", skillList)
-	_, err = datastore.PutMulti(ctx, skillKeys, skillList)
-	if err != nil {
-		log.Errorf(ctx, err.Error())
+func tgSendMessage(ctx context.Context, chatId int64, text string, parseMode string) (err error) {
+	v := url.Values{}
+	v.Set("text", text)
+	if parseMode != "" {
+		v.Set("parse_mode", parseMode)
+	}
+	return sendReplyOnce(ctx, func() error {
+		_, err := newMessagingClient(ctx).CallTG(ctx, "sendMessage", chatId, v)
+		return err
+	})
+}
+
+// 帶參數的指令，例如「打 快龍」
+var argCommands map[string]string = map[string]string{
+	"打":        "COUNTER",
+	"counter":  "COUNTER",
+	"模擬":       "SIMULATE",
+	"simulate": "SIMULATE",
+	"比較":       "COMPARE",
+	"compare":  "COMPARE",
+	"隊伍":       "TEAM",
+	"team":     "TEAM",
+}
+
+func splitCommand(text string) (cmd, args string) {
+	fields := strings.SplitN(strings.TrimSpace(text), " ", 2)
+	if len(fields) == 2 {
+		if c, ok := argCommands[strings.ToLower(fields[0])]; ok {
+			return c, strings.TrimSpace(fields[1])
+		}
 	}
+	return "", text
 }
 
-func loadMonsterData(ctx context.Context) {
-	lock.Lock()
-	defer lock.Unlock()
-
-	if len(monsterMap) != 0 {
-		return
-	}
+func tgCBHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+	var tgEntry TGEntry
 
-	monsterKeys := []*datastore.Key{}
-	monsterList := []Pokemon{}
-	f, err := os.Open("data/pokemon.json")
+	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		log.Errorf(ctx, err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	defer f.Close()
-
-	d := json.NewDecoder(f)
-	err = d.Decode(&monsterList)
-	if err != nil {
-		log.Errorf(ctx, err.Error())
+	if err := json.Unmarshal(body, &tgEntry); err != nil {
+		// 回非 2xx 時 Telegram 會一直重送，格式錯誤的內容只記錄下來
+		log.Errorf(ctx, "can not parse tg entry: %s: %s", err, body)
+		fmt.Fprint(w, "")
 		return
 	}
 
-	for _, p := range monsterList {
-		monsterKeys = append(monsterKeys, datastore.NewKey(ctx, "Pokemon", p.Name, 0, nil))
-		monsterMap[p.Id] = p
-	}
-	log.Debugf(ctx, "%+v", monsterList)
-	_, err = datastore.PutMulti(ctx, monsterKeys, monsterList)
+	log.Infof(ctx, "%+v", tgEntry)
+	err = acceptEvent(ctx, tgEventKey(tgEntry), PLATFORM_TG, tgEntry.Message.Chat.Id, tgEntry)
 	if err != nil {
-		log.Errorf(ctx, err.Error())
+		log.Errorf(ctx, "enqueue tg entry: %s", err)
+		http.Error(w, "", http.StatusInternalServerError)
 		return
 	}
+	fmt.Fprint(w, "")
 }
 
-func handler(w http.ResponseWriter, r *http.Request) {
-	fmt.Fprint(w, "Hi, this is an FB Bot for PokéDict.")
-	ctx := appengine.NewContext(r)
-	loadSkillData(ctx)
-	loadMonsterData(ctx)
-}
-
-func tgSendTextMessage(ctx context.Context, chatId int64, text string) (err error) {
-	v := url.Values{}
-	v.Set("chat_id", fmt.Sprintf("%d", chatId))
-	v.Set("text", text)
-
-	url := TG_MessageURI + fmt.Sprintf("?%s", v.Encode())
-	log.Debugf(ctx, "Url: %s", url)
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return
-	}
-
-	tr := &urlfetch.Transport{Context: ctx}
-	resp, err := tr.RoundTrip(req)
-	if err != nil {
+func handleTGEntry(ctx context.Context, tgEntry TGEntry) (err error) {
+	text := tgEntry.Message.Text
+	if text == "" {
 		return
 	}
 
-	if resp.StatusCode != 200 {
-		log.Infof(ctx, "Deliver status: %s", resp.Status)
-		buffer := bytes.NewBuffer([]byte{})
-		_, err = io.Copy(buffer, resp.Body)
-		log.Infof(ctx, buffer.String())
+	var returnText string
+	chatId := tgEntry.Message.Chat.Id
+	lang := languageForLocale(tgEntry.Message.From.LanguageCode)
+	switch cmd, args := splitCommand(text); cmd {
+	case "COUNTER":
+		boss, counters, errText := counterResult(ctx, lang, args)
+		if errText != "" {
+			returnText = errText
+		} else {
+			returnText = formatCounters(lang, boss, counters)
+		}
+	case "SIMULATE":
+		returnText = battleResponse(ctx, lang, args, 40)
+	case "COMPARE":
+		err = tgCompareResponse(ctx, chatId, lang, args)
+	case "TEAM":
+		returnText = teamResponse(ctx, lang, args)
+	default:
+		skills := querySkill(ctx, text)
+		returnText = formatSkills(lang, skills)
 	}
-	return
-}
-
-func tgCBHandler(w http.ResponseWriter, r *http.Request) {
-	ctx := appengine.NewContext(r)
-	var tgEntry TGEntry
 
-	d := json.NewDecoder(r.Body)
-	err := d.Decode(&tgEntry)
-	if err != nil {
-		log.Errorf(ctx, "%s", err.Error())
-		http.Error(w, "can not parse tg entry", http.StatusInternalServerError)
+	if returnText != "" {
+		err = tgSendTextMessage(ctx, chatId, returnText)
 	}
-
-	text := tgEntry.Message.Text
-	if text != "" {
-		skills := querySkill(ctx, []string{text})
-		returnText := formatSkills(skills)
-
-		err := tgSendTextMessage(ctx, tgEntry.Message.Chat.Id, returnText)
-		if err != nil {
-			log.Errorf(ctx, "%s", err.Error())
-			http.Error(w, "fail to deliver a message to a client", http.StatusInternalServerError)
-		}
+	if isSendError(err, SendErrorUserBlocked) {
+		log.Infof(ctx, "chat %d is not available: %s", chatId, err)
+		err = nil
 	}
-
-	log.Infof(ctx, "%+v", tgEntry)
-	fmt.Fprint(w, "")
+	return
 }
 
 func fbSendTextMessage(ctx context.Context, senderId int64, text string, quickReplies []map[string]string) (err error) {
@@ -327,37 +307,23 @@ func fbSendTextMessage(ctx context.Context, senderId int64, text string, quickRe
 	} else {
 		message = map[string]interface{}{"text": text}
 	}
+	return newMessagingClient(ctx).SendFB(ctx, senderId, message)
+}
 
-	payload := map[string]interface{}{
-		"recipient": FBRecipient{senderId},
-		"message":   message,
-	}
-
-	b, err := json.Marshal(payload)
-	if err != nil {
-		return
-	}
-
-	log.Debugf(ctx, "Payload %s", b)
-	req, err := http.NewRequest("POST", FBMessageURI, bytes.NewBuffer(b))
-	if err != nil {
-		return
-	}
-	req.Header.Add("Content-Type", "application/json")
-
-	tr := &urlfetch.Transport{Context: ctx}
-	resp, err := tr.RoundTrip(req)
-	if err != nil {
-		return
+// 失敗只記錄，不影響後續回覆
+func fbSendAction(ctx context.Context, senderId int64, action string) {
+	if err := newMessagingClient(ctx).SendFBAction(ctx, senderId, action); err != nil {
+		log.Warningf(ctx, "send %s to %d: %s", action, senderId, err)
 	}
+}
 
-	if resp.StatusCode != 200 {
-		log.Infof(ctx, "Deliver status: %s", resp.Status)
-		buffer := bytes.NewBuffer([]byte{})
-		_, err = io.Copy(buffer, resp.Body)
-		log.Infof(ctx, buffer.String())
+func setUserLanguage(user *User, text string) (returnText string) {
+	lang, ok := parseLanguage(text)
+	if !ok {
+		return tr(userLanguage(user), "unknown_language")
 	}
-	return
+	user.Language = lang
+	return tr(lang, "language_set", languageNames[lang])
 }
 
 func fbSendGeneralTemplate(ctx context.Context, senderId int64, elements json.RawMessage) (err error) {
@@ -371,148 +337,173 @@ func fbSendGeneralTemplate(ctx context.Context, senderId int64, elements json.Ra
 		return
 	}
 
-	payload := map[string]interface{}{
-		"recipient": FBRecipient{senderId},
-		"message": map[string]interface{}{
-			"attachment": &FBMessageAttachment{
-				Type:    "template",
-				Payload: json.RawMessage(msgBuf),
-			},
+	message := map[string]interface{}{
+		"attachment": &FBMessageAttachment{
+			Type:    "template",
+			Payload: json.RawMessage(msgBuf),
 		},
 	}
+	return newMessagingClient(ctx).SendFB(ctx, senderId, message)
+}
 
-	b, err := json.Marshal(payload)
-	if err != nil {
-		return
-	}
+type skillsById []PokemonSkill
 
-	log.Debugf(ctx, "Payload %s", b)
-	req, err := http.NewRequest("POST", FBMessageURI, bytes.NewBuffer(b))
-	if err != nil {
-		return
-	}
-	req.Header.Add("Content-Type", "application/json")
+func (s skillsById) Len() int           { return len(s) }
+func (s skillsById) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s skillsById) Less(i, j int) bool { return s[i].Id < s[j].Id }
 
-	tr := &urlfetch.Transport{Context: ctx}
-	resp, err := tr.RoundTrip(req)
-	if err != nil {
+type monstersById []Pokemon
+
+func (m monstersById) Len() int           { return len(m) }
+func (m monstersById) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
+func (m monstersById) Less(i, j int) bool { return m[i].Id < m[j].Id }
+
+// 給使用者搜尋用的模糊查詢，名稱完全相同時只回傳那一個
+func querySkill(ctx context.Context, keyword string) (foundSkills []PokemonSkill) {
+	data := loadGameData(ctx)
+
+	foundSkills = make([]PokemonSkill, 0)
+	keyword = skillKey(keyword)
+	if keyword == "" {
 		return
 	}
 
-	if resp.StatusCode != 200 {
-		log.Infof(ctx, "Deliver status: %s", resp.Status)
-		buffer := bytes.NewBuffer([]byte{})
-		_, err = io.Copy(buffer, resp.Body)
-		log.Infof(ctx, buffer.String())
+	if s, ok := data.SkillByName(keyword); ok {
+		return append(foundSkills, s)
+	}
+	for _, s := range data.Skills {
+		if strings.Contains(strings.ToLower(s.Name), keyword) || strings.Contains(s.Cname, keyword) ||
+			strings.Contains(s.Scname, keyword) || strings.Contains(s.Jname, keyword) {
+			foundSkills = append(foundSkills, s)
+		}
 	}
+	sort.Sort(skillsById(foundSkills))
 	return
 }
 
-func querySkill(ctx context.Context, skillNames []string) (foundSkills []PokemonSkill) {
-	if len(skillMap) == 0 {
-		loadSkillData(ctx)
-	}
+func skillById(id int64) (PokemonSkill, bool) {
+	return currentGameData().SkillById(id)
+}
 
-	foundSkills = make([]PokemonSkill, 0)
-	skillToQuery := ""
-	if l := len(skillNames); l > 1 {
-		skillToQuery = strings.Join(skillNames, ",")
-		for _, s := range skillMap {
-			if strings.Contains(strings.ToLower(skillToQuery), strings.ToLower(s.Name)) {
-				foundSkills = append(foundSkills, s)
-			}
-		}
-	} else if l == 1 {
-		skillToQuery = skillNames[0]
-		for _, s := range skillMap {
-			if strings.Contains(strings.ToLower(s.Name), strings.ToLower(skillToQuery)) {
-				foundSkills = append(foundSkills, s)
-			}
+// 以名稱精確查詢，用在寵物資料裡的技能名稱
+func skillByName(name string) (PokemonSkill, bool) {
+	return currentGameData().SkillByName(name)
+}
+
+// 先找英文或中文名稱完全相同的，找不到再看模糊搜尋是否只有一筆
+func findMonster(ctx context.Context, name string) (Pokemon, bool) {
+	data := loadGameData(ctx)
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return Pokemon{}, false
+	}
+	for _, m := range data.Monsters {
+		if m.HasName(name) {
+			return m, true
 		}
 	}
-	return
+	if monsters := queryMonster(ctx, name); len(monsters) == 1 {
+		return monsters[0], true
+	}
+	return Pokemon{}, false
 }
 
+// 和 querySkill 一樣可以用各語言的名稱查詢，名稱完全相同時只回傳那一個
 func queryMonster(ctx context.Context, monsterName string) []Pokemon {
-	if len(monsterMap) == 0 {
-		loadMonsterData(ctx)
-	}
+	data := loadGameData(ctx)
 
 	foundMonsters := make([]Pokemon, 0)
-	for _, m := range monsterMap {
-		if strings.Contains(strings.ToLower(m.Name), strings.ToLower(monsterName)) {
+	monsterName = strings.TrimSpace(monsterName)
+	if monsterName == "" {
+		return foundMonsters
+	}
+
+	for _, m := range data.Monsters {
+		if m.HasName(monsterName) {
+			return append(foundMonsters, m)
+		}
+	}
+	for _, m := range data.Monsters {
+		if m.NameContains(monsterName) {
 			foundMonsters = append(foundMonsters, m)
 		}
 	}
+	sort.Sort(monstersById(foundMonsters))
 	return foundMonsters
 }
 
-func formatSkills(skills []PokemonSkill) string {
+// 超過 n 個字時截斷並加上刪節號
+func truncateText(text string, n int) string {
+	runes := []rune(text)
+	if len(runes) <= n {
+		return text
+	}
+	return string(runes[:n-1]) + "…"
+}
+
+func formatSkills(lang string, skills []PokemonSkill) string {
 	if numSkill := len(skills); numSkill == 0 {
-		return "什麼也沒找到"
+		return tr(lang, "nothing_found")
 	} else if numSkill == 1 {
 		s := skills[0]
-		return fmt.Sprintf("%s (%s)\nDPS: %.2f", s.Name, s.Cname, s.Dps)
+		return fmt.Sprintf("%s\nDPS: %.2f", s.DisplayName(lang), s.Dps)
 	} else {
 		buf := bytes.NewBuffer([]byte{})
-		buf.WriteString("找到的技能如下:\n")
+		fmt.Fprintf(buf, "%s\n", tr(lang, "skills_found"))
 		for _, s := range skills {
-			fmt.Fprintf(buf, "*) %s (%s)\n-> DPS: %.2f\n", s.Name, s.Cname, s.Dps)
+			fmt.Fprintf(buf, "*) %s\n-> DPS: %.2f\n", s.DisplayName(lang), s.Dps)
 		}
 		return buf.String()
 	}
 }
 
-func formatMonsters(monsters []Pokemon) string {
+func formatMonsterTypes(lang string, m Pokemon) string {
+	if m.TypeII != "" {
+		return fmt.Sprintf("%s / %s", typeName(lang, m.TypeI), typeName(lang, m.TypeII))
+	}
+	return typeName(lang, m.TypeI)
+}
+
+func formatMonsters(lang string, monsters []Pokemon) string {
 	if numSkill := len(monsters); numSkill == 0 {
-		return "什麼也沒找到"
+		return tr(lang, "nothing_found")
 	} else if numSkill == 1 {
 		s := monsters[0]
-		typeII := ""
-		if s.TypeII != "" {
-			typeII = fmt.Sprintf("/%s", s.TypeII)
-		}
-		return fmt.Sprintf("%s (%s)\nType: %s%s", s.Name, s.Cname, s.TypeI, typeII)
+		return fmt.Sprintf("%s\n%s: %s", s.DisplayName(lang), tr(lang, "type_label"), formatMonsterTypes(lang, s))
 	} else {
 		buf := bytes.NewBuffer([]byte{})
-		buf.WriteString("找到的寵物如下:\n")
+		fmt.Fprintf(buf, "%s\n", tr(lang, "monsters_found"))
 		for _, s := range monsters {
-			typeII := ""
-			if s.TypeII != "" {
-				typeII = fmt.Sprintf("/%s", s.TypeII)
-			}
-			fmt.Fprintf(buf, "*) %s (%s)\nType: %s%s\n", s.Name, s.Cname, s.TypeI, typeII)
+			fmt.Fprintf(buf, "*) %s\n%s: %s\n", s.DisplayName(lang), tr(lang, "type_label"), formatMonsterTypes(lang, s))
 		}
 		return buf.String()
 	}
 }
 
-func getShortAddr(ctx context.Context, id string, latitude, longitude float64) (shortAddr string) {
-	tr := &urlfetch.Transport{Context: ctx}
-
-	if item, err := memcache.Get(ctx, id); err == memcache.ErrCacheMiss {
-		r, err := getAddress(tr.RoundTrip, latitude, longitude)
-		defer time.Sleep(500 * time.Millisecond)
-		if err != nil {
-			log.Errorf(ctx, err.Error())
-		}
-		log.Infof(ctx, "Address: %+v", r)
-		item := &memcache.Item{
-			Key:   id,
-			Value: []byte(fmt.Sprintf("%s%s,%s", r.Address.State, r.Address.Suburb, r.Address.Road)),
-		}
-		err = memcache.Add(ctx, item)
-		if err != nil {
-			log.Errorf(ctx, err.Error())
-		} else {
-			shortAddr = string(item.Value)
+func monsterItems(ctx context.Context, lang string, monsters []Pokemon) []map[string]interface{} {
+	items := []map[string]interface{}{}
+	for _, m := range monsters {
+		fastMoves, chargedMoves := monsterMoves(ctx, m)
+		subtitle := tr(lang, "monster_subtitle", formatMonsterTypes(lang, m), m.MaxCP, formatBestDps(fastMoves), formatBestDps(chargedMoves))
+		if n := countUnresolved(fastMoves, chargedMoves); n > 0 {
+			subtitle += "\n" + tr(lang, "moves_not_in_data", n)
 		}
-	} else if err != nil {
-		log.Errorf(ctx, "error getting item: %v", err)
-	} else {
-		shortAddr = string(item.Value)
+		items = append(items, map[string]interface{}{
+			"title":     m.DisplayName(lang),
+			"subtitle":  subtitle,
+			"image_url": fmt.Sprintf("http://pgwave.com/assets/images/pokemon/3d-h120/%d.png", m.Id),
+			"item_url":  fmt.Sprintf("http://pgwave.com/zh-hant/pokemon/%d", m.Id),
+			"buttons": []FBButtonItem{
+				FBButtonItem{
+					Type:    "postback",
+					Title:   tr(lang, "show_skills"),
+					Payload: fmt.Sprintf("QUERY_MONSTER_SKILL:%d", m.Id),
+				},
+			},
+		})
 	}
-	return
+	return items
 }
 
 func getDistances(lat1, long1, lat2, long2 float64) float64 {
@@ -520,17 +511,43 @@ func getDistances(lat1, long1, lat2, long2 float64) float64 {
 }
 
 func getPokemonNear(ctx context.Context, lat, long float64, distance int64) (monsters []PokemonPin, err error) {
-	if len(monsterMap) == 0 {
-		loadMonsterData(ctx)
+	game := loadGameData(ctx)
+
+	cell := geohash.EncodeWithPrecision(lat, long, radarGeohashPrecision)
+	cached, cacheErr := getWithNeighbors(ctx, geoCache, radarCacheKey, []string{cell})
+	if cacheErr != nil {
+		log.Errorf(ctx, "error getting radar cache: %v", cacheErr)
+	}
+	// 自己的格子沒有快取時，周圍格子的結果只要涵蓋整個查詢範圍也能用
+	for _, c := range append([]string{cell}, geohash.Neighbors(cell)...) {
+		b, ok := cached[c]
+		if !ok {
+			continue
+		}
+		center, radius := radarQueryArea(c)
+		if getDistances(center.Lat(), center.Lng(), lat, long)+float64(distance) > float64(radius) {
+			continue
+		}
+		cachedPins := []PokemonPin{}
+		if err := json.Unmarshal(b, &cachedPins); err == nil {
+			log.Debugf(ctx, "radar cache hit: %s for %s", c, cell)
+			return nearbyPins(cachedPins, lat, long, distance), nil
+		}
 	}
 
+	center, radius := radarQueryArea(cell)
 	tr := &urlfetch.Transport{Context: ctx}
-	data, err := goradar.GetPokemon(tr.RoundTrip, lat, long, distance)
+	clock := &skewRecorder{roundTrip: tr.RoundTrip}
+	data, err := goradar.GetPokemon(clock.RoundTrip, center.Lat(), center.Lng(), radius)
 	if err != nil {
 		log.Errorf(ctx, "%+v", err)
 		return
 	}
 
+	if clock.skew != 0 {
+		log.Infof(ctx, "radar clock skew: %s", clock.skew)
+	}
+
 	monsters = []PokemonPin{}
 	for _, pl := range data.Pokemons {
 		switch pl.PokemonId {
@@ -539,50 +556,86 @@ func getPokemonNear(ctx context.Context, lat, long float64, distance int64) (mon
 			136, 142, 143, 144, 145, 146, 147, 148, 149, 150, 151:
 			pp := PokemonPin{
 				Id:            pl.Id,
-				Pokemon:       monsterMap[pl.PokemonId],
-				DisappearTime: pl.DisappearTime,
+				Pokemon:       game.Monsters[pl.PokemonId],
+				DisappearTime: clock.toLocalMillis(pl.DisappearTime),
 				Distance:      pl.Distance,
 				Latitude:      pl.Location.Latitude,
 				Longitude:     pl.Location.Longitude,
-				Geohash:       geohash.EncodeWithPrecision(pl.Location.Latitude, pl.Location.Longitude, 6),
+				Geohash:       geohash.EncodeWithPrecision(pl.Location.Latitude, pl.Location.Longitude, pinGeohashPrecision),
 			}
 			monsters = append(monsters, pp)
 		}
 	}
+
+	if b, err := json.Marshal(monsters); err == nil {
+		if err := geoCache.Set(ctx, radarCacheKey(cell), b, radarCacheTTL); err != nil {
+			log.Errorf(ctx, err.Error())
+		}
+	}
+	return nearbyPins(monsters, lat, long, distance), nil
+}
+
+// 從格子中心查詢，範圍加上中心到角落的距離，格子裡任何位置的查詢都能用這份結果
+func radarQueryArea(cell string) (center *geohash.LatLng, radius int64) {
+	box := geohash.Decode(cell)
+	center, corner := box.Center(), box.NorthEast()
+	radius = radarMaxDistance + int64(math.Ceil(getDistances(center.Lat(), center.Lng(), corner.Lat(), corner.Lng())))
 	return
 }
 
+// 雷達結果是以格子中心查的，要以目前位置重新計算距離，並去掉已經消失的
+func nearbyPins(pins []PokemonPin, lat, long float64, distance int64) []PokemonPin {
+	now := time.Now().Unix() * 1000
+	monsters := []PokemonPin{}
+	for _, p := range pins {
+		if p.DisappearTime <= now {
+			continue
+		}
+		p.Distance = getDistances(lat, long, p.Latitude, p.Longitude)
+		if p.Distance > float64(distance) {
+			continue
+		}
+		monsters = append(monsters, p)
+	}
+	return monsters
+}
+
 func generateTemplateElements(ctx context.Context, items []map[string]interface{}) (elements []map[string]interface{}) {
 	elements = []map[string]interface{}{}
 
 	for _, item := range items {
+		title, _ := item["title"].(string)
+		subtitle, _ := item["subtitle"].(string)
+		// LINE 和 Discord 的卡片放得下完整內容，只有 Messenger 要截斷，招式的細節可以按按鈕查
 		element := map[string]interface{}{
-			"title":     item["title"],
+			"title":     truncateText(title, fbMaxTitle),
 			"image_url": item["image_url"],
 			"item_url":  item["item_url"],
-			"subtitle":  item["subtitle"],
 			"buttons":   item["buttons"],
 		}
+		if subtitle != "" {
+			element["subtitle"] = truncateText(subtitle, fbMaxSubtitle)
+		}
 		log.Debugf(ctx, "%+v", element)
 		elements = append(elements, element)
 	}
 	return
 }
 
-func getMonsterPinElements(ctx context.Context, monsterPins []PokemonPin) []map[string]interface{} {
+func getMonsterPinElements(ctx context.Context, monsterPins []PokemonPin, user *User) []map[string]interface{} {
+	loc := userLocation(user)
+	lang := userLanguage(user)
 	results := []map[string]interface{}{}
 	for _, m := range monsterPins {
 		monster := m.Pokemon
-		shortAddr := getShortAddr(ctx, m.Id, m.Latitude, m.Longitude)
 
 		disappearTime := time.Unix(m.DisappearTime/1000, 0).Round(time.Second)
-		loc, _ := time.LoadLocation("Asia/Taipei")
 		restTime := disappearTime.Sub(time.Now().Round(time.Second))
 		element := map[string]interface{}{
-			"title":     fmt.Sprintf("%s (%s)", monster.Cname, monster.Name),
+			"title":     monster.DisplayName(lang),
 			"image_url": fmt.Sprintf("http://pgwave.com/assets/images/pokemon/3d-h120/%d.png", m.Pokemon.Id),
 			"item_url":  fmt.Sprintf("http://maps.apple.com/maps?q=%f,%f&z=16", m.Latitude, m.Longitude),
-			"subtitle":  fmt.Sprintf("位置: %s\n直線距離 %0.2fkm\n消失時間 %s (剩餘 %s)", shortAddr, m.Distance, disappearTime.In(loc).Format("15:04:05"), restTime.String()),
+			"subtitle":  tr(lang, "pin_subtitle", m.ShortAddr, m.Distance, disappearTime.In(loc).Format("15:04:05"), formatCountdown(lang, restTime), formatTravel(lang, m, user.TravelMode)),
 			"buttons": []FBButtonItem{
 				FBButtonItem{
 					Type:  "web_url",
@@ -597,32 +650,28 @@ func getMonsterPinElements(ctx context.Context, monsterPins []PokemonPin) []map[
 	return results
 }
 
-func fbMonsterPinResponse(ctx context.Context, senderId int64, lat, long float64) (returnText string, err error) {
-	monsterPins, err := getPokemonNear(ctx, lat, long, 5)
+// 查附近的稀有怪，依可到達程度排序並補上地址，查不到時回傳要給使用者的訊息
+func monsterPinsNear(ctx context.Context, user *User, lat, long float64) (monsterPins []PokemonPin, returnText string) {
+	lang := userLanguage(user)
+	monsterPins, err := getPokemonNear(ctx, lat, long, radarMaxDistance)
 	if err != nil {
-		returnText = "查詢失敗"
-	} else {
-		if len(monsterPins) == 0 {
-			returnText = "附近沒有稀有怪"
-		} else {
-			log.Debugf(ctx, "%+v", monsterPins)
-			if len(monsterPins) > 10 {
-				monsterPins = monsterPins[0:10]
-			}
-
-			elements := getMonsterPinElements(ctx, monsterPins)
-			log.Debugf(ctx, "%+v", elements)
+		returnText = tr(lang, "query_failed")
+		return
+	}
+	if len(monsterPins) == 0 {
+		returnText = tr(lang, "no_rare_nearby")
+		return
+	}
 
-			b, err := json.Marshal(elements)
-			if err != nil {
-				returnText = "查詢失敗"
-			} else {
-				if err := fbSendGeneralTemplate(ctx, senderId, json.RawMessage(b)); err != nil {
-					returnText = "查詢失敗"
-				}
-			}
-		}
+	annotateTravel(monsterPins, user.TravelMode, time.Now())
+	sortByReachability(monsterPins)
+	log.Debugf(ctx, "%+v", monsterPins)
+	if len(monsterPins) > 10 {
+		monsterPins = monsterPins[0:10]
 	}
+
+	// 不等地址查詢，沒有快取的先顯示座標
+	scheduleGeocode(ctx, fillShortAddrs(ctx, geoCache, monsterPins))
 	return
 }
 
@@ -630,214 +679,179 @@ func fbCBPostHandler(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 	ctx := appengine.NewContext(r)
 
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 	var fbObject FBObject
-	d := json.NewDecoder(r.Body)
-	err := d.Decode(&fbObject)
+	if err := json.Unmarshal(body, &fbObject); err != nil {
+		// Facebook 收到非 2xx 會重送，一直失敗還會停用 webhook
+		log.Errorf(ctx, "unable to parse fb object: %s: %s", err, body)
+		fmt.Fprint(w, "")
+		return
+	}
+
+	// 先把訊息排進佇列就回應 Facebook，實際處理交給 worker
+	// 有任何訊息排不進去就回 500 讓 Facebook 重送，已經收過的訊息會被去重
+	failed := false
+	for _, fbMsg := range fbWebhookMessages(ctx, fbObject) {
+		if err := acceptEvent(ctx, fbEventKey(fbMsg), PLATFORM_FB, fbMsg.Sender.Id, fbMsg); err != nil {
+			log.Errorf(ctx, "enqueue fb message from %d: %s", fbMsg.Sender.Id, err)
+			failed = true
+		}
+	}
+	if failed {
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprint(w, "")
+}
 
+// FIND_MONSTER:緯度,經度，座標不合理時 ok 為 false
+func parseFindMonsterPayload(payload string) (lat, long float64, ok bool) {
+	items := strings.SplitN(payload, ":", 2)
+	if len(items) != 2 {
+		return
+	}
+	latlng := strings.Split(items[1], ",")
+	if len(latlng) != 2 {
+		return
+	}
+	lat, err := strconv.ParseFloat(latlng[0], 64)
 	if err != nil {
-		log.Errorf(ctx, "%s", err.Error())
-		http.Error(w, "unable to parse fb object from body", http.StatusInternalServerError)
+		return
 	}
+	long, err = strconv.ParseFloat(latlng[1], 64)
+	if err != nil {
+		return
+	}
+	// NaN 和任何數比較都是 false
+	ok = lat >= -90 && lat <= 90 && long >= -180 && long <= 180
+	return
+}
 
-	fbMessages := fbObject.Entry[0].Messaging
-	log.Debugf(ctx, "%+v", fbMessages)
+// 攤平每個 entry 的訊息並填上收到訊息的專頁，略過沒有 token 的專頁和專頁自己送出的 echo
+func fbWebhookMessages(ctx context.Context, fbObject FBObject) (messages []FBMessage) {
+	if fbObject.Object != "page" {
+		log.Warningf(ctx, "ignore fb object: %s", fbObject.Object)
+		return
+	}
+	for _, entry := range fbObject.Entry {
+		log.Debugf(ctx, "entry %s: %+v", entry.Id, entry.Messaging)
 
-	for _, fbMsg := range fbMessages {
-		senderId := fbMsg.Sender.Id
-		user, ok := users[senderId]
-		if !ok {
-			user = &User{
-				Id: senderId,
-			}
-			users[senderId] = user
+		pageId, err := strconv.ParseInt(entry.Id, 10, 64)
+		if err != nil {
+			log.Errorf(ctx, "invalid page id %q: %s", entry.Id, err)
+			continue
 		}
-		log.Debugf(ctx, "%+v", fbMsg)
-
-		var (
-			err        error
-			returnText string
-		)
-
-		if fbMsg.Content != nil {
-			// 如果收到 Location
-			attachments := fbMsg.Content.Attachments
-			if len(attachments) != 0 && attachments[0].Type == "location" {
-				payload := FBLocationAttachment{}
-				err = json.Unmarshal(attachments[0].Payload, &payload)
-				if err != nil {
-					log.Errorf(ctx, err.Error())
-					return
-				}
-				lat := payload.Coordinates.Latitude
-				long := payload.Coordinates.Longitude
-
-				if user.TodoAction == "FIND_MONSTER" {
-					returnText, err = fbMonsterPinResponse(ctx, senderId, lat, long)
-					if err != nil {
-						log.Errorf(ctx, "FB Pin response error: %s", err)
-					}
-				} else {
-					text := "找怪嗎?"
-					quickReplies := []map[string]string{
-						map[string]string{
-							"content_type": "text",
-							"title":        "是",
-							"payload":      fmt.Sprintf("FIND_MONSTER:%f,%f", lat, long),
-						},
-						map[string]string{
-							"content_type": "text",
-							"title":        "不是",
-							"payload":      "KIDDING",
-						},
-					}
-					err = fbSendTextMessage(ctx, senderId, text, quickReplies)
-				}
-			} else if fbMsg.Content.QuickReplay != nil {
-				payload := fbMsg.Content.QuickReplay.Payload
-				payloadItems := strings.Split(payload, ":")
-				if len(payloadItems) != 0 {
-					switch payloadItems[0] {
-					case "FIND_MONSTER":
-						user.TodoAction = "FIND_MONSTER"
-						latlng := strings.Split(payloadItems[1], ",")
-						if len(latlng) != 2 {
-							log.Errorf(ctx, "FIND_MONSTER postback arguments error: %+v", latlng)
-							returnText = "查詢錯誤"
-						} else {
-							lat, err := strconv.ParseFloat(latlng[0], 64)
-							if err != nil {
-								return
-							}
-							lng, err := strconv.ParseFloat(latlng[1], 64)
-							if err != nil {
-								return
-							}
-							returnText, err = fbMonsterPinResponse(ctx, senderId, lat, lng)
-							log.Debugf(ctx, "return text: %s", returnText)
-						}
-					case "KIDDING":
-						err = fbSendTextMessage(ctx, senderId, "你在呼嚨我嗎？", nil)
-					}
-				}
-			} else {
-				text := fbMsg.Content.Text
-				q := strings.ToLower(text)
-				switch q {
-				case "get started":
-					fallthrough
-				case "hi", "hello", "你好", "您好":
-					user.TodoAction = ""
-					returnText = WELCOME_TEXT
-				case "查技", "查技能", "技能", "skill":
-					user.TodoAction = "QUERY_SKILL"
-					returnText = "想要找什麼技能？(請輸入技能「英文」關鍵字)"
-				case "查寵", "查寵物", "寵物", "pokemon", "mon":
-					user.TodoAction = "QUERY_MONSTER"
-					returnText = "想要找什麼寵物？(請輸入寵物「英文」關鍵字)"
-				case "搜怪", "找怪", "找稀有怪":
-					user.TodoAction = "FIND_MONSTER"
-					returnText = "你在哪？？把你的現在位置傳 (Pin📍) 給我吧！"
-				default:
-					switch user.TodoAction {
-					case "QUERY_MONSTER":
-						monsters := queryMonster(ctx, text)
-						if l := len(monsters); l == 0 {
-							returnText = "沒有找到任何寵物"
-						} else if l > 6 {
-							returnText = "範圍太大，多打些字吧"
-						} else {
-							monstersItems := []map[string]interface{}{}
-							for _, m := range monsters {
-								typeII := ""
-								if m.TypeII != "" {
-									typeII = fmt.Sprintf(" / %s", m.TypeII)
-								}
-								monstersItems = append(monstersItems, map[string]interface{}{
-									"title": fmt.Sprintf("%s (%s)", m.Cname, m.Name),
-									"subtitle": fmt.Sprintf("屬性: %s%s\n最大CP: %d\n速技: %s\n充能技: %s",
-										m.TypeI, typeII, m.MaxCP,
-										strings.Join(m.FastMoves, ", "), strings.Join(m.ChargedMoves, ", ")),
-									"image_url": fmt.Sprintf("http://pgwave.com/assets/images/pokemon/3d-h120/%d.png", m.Id),
-									"item_url":  fmt.Sprintf("http://pgwave.com/zh-hant/pokemon/%s", m.Id),
-									"buttons": []FBButtonItem{
-										FBButtonItem{
-											Type:    "postback",
-											Title:   "顯示技能資訊",
-											Payload: fmt.Sprintf("QUERY_MONSTER_SKILL:%d", m.Id),
-										},
-									},
-								})
-							}
-							elements := generateTemplateElements(ctx, monstersItems)
-							b, err := json.Marshal(elements)
-							if err != nil {
-								returnText = "查詢失敗"
-							} else {
-								if err := fbSendGeneralTemplate(ctx, senderId, json.RawMessage(b)); err != nil {
-									returnText = "查詢失敗"
-								}
-							}
-						}
-					case "QUERY_SKILL":
-						skills := querySkill(ctx, []string{text})
-						if len(skills) > 6 {
-							returnText = "範圍太大，多打些字吧"
-						} else {
-							returnText = formatSkills(skills)
-						}
-					default:
-						user.TodoAction = ""
-						returnText = "我不懂你的意思。"
-					}
-				}
-				if err != nil {
-					log.Errorf(ctx, "%s", err.Error())
-					http.Error(w, "fail to deliver a message to a client", http.StatusInternalServerError)
-				}
-				user.LastText = text
+		if _, ok := pageToken(pageId); !ok {
+			log.Errorf(ctx, "no access token for page %d", pageId)
+			continue
+		}
+
+		for _, fbMsg := range entry.Messaging {
+			if fbMsg.Content != nil && fbMsg.Content.IsEcho {
+				continue
 			}
-		} else if fbMsg.Delivery != nil {
-		} else if fbMsg.Postback != nil {
-			payload := fbMsg.Postback.Payload
-			payloadItems := strings.Split(payload, ":")
-			if len(payloadItems) != 0 {
-				action := payloadItems[0]
-				switch action {
-				case "QUERY_MONSTER":
-					returnText = "想要找什麼寵物？(請輸入寵物「英文」關鍵字)"
-					user.TodoAction = fbMsg.Postback.Payload
-				case "QUERY_MONSTER_SKILL":
-					if len(payloadItems) == 2 {
-						mId, err := strconv.ParseInt(payloadItems[1], 10, 64)
-						if err == nil {
-							monster := monsterMap[mId]
-							err = fbSendTextMessage(ctx, senderId, fmt.Sprintf("查詢「%s」技能", monster.Cname), nil)
-							skills := querySkill(ctx, append(monster.FastMoves, monster.ChargedMoves...))
-							returnText = formatSkills(skills)
-						} else {
-							log.Errorf(ctx, "Can not parse int: %s. Error: %s", payloadItems[1], err.Error())
-							returnText = "查詢過程發生錯誤"
-						}
-					}
-				case "QUERY_SKILL":
-					returnText = "想要找什麼技能？(請輸入技能「英文」關鍵字)"
-					user.TodoAction = fbMsg.Postback.Payload
-				case "FIND_MONSTER":
-					returnText = "你在哪？？把你的現在位置傳 (Pin📍) 給我吧！"
-					user.TodoAction = fbMsg.Postback.Payload
-				case "GET_STARTED":
-					err = fbSendTextMessage(ctx, senderId, WELCOME_TEXT, nil)
-					fallthrough
-				default:
-					user.TodoAction = ""
-				}
+			fbMsg.Recipient.Id = pageId
+			messages = append(messages, fbMsg)
+		}
+	}
+	return
+}
+
+func handleFBMessage(ctx context.Context, fbMsg FBMessage) error {
+	return users.Update(ctx, fbMsg.Sender.Id, func(user *User) error {
+		return handleFBUserMessage(ctx, user, fbMsg)
+	})
+}
+
+func handleFBUserMessage(ctx context.Context, user *User, fbMsg FBMessage) (err error) {
+	senderId := fbMsg.Sender.Id
+	if fbMsg.Delivery == nil {
+		ensureUserProfile(ctx, user)
+	}
+	log.Debugf(ctx, "%+v", fbMsg)
+
+	router := commandRouter{
+		busy: func() { fbSendAction(ctx, senderId, "typing_on") },
+	}
+	var reply Reply
+
+	if fbMsg.Content != nil {
+		fbSendAction(ctx, senderId, "mark_seen")
+
+		// 如果收到 Location
+		attachments := fbMsg.Content.Attachments
+		if len(attachments) != 0 && attachments[0].Type == "location" {
+			payload := FBLocationAttachment{}
+			err = json.Unmarshal(attachments[0].Payload, &payload)
+			if err != nil {
+				return err
 			}
+			reply = router.location(ctx, user, payload.Coordinates.Latitude, payload.Coordinates.Longitude)
+		} else if fbMsg.Content.QuickReplay != nil {
+			reply = router.payload(ctx, user, fbMsg.Content.QuickReplay.Payload)
+		} else {
+			reply = router.text(ctx, user, fbMsg.Content.Text)
 		}
-		if returnText != "" {
-			err = fbSendTextMessage(ctx, senderId, returnText, nil)
+	} else if fbMsg.Delivery != nil {
+		recordFBDelivery(ctx, user, fbMsg.Delivery)
+	} else if fbMsg.Postback != nil {
+		reply = router.payload(ctx, user, fbMsg.Postback.Payload)
+	}
+
+	err = fbSendReply(ctx, user, reply)
+	if isSendError(err, SendErrorUserBlocked) {
+		log.Infof(ctx, "user %d is not available: %s", senderId, err)
+		err = nil
+	}
+	return
+}
+
+func fbQuickReplies(reply Reply) (quickReplies []map[string]string) {
+	if reply.AskLocation {
+		// 請使用者用 Messenger 內建的按鈕傳送位置
+		quickReplies = append(quickReplies, map[string]string{"content_type": "location"})
+	}
+	for _, c := range reply.Choices {
+		quickReplies = append(quickReplies, map[string]string{
+			"content_type": "text",
+			"title":        c.Title,
+			"payload":      c.Payload,
+		})
+	}
+	return
+}
+
+// 依序送出文字和卡片，卡片送不出去時改回覆查詢失敗
+func fbSendReply(ctx context.Context, user *User, reply Reply) (err error) {
+	for i, text := range reply.Texts {
+		var quickReplies []map[string]string
+		if i == len(reply.Texts)-1 {
+			quickReplies = fbQuickReplies(reply)
+		}
+		if err = fbSendTextMessage(ctx, user.Id, text, quickReplies); err != nil {
+			return
 		}
 	}
-	fmt.Fprint(w, "")
+	if len(reply.Cards) == 0 {
+		return
+	}
+
+	b, err := json.Marshal(generateTemplateElements(ctx, reply.Cards))
+	if err == nil {
+		err = fbSendGeneralTemplate(ctx, user.Id, json.RawMessage(b))
+	}
+	if e, ok := err.(*SendError); ok && e.Temporary() {
+		return
+	}
+	if err != nil {
+		log.Errorf(ctx, "send template: %s", err)
+		err = fbSendTextMessage(ctx, user.Id, tr(userLanguage(user), "query_failed"), nil)
+	}
+	return
 }
 
 func fbCBHandler(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,220 @@
+package pokedict
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func loadFBFixture(t *testing.T, name string) (fbObject FBObject) {
+	b, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(b, &fbObject); err != nil {
+		t.Fatalf("%s: %s", name, err)
+	}
+	return
+}
+
+type fbWebhookEvent struct {
+	page   int64
+	sender int64
+	key    string
+}
+
+func TestFBWebhookMessages(t *testing.T) {
+	defer func(tokens map[int64]string) { pageTokens = tokens }(pageTokens)
+	pageTokens = map[int64]string{1001: "token-1001", 1002: "token-1002"}
+
+	pages := []fbWebhookEvent{
+		{1001, 2001, "fb:mid:mid.1473204787206:aaaa"},
+		{1002, 2001, "fb:mid:mid.1473204787210:bbbb"},
+	}
+	// 沒有設定 token 的專頁只有在有預設 token 時才會處理
+	if PAGE_TOKEN != "" {
+		pages = append(pages, fbWebhookEvent{1003, 2003, "fb:mid:mid.1473204787220:cccc"})
+	}
+
+	tests := []struct {
+		fixture string
+		events  []fbWebhookEvent
+	}{
+		{"fb_batch.json", []fbWebhookEvent{
+			{1001, 2001, "fb:mid:mid.1473204787206:41d102a3e1ae206a38"},
+			{1001, 2002, "fb:mid:mid.1473204787300:0b0b1e4f4c3a9f2b11"},
+			{1001, 2001, "fb:postback:2001:1473204788000"},
+		}},
+		{"fb_pages.json", pages},
+		// echo 是專頁自己送出的訊息，不能回覆
+		{"fb_echo_delivery.json", []fbWebhookEvent{
+			{1001, 2001, "fb:delivery:2001:1458668856253"},
+			{1001, 2001, "fb:mid:mid.1458668856500:ffff"},
+		}},
+		{"fb_not_page.json", nil},
+	}
+
+	for _, test := range tests {
+		messages := fbWebhookMessages(testCtx, loadFBFixture(t, test.fixture))
+		if len(messages) != len(test.events) {
+			t.Errorf("%s: got %d messages, want %d", test.fixture, len(messages), len(test.events))
+			continue
+		}
+		for i, fbMsg := range messages {
+			got := fbWebhookEvent{fbMsg.Recipient.Id, fbMsg.Sender.Id, fbEventKey(fbMsg)}
+			if got != test.events[i] {
+				t.Errorf("%s #%d: got %+v, want %+v", test.fixture, i, got, test.events[i])
+			}
+		}
+	}
+}
+
+func TestFBWebhookPayloads(t *testing.T) {
+	fbObject := loadFBFixture(t, "fb_echo_delivery.json")
+	messaging := fbObject.Entry[0].Messaging
+
+	if !messaging[0].Content.IsEcho {
+		t.Error("echo message is not marked as echo")
+	}
+	if d := messaging[1].Delivery; d == nil || d.Watermark != 1458668856253 {
+		t.Errorf("unexpected delivery: %+v", d)
+	}
+
+	var location FBLocationAttachment
+	attachment := messaging[2].Content.Attachments[0]
+	if err := json.Unmarshal(attachment.Payload, &location); err != nil {
+		t.Fatal(err)
+	}
+	if attachment.Type != "location" || location.Coordinates.Latitude != 25.033964 || location.Coordinates.Longitude != 121.564468 {
+		t.Errorf("unexpected location: %+v", location)
+	}
+
+	fbObject = loadFBFixture(t, "fb_batch.json")
+	quickReply := fbObject.Entry[0].Messaging[1].Content.QuickReplay
+	if quickReply == nil || quickReply.Payload != "FIND_MONSTER:25.033964,121.564468" {
+		t.Errorf("unexpected quick reply: %+v", quickReply)
+	}
+}
+
+func TestParseFindMonsterPayload(t *testing.T) {
+	tests := []struct {
+		payload   string
+		lat, long float64
+		ok        bool
+	}{
+		{"FIND_MONSTER:25.033964,121.564468", 25.033964, 121.564468, true},
+		{"FIND_MONSTER:-33.8688,151.2093", -33.8688, 151.2093, true},
+		{"FIND_MONSTER", 0, 0, false},
+		{"FIND_MONSTER:", 0, 0, false},
+		{"FIND_MONSTER:25.03", 0, 0, false},
+		{"FIND_MONSTER:abc,121.5", 0, 0, false},
+		{"FIND_MONSTER:25.03,121.5,1", 0, 0, false},
+		{"FIND_MONSTER:NaN,121.5", 0, 0, false},
+		{"FIND_MONSTER:95,121.5", 0, 0, false},
+	}
+	for _, test := range tests {
+		lat, long, ok := parseFindMonsterPayload(test.payload)
+		if ok != test.ok || ok && (lat != test.lat || long != test.long) {
+			t.Errorf("%q: got (%v, %v, %v), want (%v, %v, %v)", test.payload, lat, long, ok, test.lat, test.long, test.ok)
+		}
+	}
+}
+
+func TestQuerySkill(t *testing.T) {
+	tests := []struct {
+		keyword string
+		ids     []int64
+	}{
+		// 名稱完全相同時不會帶出名稱包含它的技能
+		{"Bite", []int64{34}},
+		{"bite", []int64{34}},
+		{"Bug Bite", []int64{26}},
+		{"bit", []int64{26, 34}},
+		{"Water Gun", []int64{12}},
+		{" water gun ", []int64{12}},
+		{"Water Pulse", []int64{1019}},
+		{"water", []int64{12, 1019}},
+		{"水槍", []int64{12}},
+		{"水之波动", []int64{1019}},
+		{"みずでっぽう", []int64{12}},
+		{"水", []int64{12, 13, 1016, 1017, 1018, 1019, 1021, 1022}},
+		{"咬", []int64{26, 34}},
+		{"", []int64{}},
+		{"no such skill", []int64{}},
+	}
+	for _, test := range tests {
+		skills := querySkill(testCtx, test.keyword)
+		ids := []int64{}
+		for _, s := range skills {
+			ids = append(ids, s.Id)
+		}
+		if fmt.Sprint(ids) != fmt.Sprint(test.ids) {
+			t.Errorf("%q: got %v, want %v", test.keyword, ids, test.ids)
+		}
+	}
+}
+
+func TestQueryMonster(t *testing.T) {
+	tests := []struct {
+		keyword string
+		ids     []int64
+	}{
+		{"Mew", []int64{151}},
+		{"mew", []int64{151}},
+		{"mewt", []int64{150}},
+		{" Bulbasaur ", []int64{1}},
+		{"妙蛙", []int64{1, 2, 3}},
+		{"妙蛙種子", []int64{1}},
+		{"妙蛙种子", []int64{1}},
+		{"夢", []int64{150, 151}},
+		{"ミュウ", []int64{151}},
+		{"ミュウツ", []int64{150}},
+		{"", []int64{}},
+		{"no such monster", []int64{}},
+	}
+	for _, test := range tests {
+		ids := []int64{}
+		for _, m := range queryMonster(testCtx, test.keyword) {
+			ids = append(ids, m.Id)
+		}
+		if fmt.Sprint(ids) != fmt.Sprint(test.ids) {
+			t.Errorf("%q: got %v, want %v", test.keyword, ids, test.ids)
+		}
+	}
+}
+
+// 每張寵物卡片不截斷也要放得進 Messenger 的上限，招式的細節在技能明細裡
+func TestMonsterCardLength(t *testing.T) {
+	game := loadGameData(testCtx)
+	monsters := []Pokemon{}
+	for _, m := range game.Monsters {
+		monsters = append(monsters, m)
+	}
+	for _, lang := range languages {
+		for _, item := range monsterItems(testCtx, lang, monsters) {
+			if n := len([]rune(item["title"].(string))); n > fbMaxTitle {
+				t.Errorf("%s: title %q has %d characters", lang, item["title"], n)
+			}
+			if n := len([]rune(item["subtitle"].(string))); n > fbMaxSubtitle {
+				t.Errorf("%s: subtitle %q has %d characters", lang, item["subtitle"], n)
+			}
+		}
+	}
+
+	// 查不到的招式要在卡片上標出來，明細要有屬性、本系加成、DPS 和能量
+	m := Pokemon{Id: 9999, Name: "Test", TypeI: "Water", FastMoves: []string{"Water Gun", "No Such Move"}, ChargedMoves: []string{"Hydro Pump"}}
+	subtitle := monsterItems(testCtx, LANG_EN, []Pokemon{m})[0]["subtitle"].(string)
+	if want := "Water · MaxCP 0\nDPS fast 12.0★ · charged 23.7★\n1 move(s) not in data"; subtitle != want {
+		t.Errorf("got subtitle %q, want %q", subtitle, want)
+	}
+	fast, charged := monsterMoves(testCtx, m)
+	detail := formatMonsterSkills(LANG_EN, m, fast, charged)
+	for _, s := range []string{"Water Gun", "★STAB", "DPS: 12.00, Energy: 7", "No Such Move (not in data)", "DPS: 23.68, Energy: 90"} {
+		if !strings.Contains(detail, s) {
+			t.Errorf("skill detail %q doesn't contain %q", detail, s)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package pokedict
+
+import "math"
+
+type Stats struct {
+	Attack  float64
+	Defense float64
+	Stamina float64
+}
+
+// 等級 1 ~ 40 的 CP 乘數
+var cpMultipliers []float64 = []float64{
+	0.094, 0.16639787, 0.21573247, 0.25572005, 0.29024988,
+	0.3210876, 0.34921268, 0.37523559, 0.39956728, 0.42250001,
+	0.44310755, 0.46279839, 0.48168495, 0.49985844, 0.51739395,
+	0.53435433, 0.55079269, 0.56675452, 0.58227891, 0.59740001,
+	0.61215729, 0.62656713, 0.64065295, 0.65443563, 0.667934,
+	0.68116492, 0.69414365, 0.70688421, 0.71939909, 0.7317,
+	0.73776948, 0.74378943, 0.74976104, 0.75568551, 0.76156384,
+	0.76739717, 0.7731865, 0.77893275, 0.78463697, 0.79030001,
+}
+
+const (
+	MIN_LEVEL = 1
+	MAX_LEVEL = 40
+	MAX_IV    = 15
+)
+
+func cpMultiplier(level float64) float64 {
+	if math.IsNaN(level) || level < MIN_LEVEL {
+		level = MIN_LEVEL
+	} else if level > MAX_LEVEL {
+		level = MAX_LEVEL
+	}
+	lower := math.Floor(level)
+	cpm := cpMultipliers[int(lower)-1]
+	if level == lower {
+		return cpm
+	}
+	// 半級的乘數是前後兩級平方的平均再開根號
+	next := cpMultipliers[int(lower)]
+	return math.Sqrt((cpm*cpm + next*next) / 2)
+}
+
+// 資料檔沒有三圍時，假設三項能力相同，由滿級 CP 反推
+func baseStats(p Pokemon) Stats {
+	if p.BaseAttack != 0 && p.BaseDefense != 0 && p.BaseStamina != 0 {
+		return Stats{float64(p.BaseAttack), float64(p.BaseDefense), float64(p.BaseStamina)}
+	}
+	s := math.Sqrt(10*float64(p.MaxCP))/cpMultiplier(MAX_LEVEL) - MAX_IV
+	if s < 1 {
+		s = 1
+	}
+	return Stats{s, s, s}
+}
+
+func pokemonStats(p Pokemon, level float64, iv int64) Stats {
+	if iv < 0 {
+		iv = 0
+	} else if iv > MAX_IV {
+		iv = MAX_IV
+	}
+	b := baseStats(p)
+	cpm := cpMultiplier(level)
+	return Stats{
+		Attack:  (b.Attack + float64(iv)) * cpm,
+		Defense: (b.Defense + float64(iv)) * cpm,
+		Stamina: math.Floor((b.Stamina + float64(iv)) * cpm),
+	}
+}
+
+func damage(power, attack, defense, multiplier float64) float64 {
+	return math.Floor(0.5*power*attack/defense*multiplier) + 1
+}
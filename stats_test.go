@@ -0,0 +1,17 @@
+package pokedict
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCPMultiplierClamp(t *testing.T) {
+	for _, level := range []float64{math.NaN(), math.Inf(-1), -3, 0} {
+		if cpm := cpMultiplier(level); cpm != cpMultipliers[0] {
+			t.Errorf("level %v: got %v, want %v", level, cpm, cpMultipliers[0])
+		}
+	}
+	if cpm := cpMultiplier(math.Inf(1)); cpm != cpMultipliers[MAX_LEVEL-1] {
+		t.Errorf("level +Inf: got %v, want %v", cpm, cpMultipliers[MAX_LEVEL-1])
+	}
+}
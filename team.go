@@ -0,0 +1,284 @@
+package pokedict
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+const (
+	MAX_TEAM_SIZE   = 6
+	teamSuggestions = 3
+)
+
+type TeamReport struct {
+	Members []Pokemon
+	// 每個攻擊屬性會剋制 / 被抵抗的隊員數
+	WeakCount   map[string]int
+	ResistCount map[string]int
+	// 超過一隻隊員怕、而且怕的比抵抗的多
+	SharedWeaknesses []string
+	// 隊伍所有技能都無法造成效果絕佳的屬性
+	Uncovered []string
+}
+
+type TeamSuggestion struct {
+	// Replace 為空表示直接加入隊伍
+	Replace *Pokemon
+	With    Pokemon
+	Closes  []string
+	gain    int
+}
+
+func monsterByName(name string) (Pokemon, bool) {
+	for _, m := range currentGameData().Monsters {
+		if m.HasName(name) {
+			return m, true
+		}
+	}
+	return Pokemon{}, false
+}
+
+// 名稱可能有空白 (例如 Mr. Mime)，盡量取最長的一段符合的名稱
+func parseMonsterNames(args string) (monsters []Pokemon, unknown []string) {
+	fields := strings.Fields(strings.Replace(args, ",", " ", -1))
+	for i := 0; i < len(fields); {
+		matched := 0
+		for j := len(fields); j > i; j-- {
+			if m, ok := monsterByName(strings.Join(fields[i:j], " ")); ok {
+				monsters = append(monsters, m)
+				matched = j - i
+				break
+			}
+		}
+		if matched == 0 {
+			unknown = append(unknown, fields[i])
+			matched = 1
+		}
+		i += matched
+	}
+	return
+}
+
+func isWeakTo(m Pokemon, attackType string) bool {
+	for _, w := range m.Weaknesses {
+		if normalizeType(w) == attackType {
+			return true
+		}
+	}
+	return effectivenessLevel(attackType, pokemonTypes(m)...) > 0
+}
+
+func moveTypes(m Pokemon) []string {
+	types := []string{}
+	for _, name := range append(append([]string{}, m.FastMoves...), m.ChargedMoves...) {
+		if s, ok := skillByName(name); ok {
+			types = append(types, normalizeType(s.Type))
+		}
+	}
+	return types
+}
+
+func analyzeTeam(members []Pokemon) TeamReport {
+	report := TeamReport{
+		Members:     members,
+		WeakCount:   map[string]int{},
+		ResistCount: map[string]int{},
+	}
+
+	for _, t := range allTypes {
+		for _, m := range members {
+			if isWeakTo(m, t) {
+				report.WeakCount[t]++
+			} else if effectivenessLevel(t, pokemonTypes(m)...) < 0 {
+				report.ResistCount[t]++
+			}
+		}
+		if w := report.WeakCount[t]; w > 1 && w > report.ResistCount[t] {
+			report.SharedWeaknesses = append(report.SharedWeaknesses, t)
+		}
+	}
+
+	covered := map[string]bool{}
+	for _, m := range members {
+		for _, mt := range moveTypes(m) {
+			for _, t := range allTypes {
+				if effectivenessLevel(mt, t) > 0 {
+					covered[t] = true
+				}
+			}
+		}
+	}
+	for _, t := range allTypes {
+		if !covered[t] {
+			report.Uncovered = append(report.Uncovered, t)
+		}
+	}
+	return report
+}
+
+func teamGaps(r TeamReport) map[string]bool {
+	gaps := map[string]bool{}
+	for _, t := range r.SharedWeaknesses {
+		gaps["weak:"+t] = true
+	}
+	for _, t := range r.Uncovered {
+		gaps["cover:"+t] = true
+	}
+	return gaps
+}
+
+type suggestionsByGain []TeamSuggestion
+
+func (s suggestionsByGain) Len() int      { return len(s) }
+func (s suggestionsByGain) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s suggestionsByGain) Less(i, j int) bool {
+	if s[i].gain != s[j].gain {
+		return s[i].gain > s[j].gain
+	}
+	if s[i].With.MaxCP != s[j].With.MaxCP {
+		return s[i].With.MaxCP > s[j].With.MaxCP
+	}
+	return s[i].With.Id < s[j].With.Id
+}
+
+// 逐一嘗試把圖鑑裡的寵物加入 (或換掉一隻隊員)，找出補上最多缺口的組合
+func suggestSubstitutions(members []Pokemon, report TeamReport) []TeamSuggestion {
+	before := teamGaps(report)
+	if len(before) == 0 {
+		return nil
+	}
+
+	inTeam := map[int64]bool{}
+	for _, m := range members {
+		inTeam[m.Id] = true
+	}
+
+	slots := []int{-1}
+	if len(members) >= MAX_TEAM_SIZE {
+		slots = []int{}
+		for i := range members {
+			slots = append(slots, i)
+		}
+	}
+
+	best := map[int64]TeamSuggestion{}
+	for _, candidate := range currentGameData().Monsters {
+		if inTeam[candidate.Id] {
+			continue
+		}
+		for _, slot := range slots {
+			team := append([]Pokemon{}, members...)
+			if slot < 0 {
+				team = append(team, candidate)
+			} else {
+				team[slot] = candidate
+			}
+
+			after := teamGaps(analyzeTeam(team))
+			closes := []string{}
+			for gap := range before {
+				if !after[gap] {
+					closes = append(closes, gap)
+				}
+			}
+			gain := len(closes)
+			for gap := range after {
+				if !before[gap] {
+					gain--
+				}
+			}
+			if gain <= 0 {
+				continue
+			}
+
+			s := TeamSuggestion{With: candidate, Closes: closes, gain: gain}
+			if slot >= 0 {
+				replaced := members[slot]
+				s.Replace = &replaced
+			}
+			if prev, ok := best[candidate.Id]; !ok || suggestionsByGain([]TeamSuggestion{s, prev}).Less(0, 1) {
+				best[candidate.Id] = s
+			}
+		}
+	}
+
+	suggestions := []TeamSuggestion{}
+	for _, s := range best {
+		sort.Strings(s.Closes)
+		suggestions = append(suggestions, s)
+	}
+	sort.Sort(suggestionsByGain(suggestions))
+	if len(suggestions) > teamSuggestions {
+		suggestions = suggestions[:teamSuggestions]
+	}
+	return suggestions
+}
+
+func formatGap(lang, gap string) string {
+	if strings.HasPrefix(gap, "weak:") {
+		return tr(lang, "team_gap_weak", typeName(lang, strings.TrimPrefix(gap, "weak:")))
+	}
+	return tr(lang, "team_gap_cover", typeName(lang, strings.TrimPrefix(gap, "cover:")))
+}
+
+func formatTeam(lang string, report TeamReport, suggestions []TeamSuggestion) string {
+	buf := bytes.NewBuffer([]byte{})
+	names := []string{}
+	for _, m := range report.Members {
+		names = append(names, m.LocalName(lang))
+	}
+	fmt.Fprintf(buf, "%s\n", tr(lang, "team_members", strings.Join(names, ", ")))
+
+	if len(report.SharedWeaknesses) == 0 {
+		fmt.Fprintf(buf, "%s\n", tr(lang, "team_no_shared_weakness"))
+	} else {
+		weak := []string{}
+		for _, t := range report.SharedWeaknesses {
+			weak = append(weak, fmt.Sprintf("%s(%d)", typeName(lang, t), report.WeakCount[t]))
+		}
+		fmt.Fprintf(buf, "%s\n", tr(lang, "team_shared_weakness", strings.Join(weak, ", ")))
+	}
+
+	if len(report.Uncovered) == 0 {
+		fmt.Fprintf(buf, "%s\n", tr(lang, "team_full_coverage"))
+	} else {
+		uncovered := []string{}
+		for _, t := range report.Uncovered {
+			uncovered = append(uncovered, typeName(lang, t))
+		}
+		fmt.Fprintf(buf, "%s\n", tr(lang, "team_uncovered", strings.Join(uncovered, ", ")))
+	}
+
+	for _, s := range suggestions {
+		closes := []string{}
+		for _, gap := range s.Closes {
+			closes = append(closes, formatGap(lang, gap))
+		}
+		if s.Replace != nil {
+			fmt.Fprintf(buf, "%s\n", tr(lang, "team_replace", s.With.LocalName(lang), s.Replace.LocalName(lang), strings.Join(closes, ", ")))
+		} else {
+			fmt.Fprintf(buf, "%s\n", tr(lang, "team_add", s.With.LocalName(lang), strings.Join(closes, ", ")))
+		}
+	}
+	return buf.String()
+}
+
+func teamResponse(ctx context.Context, lang, args string) string {
+	loadGameData(ctx)
+
+	members, unknown := parseMonsterNames(args)
+	if len(unknown) != 0 {
+		return tr(lang, "team_unknown", strings.Join(unknown, ", "))
+	} else if len(members) == 0 {
+		return tr(lang, "team_usage")
+	} else if len(members) > MAX_TEAM_SIZE {
+		return tr(lang, "team_too_large", MAX_TEAM_SIZE)
+	}
+
+	report := analyzeTeam(members)
+	return formatTeam(lang, report, suggestSubstitutions(members, report))
+}
@@ -0,0 +1,229 @@
+package pokedict
+
+import (
+	"net/http"
+	"time"
+)
+
+const (
+	DEFAULT_TIMEZONE = "Asia/Taipei"
+
+	// HTTP Date header 只精確到秒，差距小於這個值就當作沒有誤差
+	minClockSkew = 2 * time.Second
+)
+
+// 邊界上的一點 (緯度, 經度)
+type latLong [2]float64
+
+type timezoneRegion struct {
+	Name string
+	// 依序連起來的多邊形頂點，只求大城市和邊境附近判斷正確，不是精確的國界
+	Polygon []latLong
+}
+
+func boxPolygon(minLat, maxLat, minLong, maxLong float64) []latLong {
+	return []latLong{{minLat, minLong}, {minLat, maxLong}, {maxLat, maxLong}, {maxLat, minLong}}
+}
+
+// 把幾段邊界依序接起來，相鄰的時區共用同一段邊界就不會重疊或留下空隙
+func joinBorders(borders ...[]latLong) (polygon []latLong) {
+	for _, b := range borders {
+		polygon = append(polygon, b...)
+	}
+	return
+}
+
+func reversed(border []latLong) []latLong {
+	r := make([]latLong, len(border))
+	for i, p := range border {
+		r[len(border)-1-i] = p
+	}
+	return r
+}
+
+// 中國和蒙古的邊界，由西到東
+var chinaMongoliaBorder = []latLong{
+	{49.15, 87.8}, {48.5, 88.6}, {47.9, 90.1}, {46.8, 91.0}, {45.3, 90.8}, {45.0, 91.5}, {44.4, 93.5},
+	{42.8, 96.3}, {42.6, 99.5}, {42.6, 101.8}, {41.6, 105.0}, {42.4, 107.5}, {42.5, 109.5}, {43.5, 111.7},
+	{44.1, 112.6}, {44.7, 114.0}, {45.4, 116.0}, {46.3, 117.4}, {46.6, 119.0}, {46.8, 119.9}, {47.7, 119.3},
+	{47.9, 118.5}, {48.0, 117.4}, {49.85, 116.7},
+}
+
+// 蒙古和俄羅斯的邊界，由東到西
+var mongoliaRussiaBorder = []latLong{
+	{49.95, 115.7}, {50.3, 114.0}, {49.6, 112.0}, {49.2, 110.3}, {49.4, 108.0}, {50.0, 107.0}, {50.3, 106.4},
+	{50.3, 104.0}, {50.6, 102.3}, {51.5, 101.5}, {51.7, 100.0}, {52.1, 98.9}, {51.4, 98.0}, {50.0, 97.3},
+	{49.95, 95.0}, {50.5, 94.0}, {50.8, 92.5}, {50.4, 91.0}, {49.6, 89.5}, {49.4, 88.2},
+}
+
+// 中國和北韓的邊界，由東到西
+var chinaKoreaBorder = []latLong{
+	{42.45, 130.6}, {42.95, 129.9}, {42.4, 129.0}, {42.0, 128.1}, {41.4, 126.9}, {40.9, 125.6}, {39.85, 124.3},
+}
+
+// 南北韓的軍事分界線，由東到西
+var koreaDMZ = []latLong{
+	{38.62, 128.36}, {38.3, 127.1}, {37.75, 126.1},
+}
+
+// 依序比對，範圍小的要放前面
+var timezoneRegions []timezoneRegion = []timezoneRegion{
+	{"Asia/Hong_Kong", boxPolygon(22.1, 22.6, 113.8, 114.5)},
+	{"Asia/Macau", boxPolygon(22.1, 22.22, 113.5, 113.63)},
+	// 台灣本島和澎湖，金門，馬祖
+	{"Asia/Taipei", []latLong{{21.8, 120.6}, {22.6, 119.2}, {23.9, 119.2}, {25.4, 121.0}, {25.4, 122.1}, {21.8, 122.0}}},
+	{"Asia/Taipei", boxPolygon(24.35, 24.55, 118.22, 118.5)},
+	{"Asia/Taipei", boxPolygon(25.9, 26.4, 119.88, 120.55)},
+	// 沿著對馬海峽和日本海把朝鮮半島排除在外
+	{"Asia/Tokyo", []latLong{
+		{24.0, 122.9}, {27.5, 122.9}, {30.5, 127.5}, {33.0, 128.5}, {34.0, 128.9}, {34.8, 129.2}, {35.5, 130.2},
+		{38.0, 133.0}, {42.0, 139.2}, {45.6, 141.0}, {45.6, 142.2}, {44.4, 145.4}, {43.2, 145.9}, {24.0, 146.0},
+	}},
+	{"Asia/Seoul", joinBorders(
+		[]latLong{{33.0, 124.5}, {37.75, 124.5}},
+		reversed(koreaDMZ),
+		[]latLong{{37.5, 131.0}, {35.0, 129.5}, {33.0, 127.5}},
+	)},
+	{"Asia/Pyongyang", joinBorders(
+		reversed(chinaKoreaBorder),
+		[]latLong{{41.0, 130.0}, {39.0, 128.5}},
+		koreaDMZ,
+		[]latLong{{37.6, 124.6}, {39.5, 123.9}},
+	)},
+	// 蒙古西部的省份用 UTC+7
+	{"Asia/Hovd", joinBorders(
+		chinaMongoliaBorder[:8],
+		[]latLong{{42.6, 99.0}, {52.05, 99.0}},
+		mongoliaRussiaBorder[11:],
+	)},
+	{"Asia/Ulaanbaatar", joinBorders(chinaMongoliaBorder, mongoliaRussiaBorder)},
+	{"Asia/Shanghai", joinBorders(
+		// 俄羅斯
+		[]latLong{
+			{53.5, 123.5}, {52.0, 126.4}, {50.0, 127.5}, {49.0, 130.5}, {47.9, 132.5}, {48.3, 134.7}, {47.5, 134.7},
+			{46.5, 134.0}, {45.3, 133.1}, {45.0, 131.9}, {44.4, 131.25}, {43.0, 131.2},
+		},
+		chinaKoreaBorder,
+		// 沿海，台灣、香港和澳門在前面已經比對過
+		[]latLong{
+			{39.0, 123.0}, {37.4, 123.5}, {31.0, 123.0}, {27.0, 121.0}, {25.0, 119.8}, {23.5, 117.8}, {22.4, 114.8},
+			{21.5, 112.0}, {20.2, 111.2}, {18.0, 110.0}, {18.0, 108.5}, {20.3, 108.4},
+		},
+		// 越南、寮國、緬甸
+		[]latLong{
+			{21.55, 108.0}, {22.0, 106.7}, {22.95, 106.6}, {23.4, 105.3}, {22.8, 104.2}, {22.5, 104.0}, {22.7, 103.0},
+			{22.4, 102.15}, {21.7, 101.8}, {21.2, 101.7}, {21.15, 101.2}, {21.55, 100.4}, {21.8, 100.05}, {22.15, 99.2},
+			{23.1, 98.9}, {23.9, 97.6}, {24.7, 97.55}, {25.5, 98.3}, {26.5, 98.7}, {27.6, 98.5}, {28.4, 98.1},
+		},
+		// 印度、不丹、尼泊爾
+		[]latLong{
+			{28.6, 97.4}, {29.3, 96.1}, {29.4, 95.4}, {28.9, 94.3}, {28.2, 93.3}, {27.9, 92.0}, {27.8, 91.6},
+			{28.1, 90.5}, {28.0, 89.5}, {27.3, 88.9}, {27.9, 88.1}, {28.0, 86.9}, {28.2, 85.6}, {28.9, 84.2},
+			{29.3, 83.6}, {30.1, 82.0}, {30.45, 81.3}, {31.0, 79.4}, {31.5, 78.8}, {32.5, 78.4}, {33.0, 79.3},
+			{34.0, 78.9}, {35.0, 78.2}, {35.6, 77.8},
+		},
+		// 巴基斯坦、塔吉克、吉爾吉斯、哈薩克
+		[]latLong{
+			{36.0, 76.8}, {36.9, 75.4}, {37.05, 74.6}, {38.6, 73.8}, {39.4, 73.7}, {40.0, 73.6}, {40.5, 75.7},
+			{41.0, 77.5}, {42.1, 80.2}, {42.8, 80.2}, {44.2, 80.3}, {45.2, 82.5}, {46.5, 82.8}, {47.2, 83.0},
+			{47.4, 85.6}, {48.4, 85.8}, {49.1, 87.3},
+		},
+		chinaMongoliaBorder,
+		// 俄羅斯
+		[]latLong{{49.75, 117.5}, {50.3, 119.2}, {51.3, 120.1}, {52.6, 120.5}, {53.3, 121.2}},
+	)},
+}
+
+// 從這一點往東的射線穿過邊界奇數次就在多邊形裡面
+func (r timezoneRegion) contains(lat, long float64) bool {
+	inside := false
+	for i, j := 0, len(r.Polygon)-1; i < len(r.Polygon); j, i = i, i+1 {
+		a, b := r.Polygon[i], r.Polygon[j]
+		if (a[0] > lat) != (b[0] > lat) && long < (b[1]-a[1])*(lat-a[0])/(b[0]-a[0])+a[1] {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// 由座標推算時區，不在已知區域內時回傳 false。經度換算的時差在俄羅斯、南亞等地常常是錯的，不如不猜
+func timezoneForLocation(lat, long float64) (name string, ok bool) {
+	for _, r := range timezoneRegions {
+		if r.contains(lat, long) {
+			return r.Name, true
+		}
+	}
+	return "", false
+}
+
+func userLocation(user *User) *time.Location {
+	if user != nil && user.Timezone != "" {
+		if loc, err := time.LoadLocation(user.Timezone); err == nil {
+			return loc
+		}
+	}
+	if loc, err := time.LoadLocation(DEFAULT_TIMEZONE); err == nil {
+		return loc
+	}
+	return time.FixedZone("UTC+8", 8*60*60)
+}
+
+// 使用者傳位置過來時更新時區，除非使用者自己設定過。推算不出來時沿用原本 (個人資料或預設) 的時區
+func updateUserTimezone(user *User, lat, long float64) {
+	if user.TimezoneFixed {
+		return
+	}
+	if name, ok := timezoneForLocation(lat, long); ok {
+		user.Timezone = name
+	}
+}
+
+func setUserTimezone(user *User, name string) error {
+	if _, err := time.LoadLocation(name); err != nil {
+		return err
+	}
+	user.Timezone = name
+	user.TimezoneFixed = true
+	return nil
+}
+
+func formatCountdown(lang string, d time.Duration) string {
+	if d <= 0 {
+		return tr(lang, "countdown_gone")
+	}
+	total := int(d.Seconds())
+	hours, minutes, seconds := total/3600, total%3600/60, total%60
+	if hours > 0 {
+		return tr(lang, "countdown_hours", hours, minutes)
+	} else if minutes > 0 {
+		return tr(lang, "countdown_minutes", minutes, seconds)
+	}
+	return tr(lang, "countdown_seconds", seconds)
+}
+
+// 記錄雷達伺服器回應的 Date header，用來估計它和我們的時鐘差距
+type skewRecorder struct {
+	roundTrip func(*http.Request) (*http.Response, error)
+	skew      time.Duration
+}
+
+func (r *skewRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	sent := time.Now()
+	resp, err := r.roundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	if date, err := http.ParseTime(resp.Header.Get("Date")); err == nil {
+		received := time.Now()
+		local := sent.Add(received.Sub(sent) / 2)
+		if skew := date.Sub(local); skew > minClockSkew || skew < -minClockSkew {
+			r.skew = skew
+		}
+	}
+	return resp, err
+}
+
+// 把雷達時鐘上的時間 (ms) 換成本機時鐘
+func (r *skewRecorder) toLocalMillis(t int64) int64 {
+	return t - int64(r.skew/time.Millisecond)
+}
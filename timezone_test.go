@@ -0,0 +1,115 @@
+package pokedict
+
+import "testing"
+
+func TestTimezoneForLocation(t *testing.T) {
+	tests := []struct {
+		place     string
+		lat, long float64
+		want      string
+	}{
+		{"台北", 25.03, 121.56, "Asia/Taipei"},
+		{"高雄", 22.63, 120.30, "Asia/Taipei"},
+		{"澎湖", 23.57, 119.58, "Asia/Taipei"},
+		{"金門", 24.43, 118.32, "Asia/Taipei"},
+		{"馬祖", 26.16, 119.95, "Asia/Taipei"},
+		{"香港", 22.30, 114.17, "Asia/Hong_Kong"},
+		{"澳門", 22.19, 113.54, "Asia/Macau"},
+		{"東京", 35.68, 139.70, "Asia/Tokyo"},
+		{"那霸", 26.21, 127.68, "Asia/Tokyo"},
+		{"札幌", 43.06, 141.35, "Asia/Tokyo"},
+		{"福岡", 33.59, 130.40, "Asia/Tokyo"},
+		{"對馬", 34.40, 129.30, "Asia/Tokyo"},
+		{"首爾", 37.57, 126.98, "Asia/Seoul"},
+		{"釜山", 35.10, 129.04, "Asia/Seoul"},
+		{"濟州", 33.50, 126.53, "Asia/Seoul"},
+		{"平壤", 39.03, 125.75, "Asia/Pyongyang"},
+		{"烏蘭巴托", 47.92, 106.92, "Asia/Ulaanbaatar"},
+		{"額爾登特", 49.03, 104.08, "Asia/Ulaanbaatar"},
+		{"喬巴山", 48.07, 114.50, "Asia/Ulaanbaatar"},
+		{"科布多", 48.00, 91.64, "Asia/Hovd"},
+		{"上海", 31.23, 121.47, "Asia/Shanghai"},
+		{"北京", 39.90, 116.40, "Asia/Shanghai"},
+		{"廣州", 23.13, 113.26, "Asia/Shanghai"},
+		{"廈門", 24.48, 118.09, "Asia/Shanghai"},
+		{"福州", 26.07, 119.30, "Asia/Shanghai"},
+		{"三亞", 18.25, 109.50, "Asia/Shanghai"},
+		{"北海", 21.48, 109.12, "Asia/Shanghai"},
+		{"昆明", 25.04, 102.71, "Asia/Shanghai"},
+		{"景洪", 22.00, 100.80, "Asia/Shanghai"},
+		{"成都", 30.57, 104.07, "Asia/Shanghai"},
+		{"拉薩", 29.65, 91.12, "Asia/Shanghai"},
+		{"林芝", 29.65, 94.36, "Asia/Shanghai"},
+		{"獅泉河", 32.50, 80.10, "Asia/Shanghai"},
+		{"喀什", 39.47, 75.99, "Asia/Shanghai"},
+		{"伊寧", 43.90, 81.30, "Asia/Shanghai"},
+		{"烏魯木齊", 43.83, 87.62, "Asia/Shanghai"},
+		{"二連浩特", 43.65, 111.98, "Asia/Shanghai"},
+		{"呼和浩特", 40.84, 111.75, "Asia/Shanghai"},
+		{"海拉爾", 49.21, 119.74, "Asia/Shanghai"},
+		{"漠河", 52.97, 122.54, "Asia/Shanghai"},
+		{"哈爾濱", 45.80, 126.53, "Asia/Shanghai"},
+		{"延吉", 42.90, 129.50, "Asia/Shanghai"},
+		{"丹東", 40.12, 124.38, "Asia/Shanghai"},
+		{"大連", 38.91, 121.60, "Asia/Shanghai"},
+		{"威海", 37.51, 122.12, "Asia/Shanghai"},
+	}
+	for _, test := range tests {
+		if got, ok := timezoneForLocation(test.lat, test.long); !ok || got != test.want {
+			t.Errorf("%s (%v, %v): got %s, want %s", test.place, test.lat, test.long, got, test.want)
+		}
+	}
+}
+
+// 已知區域以外的地方不猜時區，沿用個人資料或使用者設定的時區
+func TestUpdateUserTimezoneOutsideRegions(t *testing.T) {
+	tests := []struct {
+		place     string
+		lat, long float64
+		zone      string
+	}{
+		{"河內", 21.03, 105.85, "Asia/Bangkok"},
+		{"胡志明市", 10.82, 106.63, "Asia/Ho_Chi_Minh"},
+		{"永珍", 17.97, 102.60, "Asia/Bangkok"},
+		{"清邁", 18.79, 98.98, "Asia/Bangkok"},
+		{"密支那", 25.38, 97.40, "Asia/Yangon"},
+		{"達卡", 23.81, 90.41, "Asia/Dhaka"},
+		{"廷布", 27.47, 89.64, "Asia/Thimphu"},
+		{"加德滿都", 27.70, 85.30, "Asia/Kathmandu"},
+		{"德里", 28.61, 77.21, "Asia/Kolkata"},
+		{"列城", 34.15, 77.58, "Asia/Kolkata"},
+		{"伊斯蘭堡", 33.69, 73.06, "Asia/Karachi"},
+		{"阿拉木圖", 43.24, 76.89, "Asia/Almaty"},
+		{"伊爾庫次克", 52.29, 104.30, "Asia/Irkutsk"},
+		{"赤塔", 52.03, 113.50, "Asia/Chita"},
+		{"海參崴", 43.12, 131.89, "Asia/Vladivostok"},
+		{"伯力", 48.48, 135.07, "Asia/Vladivostok"},
+		{"倫敦", 51.51, -0.13, "Europe/London"},
+		{"紐約", 40.71, -74.01, "America/New_York"},
+	}
+	for _, test := range tests {
+		if got, ok := timezoneForLocation(test.lat, test.long); ok {
+			t.Errorf("%s (%v, %v): should not guess a timezone, got %s", test.place, test.lat, test.long, got)
+		}
+
+		user := &User{Timezone: test.zone}
+		updateUserTimezone(user, test.lat, test.long)
+		if user.Timezone != test.zone {
+			t.Errorf("%s: timezone changed from %s to %s", test.place, test.zone, user.Timezone)
+		}
+	}
+
+	// 還沒有時區的使用者用預設時區
+	user := &User{}
+	updateUserTimezone(user, 51.51, -0.13)
+	if user.Timezone != "" || userLocation(user).String() != DEFAULT_TIMEZONE {
+		t.Errorf("got timezone %q, location %s", user.Timezone, userLocation(user))
+	}
+
+	// 進入已知區域時換成該區的時區
+	user = &User{Timezone: "Asia/Irkutsk"}
+	updateUserTimezone(user, 35.68, 139.70)
+	if user.Timezone != "Asia/Tokyo" {
+		t.Errorf("got %s, want Asia/Tokyo", user.Timezone)
+	}
+}
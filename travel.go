@@ -0,0 +1,80 @@
+package pokedict
+
+import (
+	"sort"
+	"time"
+)
+
+const (
+	TRAVEL_WALK = "walk"
+	TRAVEL_BIKE = "bike"
+)
+
+// 平均移動速度 (km/h)
+var travelSpeeds map[string]float64 = map[string]float64{
+	TRAVEL_WALK: 5,
+	TRAVEL_BIKE: 15,
+}
+
+// 對應訊息目錄裡的名稱
+var travelModeNames map[string]string = map[string]string{
+	TRAVEL_WALK: "travel_walk",
+	TRAVEL_BIKE: "travel_bike",
+}
+
+// 實際路線通常比直線距離長
+const routeFactor = 1.3
+
+func travelSpeed(mode string) float64 {
+	if speed, ok := travelSpeeds[mode]; ok {
+		return speed
+	}
+	return travelSpeeds[TRAVEL_WALK]
+}
+
+func travelModeName(lang, mode string) string {
+	if key, ok := travelModeNames[mode]; ok {
+		return tr(lang, key)
+	}
+	return tr(lang, travelModeNames[TRAVEL_WALK])
+}
+
+func annotateTravel(pins []PokemonPin, mode string, now time.Time) {
+	speed := travelSpeed(mode)
+	for i := range pins {
+		p := &pins[i]
+		hours := p.Distance * routeFactor / speed
+		p.TravelTime = time.Duration(hours*3600) * time.Second
+		arrival := now.Add(p.TravelTime)
+		p.ArrivalTime = arrival.Unix() * 1000
+		p.Reachable = arrival.Before(time.Unix(p.DisappearTime/1000, 0))
+	}
+}
+
+type pinsByReachability []PokemonPin
+
+func (p pinsByReachability) Len() int      { return len(p) }
+func (p pinsByReachability) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p pinsByReachability) Less(i, j int) bool {
+	if p[i].Reachable != p[j].Reachable {
+		return p[i].Reachable
+	}
+	return p[i].Distance < p[j].Distance
+}
+
+// 來得及的排在前面，來不及的往後擺
+func sortByReachability(pins []PokemonPin) {
+	sort.Stable(pinsByReachability(pins))
+}
+
+func formatTravel(lang string, p PokemonPin, mode string) string {
+	status := tr(lang, "unreachable")
+	if p.Reachable {
+		status = tr(lang, "reachable")
+	}
+	minutes := int(p.TravelTime.Minutes() + 0.5)
+	if minutes < 1 {
+		minutes = 1
+	}
+	return tr(lang, "travel", travelModeName(lang, mode), minutes, status)
+}
@@ -0,0 +1,116 @@
+package pokedict
+
+import (
+	"math"
+	"strings"
+)
+
+const (
+	SUPER_EFFECTIVE = 1
+	NOT_EFFECTIVE   = -1
+	IMMUNE          = -2
+
+	// 遊戲中每一級效果的倍率，免疫視為兩級「效果不好」
+	effectivenessBase = 1.6
+	stabMultiplier    = 1.2
+)
+
+var allTypes []string = []string{
+	"Normal", "Fire", "Water", "Electric", "Grass", "Ice", "Fighting", "Poison", "Ground",
+	"Flying", "Psychic", "Bug", "Rock", "Ghost", "Dragon", "Dark", "Steel", "Fairy",
+}
+
+// 攻擊屬性 -> 防守屬性 -> 效果等級，沒列出的都是一般效果
+var typeChart map[string]map[string]int = map[string]map[string]int{
+	"Normal": {"Rock": NOT_EFFECTIVE, "Ghost": IMMUNE, "Steel": NOT_EFFECTIVE},
+	"Fire": {"Fire": NOT_EFFECTIVE, "Water": NOT_EFFECTIVE, "Grass": SUPER_EFFECTIVE, "Ice": SUPER_EFFECTIVE,
+		"Bug": SUPER_EFFECTIVE, "Rock": NOT_EFFECTIVE, "Dragon": NOT_EFFECTIVE, "Steel": SUPER_EFFECTIVE},
+	"Water": {"Fire": SUPER_EFFECTIVE, "Water": NOT_EFFECTIVE, "Grass": NOT_EFFECTIVE, "Ground": SUPER_EFFECTIVE,
+		"Rock": SUPER_EFFECTIVE, "Dragon": NOT_EFFECTIVE},
+	"Electric": {"Water": SUPER_EFFECTIVE, "Electric": NOT_EFFECTIVE, "Grass": NOT_EFFECTIVE, "Ground": IMMUNE,
+		"Flying": SUPER_EFFECTIVE, "Dragon": NOT_EFFECTIVE},
+	"Grass": {"Fire": NOT_EFFECTIVE, "Water": SUPER_EFFECTIVE, "Grass": NOT_EFFECTIVE, "Poison": NOT_EFFECTIVE,
+		"Ground": SUPER_EFFECTIVE, "Flying": NOT_EFFECTIVE, "Bug": NOT_EFFECTIVE, "Rock": SUPER_EFFECTIVE,
+		"Dragon": NOT_EFFECTIVE, "Steel": NOT_EFFECTIVE},
+	"Ice": {"Fire": NOT_EFFECTIVE, "Water": NOT_EFFECTIVE, "Grass": SUPER_EFFECTIVE, "Ice": NOT_EFFECTIVE,
+		"Ground": SUPER_EFFECTIVE, "Flying": SUPER_EFFECTIVE, "Dragon": SUPER_EFFECTIVE, "Steel": NOT_EFFECTIVE},
+	"Fighting": {"Normal": SUPER_EFFECTIVE, "Ice": SUPER_EFFECTIVE, "Poison": NOT_EFFECTIVE, "Flying": NOT_EFFECTIVE,
+		"Psychic": NOT_EFFECTIVE, "Bug": NOT_EFFECTIVE, "Rock": SUPER_EFFECTIVE, "Ghost": IMMUNE,
+		"Dark": SUPER_EFFECTIVE, "Steel": SUPER_EFFECTIVE, "Fairy": NOT_EFFECTIVE},
+	"Poison": {"Grass": SUPER_EFFECTIVE, "Poison": NOT_EFFECTIVE, "Ground": NOT_EFFECTIVE, "Rock": NOT_EFFECTIVE,
+		"Ghost": NOT_EFFECTIVE, "Steel": IMMUNE, "Fairy": SUPER_EFFECTIVE},
+	"Ground": {"Fire": SUPER_EFFECTIVE, "Electric": SUPER_EFFECTIVE, "Grass": NOT_EFFECTIVE, "Poison": SUPER_EFFECTIVE,
+		"Flying": IMMUNE, "Bug": NOT_EFFECTIVE, "Rock": SUPER_EFFECTIVE, "Steel": SUPER_EFFECTIVE},
+	"Flying": {"Electric": NOT_EFFECTIVE, "Grass": SUPER_EFFECTIVE, "Fighting": SUPER_EFFECTIVE, "Bug": SUPER_EFFECTIVE,
+		"Rock": NOT_EFFECTIVE, "Steel": NOT_EFFECTIVE},
+	"Psychic": {"Fighting": SUPER_EFFECTIVE, "Poison": SUPER_EFFECTIVE, "Psychic": NOT_EFFECTIVE, "Dark": IMMUNE,
+		"Steel": NOT_EFFECTIVE},
+	"Bug": {"Fire": NOT_EFFECTIVE, "Grass": SUPER_EFFECTIVE, "Fighting": NOT_EFFECTIVE, "Poison": NOT_EFFECTIVE,
+		"Flying": NOT_EFFECTIVE, "Psychic": SUPER_EFFECTIVE, "Ghost": NOT_EFFECTIVE, "Dark": SUPER_EFFECTIVE,
+		"Steel": NOT_EFFECTIVE, "Fairy": NOT_EFFECTIVE},
+	"Rock": {"Fire": SUPER_EFFECTIVE, "Ice": SUPER_EFFECTIVE, "Fighting": NOT_EFFECTIVE, "Ground": NOT_EFFECTIVE,
+		"Flying": SUPER_EFFECTIVE, "Bug": SUPER_EFFECTIVE, "Steel": NOT_EFFECTIVE},
+	"Ghost":  {"Normal": IMMUNE, "Psychic": SUPER_EFFECTIVE, "Ghost": SUPER_EFFECTIVE, "Dark": NOT_EFFECTIVE},
+	"Dragon": {"Dragon": SUPER_EFFECTIVE, "Steel": NOT_EFFECTIVE, "Fairy": IMMUNE},
+	"Dark": {"Fighting": NOT_EFFECTIVE, "Psychic": SUPER_EFFECTIVE, "Ghost": SUPER_EFFECTIVE, "Dark": NOT_EFFECTIVE,
+		"Fairy": NOT_EFFECTIVE},
+	"Steel": {"Fire": NOT_EFFECTIVE, "Water": NOT_EFFECTIVE, "Electric": NOT_EFFECTIVE, "Ice": SUPER_EFFECTIVE,
+		"Rock": SUPER_EFFECTIVE, "Steel": NOT_EFFECTIVE, "Fairy": SUPER_EFFECTIVE},
+	"Fairy": {"Fire": NOT_EFFECTIVE, "Fighting": SUPER_EFFECTIVE, "Poison": NOT_EFFECTIVE, "Dragon": SUPER_EFFECTIVE,
+		"Dark": SUPER_EFFECTIVE, "Steel": NOT_EFFECTIVE},
+}
+
+// 技能資料用的是 "Fight"，寵物資料用的是 "Fighting"
+func normalizeType(t string) string {
+	t = strings.TrimSpace(t)
+	if strings.EqualFold(t, "Fight") {
+		return "Fighting"
+	}
+	for _, name := range allTypes {
+		if strings.EqualFold(t, name) {
+			return name
+		}
+	}
+	return t
+}
+
+func effectivenessLevel(attackType string, defenseTypes ...string) (level int) {
+	chart := typeChart[normalizeType(attackType)]
+	for _, t := range defenseTypes {
+		if t == "" {
+			continue
+		}
+		level += chart[normalizeType(t)]
+	}
+	return
+}
+
+func typeEffectiveness(attackType string, defenseTypes ...string) float64 {
+	return math.Pow(effectivenessBase, float64(effectivenessLevel(attackType, defenseTypes...)))
+}
+
+func pokemonTypes(p Pokemon) []string {
+	if p.TypeII == "" {
+		return []string{normalizeType(p.TypeI)}
+	}
+	return []string{normalizeType(p.TypeI), normalizeType(p.TypeII)}
+}
+
+func isSameType(p Pokemon, skillType string) bool {
+	t := normalizeType(skillType)
+	for _, pt := range pokemonTypes(p) {
+		if pt == t {
+			return true
+		}
+	}
+	return false
+}
+
+// 技能打在某隻寵物身上的倍率 (屬性一致加成 x 屬性相剋)
+func moveMultiplier(attacker Pokemon, skill PokemonSkill, defender Pokemon) float64 {
+	m := typeEffectiveness(skill.Type, pokemonTypes(defender)...)
+	if isSameType(attacker, skill.Type) {
+		m *= stabMultiplier
+	}
+	return m
+}
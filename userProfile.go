@@ -0,0 +1,107 @@
+package pokedict
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/log"
+)
+
+const (
+	FB_PROFILE_USER_FIELDS = "first_name,last_name,locale,timezone"
+
+	// 成功後定期更新，失敗時過一陣子再試
+	profileRefreshInterval = 30 * 24 * time.Hour
+	profileRetryInterval   = 1 * time.Hour
+)
+
+type FBUserProfile struct {
+	FirstName string  `json:"first_name"`
+	LastName  string  `json:"last_name"`
+	Locale    string  `json:"locale"`
+	Timezone  float64 `json:"timezone"`
+}
+
+func (c *MessagingClient) GetFBUserProfile(ctx context.Context, userId int64) (profile FBUserProfile, err error) {
+	token, _ := pageToken(pageFromContext(ctx))
+	v := url.Values{}
+	v.Set("fields", FB_PROFILE_USER_FIELDS)
+	v.Set("access_token", token)
+	uri := fmt.Sprintf("%s/%d?%s", FB_GRAPH_ROOT, userId, v.Encode())
+
+	body, err := c.do(ctx, PLATFORM_FB, strconv.FormatInt(userId, 10), func() (*http.Request, error) {
+		return http.NewRequest("GET", uri, nil)
+	})
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(body, &profile)
+	return
+}
+
+// 把 Messenger 的時差 (小時) 轉成時區名稱
+func timezoneForOffset(offset float64) string {
+	if offset != float64(int(offset)) {
+		return ""
+	}
+	if offset == 0 {
+		return "Etc/UTC"
+	}
+	return fmt.Sprintf("Etc/GMT%+d", -int(offset))
+}
+
+func applyFBUserProfile(user *User, profile FBUserProfile) {
+	user.FirstName = profile.FirstName
+	user.Locale = profile.Locale
+	// 位置推算或使用者設定的時區比較準
+	if user.Timezone == "" && !user.TimezoneFixed {
+		user.Timezone = timezoneForOffset(profile.Timezone)
+	}
+}
+
+// 第一次互動時抓使用者資料，失敗時沿用預設值
+func ensureUserProfile(ctx context.Context, user *User) {
+	now := time.Now()
+	checkedAt := time.Unix(user.ProfileCheckedAt, 0)
+	if user.ProfileCheckedAt != 0 {
+		interval := profileRefreshInterval
+		if user.FirstName == "" && user.Locale == "" {
+			interval = profileRetryInterval
+		}
+		if now.Sub(checkedAt) < interval {
+			return
+		}
+	}
+	user.ProfileCheckedAt = now.Unix()
+
+	profile, err := newMessagingClient(ctx).GetFBUserProfile(ctx, user.Id)
+	if err != nil {
+		log.Warningf(ctx, "get profile of %d: %s", user.Id, err)
+		return
+	}
+	applyFBUserProfile(user, profile)
+}
+
+// 使用者自己選的語言優先，其次是 Messenger 的 locale
+func userLanguage(user *User) string {
+	if user == nil {
+		return DEFAULT_LANGUAGE
+	}
+	if user.Language != "" {
+		return user.Language
+	}
+	return languageForLocale(user.Locale)
+}
+
+func welcomeText(user *User) string {
+	lang := userLanguage(user)
+	if user == nil || user.FirstName == "" {
+		return tr(lang, "welcome")
+	}
+	return tr(lang, "welcome_named", user.FirstName)
+}
@@ -0,0 +1,158 @@
+package pokedict
+
+import (
+	"strconv"
+	"sync"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+// UserStore 保存使用者狀態，Load 拿到的是複本
+// 要修改時用 Update，同一個使用者的修改會依序執行，不會互相蓋掉
+// update 回傳錯誤或 panic 時，已經做的修改一樣會保存
+type UserStore interface {
+	Load(ctx context.Context, id int64) (*User, error)
+	Update(ctx context.Context, id int64, update func(*User) error) error
+}
+
+// 存取使用者狀態失敗時讓 task queue 重試
+type StoreError struct {
+	Err error
+}
+
+func (e *StoreError) Error() string {
+	return "user store: " + e.Err.Error()
+}
+
+func (e *StoreError) Temporary() bool {
+	return true
+}
+
+type userLock struct {
+	sync.Mutex
+	// 正在等待或持有這個鎖的數量，歸零時從 map 移除
+	refs int
+}
+
+// 每個使用者一個鎖，只在 instance 內有效
+type userLocks struct {
+	mu    sync.Mutex
+	locks map[int64]*userLock
+}
+
+func (s *userLocks) lock(id int64) *userLock {
+	s.mu.Lock()
+	if s.locks == nil {
+		s.locks = map[int64]*userLock{}
+	}
+	l, ok := s.locks[id]
+	if !ok {
+		l = &userLock{}
+		s.locks[id] = l
+	}
+	l.refs++
+	s.mu.Unlock()
+
+	l.Lock()
+	return l
+}
+
+func (s *userLocks) unlock(id int64, l *userLock) {
+	l.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l.refs--
+	if l.refs == 0 {
+		delete(s.locks, id)
+	}
+}
+
+func copyUser(u User) User {
+	if u.FollowedPokemonId != nil {
+		u.FollowedPokemonId = append([]int64{}, u.FollowedPokemonId...)
+	}
+	return u
+}
+
+// LocalUserStore 把使用者狀態放在 instance 的記憶體裡，給單機執行和測試用
+type LocalUserStore struct {
+	userLocks
+	mu    sync.RWMutex
+	users map[int64]User
+}
+
+func NewLocalUserStore() *LocalUserStore {
+	return &LocalUserStore{users: map[int64]User{}}
+}
+
+func (s *LocalUserStore) Load(ctx context.Context, id int64) (*User, error) {
+	s.mu.RLock()
+	u, ok := s.users[id]
+	s.mu.RUnlock()
+
+	if !ok {
+		return &User{Id: id}, nil
+	}
+	u = copyUser(u)
+	return &u, nil
+}
+
+func (s *LocalUserStore) save(u *User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[u.Id] = copyUser(*u)
+}
+
+func (s *LocalUserStore) Update(ctx context.Context, id int64, update func(*User) error) error {
+	l := s.lock(id)
+	defer s.unlock(id, l)
+
+	u, _ := s.Load(ctx, id)
+	defer s.save(u)
+	return update(u)
+}
+
+// 事件可能由任何一個 instance 處理，使用者狀態要存在 datastore 才不會在兩個事件之間遺失
+// 同一個使用者的事件由 eventOrder 保證一次只處理一個，這裡的鎖只防止同一個 instance 內同時修改
+type DatastoreUserStore struct {
+	locks *userLocks
+}
+
+func NewDatastoreUserStore() DatastoreUserStore {
+	return DatastoreUserStore{locks: &userLocks{}}
+}
+
+func userKey(ctx context.Context, id int64) *datastore.Key {
+	// LINE 的使用者 id 是負數，datastore 的數字 id 不能用
+	return datastore.NewKey(ctx, "User", strconv.FormatInt(id, 10), 0, nil)
+}
+
+func (s DatastoreUserStore) Load(ctx context.Context, id int64) (*User, error) {
+	u := &User{}
+	err := datastore.Get(ctx, userKey(ctx, id), u)
+	if err == datastore.ErrNoSuchEntity {
+		return &User{Id: id}, nil
+	} else if err != nil {
+		return nil, &StoreError{err}
+	}
+	u.Id = id
+	return u, nil
+}
+
+func (s DatastoreUserStore) Update(ctx context.Context, id int64, update func(*User) error) (err error) {
+	l := s.locks.lock(id)
+	defer s.locks.unlock(id, l)
+
+	u, err := s.Load(ctx, id)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if _, putErr := datastore.Put(ctx, userKey(ctx, id), u); putErr != nil && err == nil {
+			err = &StoreError{putErr}
+		}
+	}()
+	return update(u)
+}
@@ -0,0 +1,174 @@
+package pokedict
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestUserStoreUpdate(t *testing.T) {
+	local, ds := NewLocalUserStore(), NewDatastoreUserStore()
+	for _, c := range []struct {
+		name  string
+		store UserStore
+		locks *userLocks
+	}{
+		{"local", local, &local.userLocks},
+		{"datastore", ds, ds.locks},
+	} {
+		// LINE 的使用者 id 是負數
+		const id = -1
+		var wg sync.WaitGroup
+		for i := 0; i < 100; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				c.store.Update(testCtx, id, func(u *User) error {
+					u.FollowedPokemonId = append(u.FollowedPokemonId, int64(i))
+					return nil
+				})
+			}(i)
+		}
+		wg.Wait()
+
+		u, err := c.store.Load(testCtx, id)
+		if err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+		if n := len(u.FollowedPokemonId); n != 100 || u.Id != id {
+			t.Errorf("%s: got user %d with %d updates, want 100", c.name, u.Id, n)
+		}
+		if len(c.locks.locks) != 0 {
+			t.Errorf("%s: %d user locks are not released", c.name, len(c.locks.locks))
+		}
+	}
+}
+
+// 所有請求都回成功，稍微延遲讓同時處理的事件交錯
+type okTransport struct{}
+
+func (okTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	time.Sleep(time.Millisecond)
+	return &http.Response{
+		StatusCode: 200,
+		Header:     http.Header{},
+		Body:       ioutil.NopCloser(strings.NewReader(`{"ok":true,"result":{}}`)),
+	}, nil
+}
+
+// 像 push queue 一樣在背景把 task 送給 eventTaskHandler，失敗時稍後重試
+type pushQueue struct {
+	t  *testing.T
+	wg sync.WaitGroup
+}
+
+func (q *pushQueue) Enqueue(ctx context.Context, event QueuedEvent) error {
+	b, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	q.wg.Add(1)
+	go func() {
+		defer q.wg.Done()
+		for retry := 0; ; retry++ {
+			r, err := testInstance.NewRequest("POST", EVENT_TASK_PATH, bytes.NewReader(b))
+			if err != nil {
+				q.t.Error(err)
+				return
+			}
+			r.Header.Set("X-AppEngine-QueueName", EVENT_QUEUE)
+			w := httptest.NewRecorder()
+			eventTaskHandler(w, r)
+			if w.Code == http.StatusOK {
+				return
+			}
+			if w.Code != http.StatusServiceUnavailable || retry == 1000 {
+				q.t.Errorf("%s event %d: got status %d", event.Platform, event.Seq, w.Code)
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	return nil
+}
+
+// 同時送出 webhook 請求，用 go test -race 執行
+func TestConcurrentHandlers(t *testing.T) {
+	defer func(q EventQueue, o EventOrder, tr func(ctx context.Context) http.RoundTripper, l *recipientLimiter, d DedupStore, u UserStore, tokens map[int64]string) {
+		eventQueue, eventOrder, messagingTransport, sendLimiter, dedupStore, users, pageTokens = q, o, tr, l, d, u, tokens
+	}(eventQueue, eventOrder, messagingTransport, sendLimiter, dedupStore, users, pageTokens)
+	queue := &pushQueue{t: t}
+	eventQueue = queue
+	eventOrder = DatastoreEventOrder{}
+	messagingTransport = func(ctx context.Context) http.RoundTripper { return okTransport{} }
+	sendLimiter = newRecipientLimiter(0)
+	dedupStore = NewLocalDedupStore()
+	users = NewDatastoreUserStore()
+	pageTokens = map[int64]string{1001: "token-1001"}
+
+	const fbUser, tgChat = 3001, 4001
+	type webhook struct {
+		handler http.HandlerFunc
+		path    string
+		body    interface{}
+	}
+	fbWebhook := func(fbMsg FBMessage) webhook {
+		return webhook{fbCBPostHandler, "/fbCallback", FBObject{
+			Object: "page",
+			Entry:  []FBEntry{{Id: "1001", Messaging: []FBMessage{fbMsg}}},
+		}}
+	}
+
+	webhooks := []webhook{}
+	for i, text := range []string{"騎車", "查技能"} {
+		webhooks = append(webhooks, fbWebhook(FBMessage{Sender: FBSender{fbUser}, Content: &FBMessageContent{Seq: int64(i + 1), Text: text}}))
+	}
+	for i := 1; i <= 50; i++ {
+		webhooks = append(webhooks, fbWebhook(FBMessage{Sender: FBSender{fbUser}, Delivery: &FBMessageDelivery{Watermark: int64(i)}}))
+		tgEntry := TGEntry{Id: int64(i), Message: TGMessage{Chat: TGChat{Id: tgChat}, Text: "Bite"}}
+		webhooks = append(webhooks, webhook{tgCBHandler, "/tgCallback", tgEntry})
+	}
+
+	var wg sync.WaitGroup
+	for _, hook := range webhooks {
+		b, err := json.Marshal(hook.body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := newTestRequest(t, "POST", hook.path, bytes.NewReader(b))
+		wg.Add(1)
+		go func(hook webhook, r *http.Request) {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			hook.handler(w, r)
+			if w.Code != http.StatusOK {
+				t.Errorf("%s: got status %d", hook.path, w.Code)
+			}
+		}(hook, r)
+	}
+	wg.Wait()
+	queue.wg.Wait()
+
+	// 文字訊息和 delivery 同時處理時，彼此的修改都要保留
+	u, err := users.Load(testCtx, fbUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.DeliveredWatermark != 50 {
+		t.Errorf("got watermark %d, want 50", u.DeliveredWatermark)
+	}
+	if u.TravelMode != TRAVEL_BIKE {
+		t.Errorf("got travel mode %q, want %q", u.TravelMode, TRAVEL_BIKE)
+	}
+	if u.ProfileCheckedAt == 0 {
+		t.Error("profile was not checked")
+	}
+}